@@ -0,0 +1,336 @@
+// Package backtest evaluates forecasts of a GP on historical
+// data. The data are cut at a sequence of points; at each cut
+// point, the GP observes a window of the preceding data, either
+// all of them or a fixed number of the most recent ones, and
+// forecasts the outputs at several horizons after the cut. The
+// hyperparameters are refit every few cut points, and reused in
+// between. Cut points are evaluated in parallel.
+package backtest
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/metrics"
+	"bitbucket.org/dtolpin/infergo/model"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/optimize"
+	"gonum.org/v1/gonum/stat"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// Type Fit fits the hyperparameters of GP g to its observations,
+// starting from theta, in the unconstrained space of the
+// arguments of g.Observe, and returns the fitted values. On
+// return, the hyperparameters of g must be set to the fitted
+// values, as by g.Observe. An error means that the fit is
+// unreliable; the forecasts are still made. Random choices of the
+// fit, such as of restarts, must be drawn from rng.
+type Fit func(g *gp.GP, theta []float64, rng *rand.Rand) ([]float64, error)
+
+// Type Config is the configuration of a backtest.
+type Config struct {
+	// New returns a GP with the kernels and the options, but
+	// without observations; each worker creates its own GP.
+	New func() *gp.GP
+	// Fit fits the hyperparameters, LBFGS when nil.
+	Fit Fit
+	// Initial values of the hyperparameters, zeros when nil.
+	// Every fit starts from Theta, hence the cut points are
+	// independent.
+	Theta []float64
+	// Min is the first cut point, the number of observations
+	// before it, at least 1.
+	Min int
+	// Step is the distance between cut points, 1 by default.
+	Step int
+	// Window is the number of most recent observations the GP
+	// observes at a cut point; all observations when 0.
+	Window int
+	// Refit is the number of cut points between fits, 1 by
+	// default, that is, the hyperparameters are fit at every
+	// cut point.
+	Refit int
+	// Horizons are the forecast horizons, in rows after the cut
+	// point, from 1; {1} by default.
+	Horizons []int
+	// Normalize the outputs of each window by their mean and
+	// standard deviation.
+	Normalize bool
+	// Workers is the number of parallel workers, GOMAXPROCS
+	// when 0.
+	Workers int
+	// Seed of the random sources of the fits; a fit has its own
+	// source, hence the forecasts do not depend on the number of
+	// workers.
+	Seed int64
+}
+
+// Type Forecast is a forecast at a horizon from a cut point.
+type Forecast struct {
+	Cut     int // the index of the first row after the window
+	Horizon int
+	Index   int // of the forecast row, Cut + Horizon - 1
+	X       []float64
+	Y       float64 // observed
+	Mu      float64
+	Sigma   float64
+	Mean    float64   // of the outputs in the window,
+	Std     float64   // the trivial forecast, see metrics.SLL
+	LML     float64   // log marginal likelihood of the window
+	Refit   bool      // the hyperparameters were fit at the cut
+	Theta   []float64 // the hyperparameters, as in GP.ThetaSimil
+	FitErr  error     // reported by Fit, see Fit
+}
+
+// Type Result is the result of a backtest.
+type Result struct {
+	Names     []string   // of the hyperparameters
+	Forecasts []Forecast // by cut point, then as in Horizons
+}
+
+// Horizon returns the forecasts at horizon h.
+func (r *Result) Horizon(h int) []Forecast {
+	var fs []Forecast
+	for _, f := range r.Forecasts {
+		if f.Horizon == h {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// Summary returns the summary of the scores of the forecasts at
+// horizon h, with coverage at the levels and a PIT histogram of
+// bins (see metrics.NewScores).
+func (r *Result) Summary(h int, levels []float64, bins int) metrics.Summary {
+	s := metrics.NewScores(levels, bins)
+	for _, f := range r.Horizon(h) {
+		s.Add(f.Y, f.Mu, f.Sigma, f.Mean, f.Std)
+	}
+	return s.Summary()
+}
+
+// defaults fills the defaults of the configuration.
+func (c *Config) defaults() error {
+	if c.New == nil {
+		return errors.New("no GP constructor")
+	}
+	if c.Fit == nil {
+		c.Fit = LBFGS
+	}
+	if c.Min < 1 {
+		c.Min = 1
+	}
+	if c.Step < 1 {
+		c.Step = 1
+	}
+	if c.Refit < 1 {
+		c.Refit = 1
+	}
+	if len(c.Horizons) == 0 {
+		c.Horizons = []int{1}
+	}
+	for _, h := range c.Horizons {
+		if h < 1 {
+			return fmt.Errorf("horizon %d, must be at least 1", h)
+		}
+	}
+	if c.Window < 0 {
+		return fmt.Errorf("window %d, must not be negative", c.Window)
+	}
+	if c.Workers < 1 {
+		c.Workers = runtime.GOMAXPROCS(0)
+	}
+	return nil
+}
+
+// Run runs a backtest on inputs x and outputs y, ordered by
+// time. Blocks of cut points are evaluated concurrently; the GPs
+// serialize their calls through the automatic differentiation
+// tape (see package gp), but a Fit using models other than the
+// GP must be thread-safe itself, and must not drop the tapes
+// (ad.DropAllTapes) of other workers.
+func Run(c Config, x [][]float64, y []float64) (*Result, error) {
+	if err := c.defaults(); err != nil {
+		return nil, err
+	}
+	if len(x) != len(y) {
+		return nil, fmt.Errorf("%d inputs, %d outputs", len(x), len(y))
+	}
+
+	// Cut points, in blocks between fits
+	hmin := c.Horizons[0]
+	for _, h := range c.Horizons {
+		hmin = min(hmin, h)
+	}
+	var cuts []int
+	for cut := c.Min; cut+hmin-1 < len(y); cut += c.Step {
+		cuts = append(cuts, cut)
+	}
+	if len(cuts) == 0 {
+		return nil, errors.New("no cut points, too few data")
+	}
+	nblocks := (len(cuts) + c.Refit - 1) / c.Refit
+	blocks := make([][]Forecast, nblocks)
+
+	// Workers evaluate blocks, each with its own GP.
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		names    []string
+	)
+	jobs := make(chan int)
+	for w := 0; w != min(c.Workers, nblocks); w++ {
+		g := c.New()
+		if w == 0 {
+			names = g.Names()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ib := range jobs {
+				i1 := min((ib+1)*c.Refit, len(cuts))
+				rng := rand.New(rand.NewSource(c.Seed + int64(ib)))
+				fs, err := c.block(g, rng, cuts[ib*c.Refit:i1], x, y)
+				if err != nil {
+					once.Do(func() { firstErr = err })
+					continue
+				}
+				blocks[ib] = fs
+			}
+		}()
+	}
+	for ib := range blocks {
+		jobs <- ib
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	r := &Result{Names: names}
+	for _, fs := range blocks {
+		r.Forecasts = append(r.Forecasts, fs...)
+	}
+	return r, nil
+}
+
+// block evaluates the cut points of a block, fitting the
+// hyperparameters at the first cut point.
+func (c *Config) block(
+	g *gp.GP,
+	rng *rand.Rand,
+	cuts []int,
+	x [][]float64,
+	y []float64,
+) (fs []Forecast, err error) {
+	var fitErr error
+	for i, cut := range cuts {
+		start := 0
+		if c.Window > 0 {
+			start = max(cut-c.Window, 0)
+		}
+		mean, std := normalization(y[start:cut], c.Normalize)
+		var tmean, tstd float64
+		if cut-start > 1 {
+			tmean, tstd = stat.MeanStdDev(y[start:cut], nil)
+		}
+		ny := make([]float64, cut-start)
+		for j := range ny {
+			ny[j] = (y[start+j] - mean) / std
+		}
+
+		if i == 0 {
+			theta := c.Theta
+			if theta == nil {
+				theta = make([]float64, len(g.Names()))
+			}
+			g.X, g.Y = x[start:cut], ny
+			_, fitErr = c.Fit(g, append([]float64{}, theta...), rng)
+		} else if err := g.Absorb(x[start:cut], ny); err != nil {
+			return nil, fmt.Errorf("cut %d: %v", cut, err)
+		}
+
+		var z [][]float64
+		var hs []int
+		for _, h := range c.Horizons {
+			if cut+h-1 < len(y) {
+				z = append(z, x[cut+h-1])
+				hs = append(hs, h)
+			}
+		}
+		mu, sigma, err := g.Produce(z)
+		if err != nil {
+			return nil, fmt.Errorf("cut %d: %v", cut, err)
+		}
+		theta := append(append([]float64{}, g.ThetaSimil...), g.ThetaNoise...)
+		for j, h := range hs {
+			fs = append(fs, Forecast{
+				Cut:     cut,
+				Horizon: h,
+				Index:   cut + h - 1,
+				X:       z[j],
+				Y:       y[cut+h-1],
+				Mu:      mu[j]*std + mean,
+				Sigma:   sigma[j] * std,
+				Mean:    tmean,
+				Std:     tstd,
+				LML:     g.LML(),
+				Refit:   i == 0,
+				Theta:   theta,
+				FitErr:  fitErr,
+			})
+		}
+	}
+	return fs, nil
+}
+
+// normalization returns the mean and the standard deviation of
+// the outputs, or 0 and 1 if the outputs are not normalized.
+func normalization(y []float64, normalize bool) (mean, std float64) {
+	if !normalize || len(y) < 2 {
+		return 0, 1
+	}
+	mean, std = stat.MeanStdDev(y, nil)
+	if std == 0 {
+		std = 1
+	}
+	return mean, std
+}
+
+// Parameters of LBFGS
+var (
+	Iters     = 1000 // major iterations
+	MinIters  = 10   // minimum iterations to accept
+	Threshold = 1e-6 // gradient threshold
+)
+
+// LBFGS fits the hyperparameters of g by LBFGS, from theta,
+// without random choices. The optimizer does not have to
+// converge, a few iterations usually bring most of the
+// improvement; an error is returned if the optimizer stops after
+// fewer than MinIters iterations.
+func LBFGS(g *gp.GP, theta []float64, _ *rand.Rand) ([]float64, error) {
+	Func, Grad := gp.FuncGrad(g)
+	p := optimize.Problem{Func: Func, Grad: Grad}
+	result, err := optimize.Minimize(
+		p, theta, &optimize.Settings{
+			MajorIterations:   Iters,
+			GradientThreshold: Threshold,
+		}, nil)
+	if err != nil && result.Stats.MajorIterations > MinIters {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("stuck after %d iterations: %v",
+			result.Stats.MajorIterations, err)
+	}
+
+	// Set the hyperparameters of g
+	g.Observe(result.X)
+	model.DropGradient(g)
+	return result.X, err
+}
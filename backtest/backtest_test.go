@@ -0,0 +1,219 @@
+package backtest
+
+import (
+	"bitbucket.org/dtolpin/gogp/expr"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// series returns a noisy-looking deterministic time series.
+func series(n int) (x [][]float64, y []float64) {
+	for i := 0; i != n; i++ {
+		x = append(x, []float64{float64(i)})
+		y = append(y, math.Sin(0.7*float64(i))+0.1*math.Cos(3.1*float64(i)))
+	}
+	return x, y
+}
+
+func newGP() *gp.GP {
+	simil, noise, err := expr.Parse("c*Matern52(l) + UniformNoise(s)")
+	if err != nil {
+		panic(err)
+	}
+	return &gp.GP{NDim: 1, Simil: simil, Noise: noise}
+}
+
+func TestRun(t *testing.T) {
+	x, y := series(12)
+	theta := []float64{0.5, 0.3, -1}
+	for _, c := range []struct {
+		name     string
+		config   Config
+		cuts     []int
+		horizons [][]int
+		fits     int
+	}{
+		{"expanding",
+			Config{Min: 8},
+			[]int{8, 9, 10, 11},
+			[][]int{{1}, {1}, {1}, {1}},
+			4},
+		{"rolling",
+			Config{Min: 6, Window: 4, Step: 2},
+			[]int{6, 8, 10},
+			[][]int{{1}, {1}, {1}},
+			3},
+		{"refit",
+			Config{Min: 5, Refit: 3},
+			[]int{5, 6, 7, 8, 9, 10, 11},
+			[][]int{{1}, {1}, {1}, {1}, {1}, {1}, {1}},
+			3},
+		{"horizons",
+			Config{Min: 8, Window: 5, Refit: 2, Horizons: []int{3, 1}},
+			[]int{8, 9, 10, 11},
+			[][]int{{3, 1}, {3, 1}, {1}, {1}},
+			2},
+	} {
+		// Fitting sets fixed hyperparameters, hence each
+		// forecast can be checked against a GP on its window.
+		var fits int32
+		c.config.New = newGP
+		c.config.Fit = func(g *gp.GP, _ []float64, _ *rand.Rand) ([]float64, error) {
+			atomic.AddInt32(&fits, 1)
+			g.Observe(theta)
+			return theta, nil
+		}
+		c.config.Workers = 3
+		r, err := Run(c.config, x, y)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if int(fits) != c.fits {
+			t.Errorf("%s: wrong number of fits: got %d, want %d",
+				c.name, fits, c.fits)
+		}
+		i := 0
+		for j, cut := range c.cuts {
+			for _, h := range c.horizons[j] {
+				if i == len(r.Forecasts) {
+					t.Fatalf("%s: too few forecasts: %d", c.name, i)
+				}
+				f := r.Forecasts[i]
+				i++
+				if f.Cut != cut || f.Horizon != h || f.Index != cut+h-1 {
+					t.Errorf("%s: wrong forecast: got %d+%d, want %d+%d",
+						c.name, f.Cut, f.Horizon, cut, h)
+					continue
+				}
+				if f.Refit != (j%max(c.config.Refit, 1) == 0) {
+					t.Errorf("%s: %d+%d: wrong refit %v",
+						c.name, cut, h, f.Refit)
+				}
+				start := 0
+				if c.config.Window > 0 {
+					start = max(cut-c.config.Window, 0)
+				}
+				g := newGP()
+				g.X, g.Y = x[start:cut], y[start:cut]
+				g.Observe(theta)
+				mu, sigma, err := g.Produce(x[f.Index : f.Index+1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if math.Abs(f.Mu-mu[0]) > 1e-9 ||
+					math.Abs(f.Sigma-sigma[0]) > 1e-9 ||
+					f.Y != y[f.Index] {
+					t.Errorf("%s: %d+%d: wrong forecast: "+
+						"got %.6g±%.6g, want %.6g±%.6g",
+						c.name, cut, h, f.Mu, f.Sigma, mu[0], sigma[0])
+				}
+			}
+		}
+		if i != len(r.Forecasts) {
+			t.Errorf("%s: too many forecasts: got %d, want %d",
+				c.name, len(r.Forecasts), i)
+		}
+		for _, h := range []int{1, 3} {
+			for _, f := range r.Horizon(h) {
+				if f.Horizon != h {
+					t.Errorf("%s: wrong horizon %d", c.name, f.Horizon)
+				}
+			}
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	x, y := series(15)
+	// Fits from random initial values are reproducible.
+	fit := func(g *gp.GP, theta []float64, rng *rand.Rand) ([]float64, error) {
+		for i := range theta {
+			theta[i] += 0.1 * rng.NormFloat64()
+		}
+		return LBFGS(g, theta, rng)
+	}
+	for _, c := range []struct {
+		name  string
+		newGP func() *gp.GP
+	}{
+		{"expression", newGP},
+		// The kernels of the library share the tape.
+		{"library", func() *gp.GP {
+			return &gp.GP{
+				NDim:  1,
+				Simil: kernel.Matern52,
+				Noise: kernel.UniformNoise,
+			}
+		}},
+		// The tape is not thread-safe in this package, and the
+		// workers of the GPs share it as well.
+		{"parallel library", func() *gp.GP {
+			return &gp.GP{
+				NDim:     1,
+				Simil:    kernel.Matern52,
+				Noise:    kernel.UniformNoise,
+				Parallel: true,
+				Workers:  3,
+			}
+		}},
+	} {
+		var results []*Result
+		for _, workers := range []int{1, 4} {
+			r, err := Run(Config{
+				New:       c.newGP,
+				Fit:       fit,
+				Seed:      7,
+				Min:       6,
+				Window:    8,
+				Refit:     2,
+				Horizons:  []int{1, 2},
+				Normalize: true,
+				Workers:   workers,
+			}, x, y)
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			results = append(results, r)
+		}
+		if c.name == "expression" && !reflect.DeepEqual(results[0].Names,
+			[]string{"simil.c", "simil.l", "noise.s"}) {
+			t.Errorf("%s: wrong names: %v", c.name, results[0].Names)
+		}
+		if !reflect.DeepEqual(results[0].Forecasts, results[1].Forecasts) {
+			t.Errorf("%s: parallel results differ from serial ones", c.name)
+		}
+		for _, h := range []int{1, 2} {
+			s := results[0].Summary(h, nil, 0)
+			if n := len(results[0].Horizon(h)); s.N != n || math.IsNaN(s.MSLL) {
+				t.Errorf("%s: wrong summary at horizon %d of %d forecasts: %+v",
+					c.name, h, n, s)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	x, y := series(5)
+	for _, c := range []struct {
+		name   string
+		config Config
+	}{
+		{"no constructor", Config{}},
+		{"horizon", Config{New: newGP, Horizons: []int{0}}},
+		{"window", Config{New: newGP, Window: -1}},
+		{"too few data", Config{New: newGP, Min: 5}},
+	} {
+		if _, err := Run(c.config, x, y); err == nil {
+			t.Errorf("%s: no error", c.name)
+		}
+	}
+	if _, err := Run(Config{New: newGP}, x, y[1:]); err == nil {
+		t.Errorf("mismatched data: no error")
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	bt "bitbucket.org/dtolpin/gogp/backtest"
+	"bitbucket.org/dtolpin/gogp/data"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/gpfile"
+	"bitbucket.org/dtolpin/gogp/query"
+	"bitbucket.org/dtolpin/gogp/server"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fit fits the hyperparameters to the data and writes the model.
+func fit(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("fit", flag.ContinueOnError)
+	var o options
+	o.register(fs)
+	output := fs.String("o", "", "model file, standard output if empty")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	X, Y, err := o.loadData(fs, stdin)
+	if err != nil {
+		return err
+	}
+	g, err := o.newGP(len(X[0]))
+	if err != nil {
+		return err
+	}
+	mean, std := o.normalization(Y)
+	f := &gpfile.File{Mean: mean, Std: std}
+	g.X, g.Y = X, f.Normalize(Y)
+
+	theta := make([]float64, len(g.Names()))
+	rng := rand.New(rand.NewSource(o.seed))
+	if _, err := o.fit(g, theta, rng); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "LML: %.6g\nKernel: %v\n", g.LML(), g)
+
+	w := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return gpfile.New(g, o.kernel, mean, std).Write(w)
+}
+
+// predict writes the predictive means and standard deviations
+// of the model at the inputs, and optionally their covariances.
+func predict(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("predict", flag.ContinueOnError)
+	path := fs.String("model", "", "model file")
+	spec := fs.String("query", "",
+		"query points instead of the inputs: horizon=H[,step=S],\n"+
+			"grid=FROM:TO:STEP[,...], or file=PATH (see package query)")
+	withCov := fs.Bool("cov", false, "write the covariances of the predictions")
+	var par, hasHeader bool
+	var workers int
+	parallel(fs, &par, &workers)
+	headerFlag(fs, &hasHeader)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := loadModel(*path)
+	if err != nil {
+		return err
+	}
+	g, err := f.GP()
+	if err != nil {
+		return err
+	}
+	g.Parallel, g.Workers = par, workers
+	var Z [][]float64
+	if *spec != "" {
+		if len(f.X) == 0 {
+			return errors.New("no observations in the model to query after")
+		}
+		Z, err = query.Parse(*spec, f.X[len(f.X)-1], data.Options{})
+	} else {
+		Z, err = loadInputs(fs, stdin, f.NDim, hasHeader)
+	}
+	if err != nil {
+		return err
+	}
+
+	var mu, sigma []float64
+	var cov *mat.SymDense
+	if *withCov {
+		mu, cov, err = g.ProduceCov(Z)
+		if err == nil {
+			sigma = make([]float64, len(Z))
+			for i := range sigma {
+				sigma[i] = math.Sqrt(cov.At(i, i))
+			}
+		}
+	} else {
+		mu, sigma, err = g.Produce(Z)
+	}
+	if err != nil {
+		return err
+	}
+	f.Denormalize(mu, sigma)
+
+	w := csv.NewWriter(stdout)
+	header := append(inputNames(f.NDim), "mu", "sigma")
+	if *withCov {
+		for i := range Z {
+			header = append(header, fmt.Sprintf("cov%d", i+1))
+		}
+	}
+	w.Write(header)
+	std := f.Std // zero if the outputs are not normalized
+	if std == 0 {
+		std = 1
+	}
+	for i := range Z {
+		record := append(append([]float64{}, Z[i]...), mu[i], sigma[i])
+		if *withCov {
+			for j := range Z {
+				record = append(record, cov.At(i, j)*std*std)
+			}
+		}
+		w.Write(format(record...))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// backtest forecasts the outputs at the horizons from cut points
+// in the data, from the observations in a window preceding each
+// cut point (see package backtest).
+func backtest(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	var o options
+	o.register(fs)
+	min := fs.Int("min", 2, "minimum number of observations to forecast from")
+	step := fs.Int("step", 1, "distance between cut points")
+	window := fs.Int("window", 0,
+		"number of most recent observations, all when 0")
+	refit := fs.Int("refit", 1, "refit the hyperparameters every k cut points")
+	horizons := fs.String("horizons", "1", "comma-separated forecast horizons")
+	jobs := fs.Int("j", 0,
+		"number of cut points evaluated in parallel, GOMAXPROCS when 0")
+	reportPath := fs.String("report", "", "write an HTML report to the file")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	var hs []int
+	for _, h := range strings.Split(*horizons, ",") {
+		h, err := strconv.Atoi(strings.TrimSpace(h))
+		if err != nil {
+			return fmt.Errorf("invalid horizons %q", *horizons)
+		}
+		hs = append(hs, h)
+	}
+
+	X, Y, err := o.loadData(fs, stdin)
+	if err != nil {
+		return err
+	}
+	if _, err := o.newGP(len(X[0])); err != nil {
+		return err
+	}
+	r, err := bt.Run(bt.Config{
+		New: func() *gp.GP {
+			g, _ := o.newGP(len(X[0]))
+			return g
+		},
+		Fit:       o.fit,
+		Min:       *min,
+		Step:      *step,
+		Window:    *window,
+		Refit:     *refit,
+		Horizons:  hs,
+		Normalize: o.normalize,
+		Workers:   *jobs,
+		Seed:      o.seed,
+	}, X, Y)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(stdout)
+	header := append([]string{"cut", "horizon"}, inputNames(len(X[0]))...)
+	header = append(header, "y", "mu", "sigma", "lml")
+	w.Write(append(header, r.Names...))
+	for i, f := range r.Forecasts {
+		// Fit errors are reported once per fit.
+		if f.Refit && f.FitErr != nil &&
+			(i == 0 || r.Forecasts[i-1].Cut != f.Cut) {
+			fmt.Fprintf(os.Stderr, "%d: %v\n", f.Cut, f.FitErr)
+		}
+		record := append(append([]float64{}, f.X...),
+			f.Y, f.Mu, f.Sigma, f.LML)
+		record = append(record, f.Theta...)
+		w.Write(append([]string{strconv.Itoa(f.Cut), strconv.Itoa(f.Horizon)},
+			format(record...)...))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	var summary strings.Builder
+	for _, h := range hs {
+		fmt.Fprintf(&summary, "Horizon %d:\n%v", h, r.Summary(h, nil, 0))
+	}
+	fmt.Fprint(os.Stderr, summary.String())
+	if *reportPath != "" {
+		return backtestReport(*reportPath, X, Y, r, hs, summary.String())
+	}
+	return nil
+}
+
+// sample draws functions from the posterior of the model at the
+// inputs, one column per function.
+func sample(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("sample", flag.ContinueOnError)
+	path := fs.String("model", "", "model file")
+	n := fs.Int("n", 10, "number of samples")
+	seed := fs.Int64("seed", 1, "random seed")
+	var hasHeader bool
+	headerFlag(fs, &hasHeader)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := loadModel(*path)
+	if err != nil {
+		return err
+	}
+	g, err := f.GP()
+	if err != nil {
+		return err
+	}
+	Z, err := loadInputs(fs, stdin, f.NDim, hasHeader)
+	if err != nil {
+		return err
+	}
+	mu, cov, err := g.ProduceCov(Z)
+	if err != nil {
+		return err
+	}
+	L, err := cholesky(cov)
+	if err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	samples := mat.NewDense(len(Z), *n, nil)
+	z := mat.NewVecDense(len(Z), nil)
+	s := mat.NewVecDense(len(Z), nil)
+	for j := 0; j != *n; j++ {
+		for i := range Z {
+			z.SetVec(i, rng.NormFloat64())
+		}
+		s.MulVec(L, z)
+		col := make([]float64, len(Z))
+		for i := range col {
+			col[i] = mu[i] + s.AtVec(i)
+		}
+		f.Denormalize(col, nil)
+		samples.SetCol(j, col)
+	}
+
+	w := csv.NewWriter(stdout)
+	header := inputNames(f.NDim)
+	for j := 0; j != *n; j++ {
+		header = append(header, fmt.Sprintf("sample%d", j+1))
+	}
+	w.Write(header)
+	for i := range Z {
+		w.Write(format(append(append([]float64{}, Z[i]...),
+			samples.RawRowView(i)...)...))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// cholesky returns the lower triangular factor of the posterior
+// covariance. The covariance is positive semidefinite, and is
+// regularized by increasing jitter until it can be factorized.
+func cholesky(cov *mat.SymDense) (*mat.TriDense, error) {
+	n := cov.Symmetric()
+	scale := 0.
+	for i := 0; i != n; i++ {
+		scale = math.Max(scale, cov.At(i, i))
+	}
+	if scale == 0 {
+		scale = 1
+	}
+	var chol mat.Cholesky
+	a := mat.NewSymDense(n, nil)
+	for jitter := 1e-12; jitter < 1e-3; jitter *= 10 {
+		a.CopySym(cov)
+		for i := 0; i != n; i++ {
+			a.SetSym(i, i, a.At(i, i)+jitter*scale)
+		}
+		if chol.Factorize(a) {
+			var L mat.TriDense
+			chol.LTo(&L)
+			return &L, nil
+		}
+	}
+	return nil, fmt.Errorf("the posterior covariance is not positive definite")
+}
+
+// serve serves predictions of the model over HTTP (see package
+// server), until interrupted.
+func serve(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	path := fs.String("model", "", "model file")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	seed := fs.Int64("seed", 1, "random seed of refits")
+	var par bool
+	var workers int
+	parallel(fs, &par, &workers)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := loadModel(*path)
+	if err != nil {
+		return err
+	}
+	s, err := server.New(f, server.Options{
+		Seed:     *seed,
+		Parallel: par,
+		Workers:  workers,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s on %s\n", f.Kernel, *addr)
+	return http.ListenAndServe(*addr, s)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bitbucket.org/dtolpin/gogp/config"
+	"bitbucket.org/dtolpin/gogp/data"
+	"bitbucket.org/dtolpin/gogp/expr"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/gpfile"
+	"bitbucket.org/dtolpin/infergo/infer"
+	"bitbucket.org/dtolpin/infergo/model"
+	"errors"
+	"flag"
+	"fmt"
+	"gonum.org/v1/gonum/optimize"
+	"gonum.org/v1/gonum/stat"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// Type options are the options of fitting a GP.
+type options struct {
+	kernel    string  // kernel expression, see expr.Parse
+	normalize bool    // normalize the outputs
+	alg       string  // optimization algorithm
+	iters     int     // major iterations
+	miniters  int     // minimum iterations to accept in lbfgs
+	threshold float64 // gradient threshold
+	rate      float64 // learning rate, for Adam
+	restarts  int     // number of restarts from jittered values
+	jitter    float64 // standard deviation of the jitter
+	seed      int64   // random seed
+	parallel  bool    // compute covariances in parallel
+	workers   int     // number of parallel workers
+	config    string  // experiment configuration, see package config
+	header    bool    // the first row of the data names the columns
+	// Set by the experiment configuration
+	data       string             // path of the data
+	experiment *config.Experiment // for the priors on the hyperparameters
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.kernel, "kernel", "c*Matern52(l) + UniformNoise(s)",
+		"kernel expression, of Normal, Periodic, Matern32, Matern52,\n"+
+			"UniformNoise, and ConstantNoise(std)")
+	fs.BoolVar(&o.normalize, "normalize", true, "normalize the outputs")
+	fs.StringVar(&o.alg, "a", "lbfgs", "optimization algorithm: lbfgs or adam")
+	fs.IntVar(&o.iters, "iters", 1000, "major iterations")
+	fs.IntVar(&o.miniters, "miniters", 10,
+		"minimum iterations to accept in lbfgs")
+	fs.Float64Var(&o.threshold, "threshold", 1e-6, "gradient threshold")
+	fs.Float64Var(&o.rate, "rate", 0.01, "learning rate, for adam")
+	fs.IntVar(&o.restarts, "restarts", 0,
+		"number of restarts from jittered initial values")
+	fs.Float64Var(&o.jitter, "jitter", 0.5,
+		"standard deviation of the jitter of restarts")
+	fs.Int64Var(&o.seed, "seed", 1, "random seed")
+	fs.StringVar(&o.config, "config", "",
+		"experiment configuration in JSON, see package config;\n"+
+			"flags given explicitly override the configuration")
+	parallel(fs, &o.parallel, &o.workers)
+	headerFlag(fs, &o.header)
+}
+
+// parse parses the arguments and applies the experiment
+// configuration, if any; flags given explicitly override the
+// configuration.
+func (o *options) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.config == "" {
+		return nil
+	}
+	e, err := config.Load(o.config)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	o.experiment = e
+	o.data = e.Data
+	if e.Kernel != "" {
+		o.kernel = e.Kernel
+	}
+	if e.Normalize != nil {
+		o.normalize = *e.Normalize
+	}
+	opt := e.Optimizer
+	if opt.Algorithm != "" {
+		o.alg = opt.Algorithm
+	}
+	if opt.Iters != 0 {
+		o.iters = opt.Iters
+	}
+	if opt.MinIters != 0 {
+		o.miniters = opt.MinIters
+	}
+	if opt.Threshold != 0 {
+		o.threshold = opt.Threshold
+	}
+	if opt.Rate != 0 {
+		o.rate = opt.Rate
+	}
+	if opt.Restarts != 0 {
+		o.restarts = opt.Restarts
+	}
+	if opt.Jitter != 0 {
+		o.jitter = opt.Jitter
+	}
+	if e.Seed != nil {
+		o.seed = *e.Seed
+	}
+
+	for name, value := range set {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parallel registers the flags of parallel computation.
+func parallel(fs *flag.FlagSet, parallel *bool, workers *int) {
+	fs.BoolVar(parallel, "p", false, "compute covariances in parallel")
+	fs.IntVar(workers, "w", 0,
+		"number of parallel workers, GOMAXPROCS when 0")
+}
+
+// headerFlag registers the flag of the header of the data.
+func headerFlag(fs *flag.FlagSet, header *bool) {
+	fs.BoolVar(header, "header", false,
+		"the first row of the data holds the names of the columns")
+}
+
+// newGP creates a GP with the kernels of the options.
+func (o *options) newGP(ndim int) (*gp.GP, error) {
+	if ndim != 1 {
+		return nil, fmt.Errorf("%d inputs, kernel expressions "+
+			"have a single input", ndim)
+	}
+	simil, noise, err := expr.Parse(o.kernel)
+	if err != nil {
+		return nil, err
+	}
+	g := &gp.GP{
+		NDim:     ndim,
+		Simil:    simil,
+		Noise:    noise,
+		Parallel: o.parallel,
+		Workers:  o.workers,
+	}
+	if o.experiment != nil {
+		// The priors must name hyperparameters of the kernel.
+		if _, err := o.experiment.PriorModel(g); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// normalization returns the mean and the standard deviation of
+// the outputs, or 0 and 1 if the outputs are not normalized.
+func (o *options) normalization(y []float64) (mean, std float64) {
+	if !o.normalize || len(y) < 2 {
+		return 0, 1
+	}
+	mean, std = stat.MeanStdDev(y, nil)
+	if std == 0 {
+		std = 1
+	}
+	return mean, std
+}
+
+// fit fits the hyperparameters of g, starting from theta and, on
+// restarts, from theta jittered by rng, and returns the best
+// hyperparameters, by the log marginal likelihood and the log
+// priors. The observations must be assigned to g. The
+// hyperparameters of g are set to the result, even if an error
+// is returned; the error means that the optimizer stopped too
+// early.
+func (o *options) fit(
+	g *gp.GP,
+	theta []float64,
+	rng *rand.Rand,
+) ([]float64, error) {
+	var m model.ElementalModel = g
+	if o.experiment != nil {
+		pm, err := o.experiment.PriorModel(g)
+		if err != nil {
+			return theta, err
+		}
+		if pm != nil {
+			m = &gp.Model{GP: g, Priors: pm}
+		}
+	}
+
+	x, ll, err := o.optimize(m, theta)
+	for i := 0; i != o.restarts; i++ {
+		x0 := make([]float64, len(theta))
+		for j := range x0 {
+			x0[j] = theta[j] + o.jitter*rng.NormFloat64()
+		}
+		xi, lli, erri := o.optimize(m, x0)
+		if lli > ll || math.IsNaN(ll) {
+			x, ll, err = xi, lli, erri
+		}
+	}
+
+	// Set the hyperparameters of g; the tapes are not dropped,
+	// since fit runs concurrently in backtests.
+	g.Observe(x)
+	model.DropGradient(g)
+
+	return x, err
+}
+
+// optimize maximizes the log likelihood of model m, starting
+// from theta, and returns the optimized parameters and the log
+// likelihood.
+func (o *options) optimize(m model.ElementalModel, theta []float64) (
+	x []float64,
+	ll float64,
+	err error,
+) {
+	x = append([]float64{}, theta...)
+	switch o.alg {
+	case "lbfgs":
+		Func, Grad := gp.FuncGrad(m)
+		p := optimize.Problem{Func: Func, Grad: Grad}
+		var result *optimize.Result
+		result, err = optimize.Minimize(
+			p, x, &optimize.Settings{
+				MajorIterations:   o.iters,
+				GradientThreshold: o.threshold,
+			}, nil)
+		// A few iterations usually bring most of the
+		// improvement, the optimizer does not have to converge.
+		if err != nil && result.Stats.MajorIterations > o.miniters {
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("stuck after %d iterations: %v",
+				result.Stats.MajorIterations, err)
+		}
+		x = result.X
+	case "adam":
+		opt := &infer.Adam{Rate: o.rate}
+	Epochs:
+		for epoch := 0; epoch != o.iters; epoch++ {
+			_, grad := opt.Step(m, x)
+			for i := range grad {
+				if math.Abs(grad[i]) >= o.threshold {
+					continue Epochs
+				}
+			}
+			break Epochs
+		}
+	default:
+		return theta, math.NaN(), fmt.Errorf("unknown algorithm %q", o.alg)
+	}
+	ll = m.Observe(x)
+	model.DropGradient(m)
+	return x, ll, err
+}
+
+// open opens the only positional argument, or, if there are no
+// positional arguments, the file at path, or returns stdin if
+// the path is empty.
+func open(fs *flag.FlagSet, stdin io.Reader, path string) (io.ReadCloser, error) {
+	switch fs.NArg() {
+	case 0:
+		if path != "" {
+			return os.Open(path)
+		}
+		return io.NopCloser(stdin), nil
+	case 1:
+		return os.Open(fs.Arg(0))
+	default:
+		return nil, errors.New("too many arguments")
+	}
+}
+
+// loadData reads the data, inputs followed by the output, from
+// the positional argument, the path of the data in the options,
+// or stdin (see data.Load).
+func (o *options) loadData(fs *flag.FlagSet, stdin io.Reader) (
+	x [][]float64,
+	y []float64,
+	err error,
+) {
+	rdr, err := open(fs, stdin, o.data)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rdr.Close()
+	t, err := data.Load(rdr, data.Options{Header: o.header})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(t.X) == 0 {
+		return nil, nil, errors.New("no data")
+	}
+	return t.X, t.Y, nil
+}
+
+// loadInputs reads ndim-dimensional inputs; an extra column, the
+// output, is ignored. The model does not keep the origin of
+// numeric time, hence the inputs must not be timestamps.
+func loadInputs(
+	fs *flag.FlagSet,
+	stdin io.Reader,
+	ndim int,
+	header bool,
+) (
+	x [][]float64,
+	err error,
+) {
+	rdr, err := open(fs, stdin, "")
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	t, err := data.Load(rdr, data.Options{Header: header, NoOutput: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(t.X) == 0 {
+		return nil, errors.New("no inputs")
+	}
+	if !t.Origin.IsZero() {
+		return nil, errors.New("timestamps in the inputs, " +
+			"the model does not keep the origin of time")
+	}
+	if len(t.X[0]) != ndim && len(t.X[0]) != ndim+1 {
+		return nil, fmt.Errorf("%d columns, want %d inputs",
+			len(t.X[0]), ndim)
+	}
+	for _, record := range t.X {
+		x = append(x, record[:ndim])
+	}
+	return x, nil
+}
+
+// loadModel reads the model file.
+func loadModel(path string) (*gpfile.File, error) {
+	if path == "" {
+		return nil, errors.New("no model, use -model")
+	}
+	rdr, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return gpfile.Read(rdr)
+}
+
+// inputNames returns the column names of ndim-dimensional inputs.
+func inputNames(ndim int) []string {
+	if ndim == 1 {
+		return []string{"x"}
+	}
+	names := make([]string, ndim)
+	for i := range names {
+		names[i] = fmt.Sprintf("x%d", i+1)
+	}
+	return names
+}
+
+// format formats floating-point values for output.
+func format(values ...float64) []string {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fields
+}
@@ -0,0 +1,94 @@
+// Command gogp fits Gaussian processes to data, makes and
+// backtests predictions, and draws samples, with kernels given
+// by expressions (see package expr). Invocation:
+//   gogp fit [OPTIONS] [DATA] > MODEL
+//   gogp predict -model MODEL [OPTIONS] [INPUTS] > PREDICTIONS
+//   gogp predict -model MODEL -query QUERY [OPTIONS] > PREDICTIONS
+//   gogp backtest [OPTIONS] [DATA] > FORECASTS
+//   gogp sample -model MODEL [OPTIONS] [INPUTS] > SAMPLES
+//   gogp serve -model MODEL [OPTIONS]
+// Data are CSV or TSV records of inputs followed by the output;
+// inputs are records of inputs, and the output, if present, is
+// ignored. With -header, the first row names the columns; fields
+// of the data may be timestamps, and rows with missing values are
+// skipped (see package data). When the file is omitted, the
+// standard input is read.
+// Instead of inputs, predictions can be queried at points after
+// the data, on a grid, or from a file (see package query).
+// Fit and backtest read the data, the kernel, the priors and the
+// settings of the optimizer from an experiment configuration with
+// -config (see package config); explicit flags override it.
+// Serve serves the model over HTTP (see package server).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Type command is a subcommand.
+type command struct {
+	usage string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"fit": {
+		"fit [OPTIONS] [DATA] > MODEL\n" +
+			"\tfits hyperparameters and writes the model",
+		fit,
+	},
+	"predict": {
+		"predict -model MODEL [OPTIONS] [INPUTS] > PREDICTIONS\n" +
+			"\twrites predictive means and standard deviations,\n" +
+			"\tand covariances with -cov; -query QUERY replaces INPUTS",
+		predict,
+	},
+	"backtest": {
+		"backtest [OPTIONS] [DATA] > FORECASTS\n" +
+			"\tforecasts the outputs at horizons from cut points in the data",
+		backtest,
+	},
+	"sample": {
+		"sample -model MODEL [OPTIONS] [INPUTS] > SAMPLES\n" +
+			"\tdraws functions from the posterior at the inputs",
+		sample,
+	},
+	"serve": {
+		"serve -model MODEL [OPTIONS]\n" +
+			"\tserves predictions, absorptions and refits over HTTP",
+		serve,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	for _, name := range []string{"fit", "predict", "backtest", "sample", "serve"} {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", os.Args[0], commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr,
+		"Run '%s COMMAND -h' for the options of a command.\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(os.Args[2:], os.Stdin, os.Stdout)
+	switch err {
+	case nil:
+	case flag.ErrHelp:
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", os.Args[0], os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	bt "bitbucket.org/dtolpin/gogp/backtest"
+	"bitbucket.org/dtolpin/gogp/report"
+	"fmt"
+	"os"
+)
+
+// Level of credible bands in reports
+const level = 0.95
+
+// backtestReport writes an HTML report of backtest result r on
+// data X, Y: the forecasts at horizons hs, and the
+// hyperparameters and the log marginal likelihood at the cut
+// points, against the first input.
+func backtestReport(
+	path string,
+	X [][]float64,
+	Y []float64,
+	r *bt.Result,
+	hs []int,
+	summary string,
+) error {
+	rep := &report.Report{Title: "GoGP backtest", Summary: summary}
+	f := rep.Figure("Forecasts", "x", "y")
+	var x []float64
+	for i := range X {
+		x = append(x, X[i][0])
+	}
+	f.Points("observed", x, Y)
+	for _, h := range hs {
+		var hx, mu, sigma []float64
+		for _, fc := range r.Horizon(h) {
+			hx = append(hx, fc.X[0])
+			mu = append(mu, fc.Mu)
+			sigma = append(sigma, fc.Sigma)
+		}
+		f.Forecast(fmt.Sprintf("horizon %d", h), hx, mu, sigma, level)
+	}
+
+	// Hyperparameters and LML, once per cut point
+	var cx, lml []float64
+	theta := make([][]float64, len(r.Names))
+	for i, fc := range r.Forecasts {
+		if i > 0 && r.Forecasts[i-1].Cut == fc.Cut {
+			continue
+		}
+		cx = append(cx, X[fc.Cut][0])
+		lml = append(lml, fc.LML)
+		for j := range theta {
+			theta[j] = append(theta[j], fc.Theta[j])
+		}
+	}
+	f = rep.Figure("Hyperparameters", "x at the cut point", "value")
+	for j, name := range r.Names {
+		f.Line(name, cx, theta[j])
+	}
+	rep.Figure("Log marginal likelihood", "x at the cut point", "LML").
+		Line("LML", cx, lml)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := rep.HTML(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,157 @@
+// Package config reads and writes experiment configurations in
+// JSON: the data, the kernel, the priors on the hyperparameters,
+// the settings of the optimizer, and the random seed, so that a
+// run can be reproduced exactly. An example:
+//   {
+//     "data": "series.csv",
+//     "kernel": "c*Matern52(l) + UniformNoise(s)",
+//     "priors": {"simil.l": {"dist": "normal", "mu": 1, "sigma": 0.5}},
+//     "optimizer": {"algorithm": "lbfgs", "iters": 1000,
+//                   "restarts": 4, "jitter": 0.5},
+//     "seed": 42
+//   }
+package config
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/priors"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Type Experiment is the configuration of an experiment. Zero
+// values stand for the defaults of the program running the
+// experiment.
+type Experiment struct {
+	// Path of the data; relative to the configuration file
+	// when loaded by Load.
+	Data string `json:"data,omitempty"`
+	// Kernel expression, see expr.Parse.
+	Kernel string `json:"kernel,omitempty"`
+	// Priors on hyperparameters, by name, see GP.Names.
+	Priors map[string]Prior `json:"priors,omitempty"`
+	// Normalize the outputs, when not nil.
+	Normalize *bool     `json:"normalize,omitempty"`
+	Optimizer Optimizer `json:"optimizer"`
+	// Seed of the random source, the default of the program when
+	// nil; runs with the same seed are identical.
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+// Type Optimizer are the settings of the optimizer of the
+// hyperparameters.
+type Optimizer struct {
+	Algorithm string  `json:"algorithm,omitempty"` // lbfgs or adam
+	Iters     int     `json:"iters,omitempty"`     // major iterations
+	MinIters  int     `json:"miniters,omitempty"`  // to accept in lbfgs
+	Threshold float64 `json:"threshold,omitempty"` // of the gradient
+	Rate      float64 `json:"rate,omitempty"`      // learning rate of adam
+	// Restarts from initial values perturbed by normal noise
+	// with standard deviation Jitter; the best fit is kept.
+	Restarts int     `json:"restarts,omitempty"`
+	Jitter   float64 `json:"jitter,omitempty"`
+}
+
+// Type Prior is a prior on a hyperparameter: one of the
+// distributions of package priors, by lowercase name, with its
+// parameters.
+type Prior struct {
+	Dist  string  `json:"dist"` // normal, gamma, halfcauchy, invgamma
+	Mu    float64 `json:"mu,omitempty"`
+	Sigma float64 `json:"sigma,omitempty"`
+	Alpha float64 `json:"alpha,omitempty"`
+	Beta  float64 `json:"beta,omitempty"`
+	Gamma float64 `json:"gamma,omitempty"`
+}
+
+// Prior returns the prior of package priors.
+func (p Prior) Prior() (priors.Prior, error) {
+	positive := func(names []string, values ...float64) error {
+		for i, v := range values {
+			if v <= 0 {
+				return fmt.Errorf("%s prior: %s=%g, must be positive",
+					p.Dist, names[i], v)
+			}
+		}
+		return nil
+	}
+	switch p.Dist {
+	case "normal":
+		return priors.Normal{Mu: p.Mu, Sigma: p.Sigma},
+			positive([]string{"sigma"}, p.Sigma)
+	case "gamma":
+		return priors.Gamma{Alpha: p.Alpha, Beta: p.Beta},
+			positive([]string{"alpha", "beta"}, p.Alpha, p.Beta)
+	case "halfcauchy":
+		return priors.HalfCauchy{Gamma: p.Gamma},
+			positive([]string{"gamma"}, p.Gamma)
+	case "invgamma":
+		return priors.InvGamma{Alpha: p.Alpha, Beta: p.Beta},
+			positive([]string{"alpha", "beta"}, p.Alpha, p.Beta)
+	default:
+		return nil, fmt.Errorf("unknown prior %q", p.Dist)
+	}
+}
+
+// PriorModel returns the model of the priors on the
+// hyperparameters of g, or nil if there are no priors.
+func (e *Experiment) PriorModel(g *gp.GP) (*priors.Model, error) {
+	if len(e.Priors) == 0 {
+		return nil, nil
+	}
+	ps := make(map[string]priors.Prior)
+	for name, p := range e.Priors {
+		prior, err := p.Prior()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		ps[name] = prior
+	}
+	return priors.Named(g, ps)
+}
+
+// Read reads a configuration; unknown fields are errors, to catch
+// misspellings.
+func Read(r io.Reader) (*Experiment, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	e := &Experiment{}
+	if err := dec.Decode(e); err != nil {
+		return nil, fmt.Errorf("experiment configuration: %v", err)
+	}
+	for name, p := range e.Priors {
+		if _, err := p.Prior(); err != nil {
+			return nil, fmt.Errorf("experiment configuration: %s: %v",
+				name, err)
+		}
+	}
+	return e, nil
+}
+
+// Load reads the configuration from the file; the path of the
+// data is made relative to the directory of the file.
+func Load(path string) (*Experiment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	e, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if e.Data != "" && !filepath.IsAbs(e.Data) {
+		e.Data = filepath.Join(filepath.Dir(path), e.Data)
+	}
+	return e, nil
+}
+
+// Write writes the configuration, indented.
+func (e *Experiment) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
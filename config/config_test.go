@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bitbucket.org/dtolpin/gogp/expr"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/priors"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const experiment = `{
+  "data": "series.csv",
+  "kernel": "c*Matern52(l) + UniformNoise(s)",
+  "priors": {
+    "simil.l": {"dist": "normal", "mu": 1, "sigma": 0.5},
+    "noise.s": {"dist": "halfcauchy", "gamma": 0.1}
+  },
+  "normalize": false,
+  "optimizer": {"algorithm": "adam", "iters": 200, "rate": 0.1,
+                "restarts": 4, "jitter": 0.5},
+  "seed": 42
+}`
+
+func TestRead(t *testing.T) {
+	e, err := Read(strings.NewReader(experiment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalize := false
+	seed := int64(42)
+	want := &Experiment{
+		Data:   "series.csv",
+		Kernel: "c*Matern52(l) + UniformNoise(s)",
+		Priors: map[string]Prior{
+			"simil.l": {Dist: "normal", Mu: 1, Sigma: 0.5},
+			"noise.s": {Dist: "halfcauchy", Gamma: 0.1},
+		},
+		Normalize: &normalize,
+		Optimizer: Optimizer{Algorithm: "adam", Iters: 200, Rate: 0.1,
+			Restarts: 4, Jitter: 0.5},
+		Seed: &seed,
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("wrong experiment: got %+v, want %+v", e, want)
+	}
+
+	// Written and read again, the experiment is the same.
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	e, err = Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("wrong round trip: got %+v, want %+v", e, want)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	// Fields which are not set are left to the program.
+	e, err := Read(strings.NewReader(`{"kernel": "c*Normal(l)"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Seed != nil || e.Normalize != nil {
+		t.Errorf("fields set: %+v", e)
+	}
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "seed") {
+		t.Errorf("seed written: %s", buf.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		name, config, err string
+	}{
+		{"unknown field", `{"kernal": "c*Normal(l)"}`, "kernal"},
+		{"malformed", `{"seed": "one"}`, "seed"},
+		{"unknown prior", `{"priors": {"simil.l": {"dist": "beta"}}}`,
+			"unknown prior"},
+		{"non-positive parameter",
+			`{"priors": {"simil.l": {"dist": "gamma", "alpha": 1}}}`,
+			"beta=0"},
+	} {
+		_, err := Read(strings.NewReader(c.config))
+		if err == nil {
+			t.Errorf("%s: no error", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: wrong error: got %q, want %q in it",
+				c.name, err, c.err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "experiment.json")
+	if err := os.WriteFile(path, []byte(experiment), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "series.csv"); e.Data != want {
+		t.Errorf("wrong data path: got %q, want %q", e.Data, want)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("no error for a missing file")
+	}
+}
+
+func TestPriorModel(t *testing.T) {
+	e, err := Read(strings.NewReader(experiment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	simil, noise, err := expr.Parse(e.Kernel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &gp.GP{NDim: 1, Simil: simil, Noise: noise}
+	m, err := e.PriorModel(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]priors.Prior{
+		g.Index("simil.l"): priors.Normal{Mu: 1, Sigma: 0.5},
+		g.Index("noise.s"): priors.HalfCauchy{Gamma: 0.1},
+	}
+	if !reflect.DeepEqual(m.Priors(), want) {
+		t.Errorf("wrong priors: got %v, want %v", m.Priors(), want)
+	}
+
+	// The priors must name hyperparameters of the kernel.
+	e.Priors["simil.p"] = Prior{Dist: "normal", Sigma: 1}
+	if _, err := e.PriorModel(g); err == nil {
+		t.Errorf("no error for an unknown hyperparameter")
+	}
+
+	// Without priors, there is no model.
+	e.Priors = nil
+	if m, err := e.PriorModel(g); m != nil || err != nil {
+		t.Errorf("wrong model without priors: got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,294 @@
+// Package data loads tabular data, CSV or TSV, into inputs and
+// outputs of a GP. Columns are selected by name; fields are
+// numbers or ISO-8601 timestamps, which are turned into numeric
+// time in units since an origin. Rows with missing values are
+// skipped.
+package data
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type Options are the options of loading.
+type Options struct {
+	// Field delimiter; when 0, the delimiter is a tab if the
+	// first line contains a tab, and a comma otherwise.
+	Comma rune
+	// When true, the first row holds the names of the columns.
+	// Otherwise, the columns are named by their numbers from 1.
+	Header bool
+	// Names of the input columns; when empty, all columns but
+	// the output.
+	Inputs []string
+	// Name of the output column; when empty, the last column.
+	Output string
+	// When true, there is no output column, as in a file of
+	// query points; Table.Y is then nil.
+	NoOutput bool
+	// Values denoting a missing value; when nil, NA (see NA).
+	NA []string
+	// Origin and unit of numeric time; when zero, the origin
+	// is the first timestamp in the data, and the unit is a day.
+	Origin time.Time
+	Unit   time.Duration
+}
+
+// NA are the default values denoting a missing value; numbers
+// which are not a number, such as NaN, are missing as well.
+var NA = []string{"", "NA", "N/A", "NaN", "nan", "null"}
+
+// Type Table is the loaded data.
+type Table struct {
+	Inputs []string    // names of the input columns
+	Output string      // name of the output column, if any
+	X      [][]float64 // inputs, ready for GP.Absorb
+	Y      []float64   // outputs
+	Lines  []int       // of the rows in the file, from 1
+	// Number of rows skipped because of missing values
+	Skipped int
+	// Origin and unit of numeric time, if any of the selected
+	// columns holds timestamps
+	Origin time.Time
+	Unit   time.Duration
+}
+
+// Time returns the timestamp of numeric time x.
+func (t *Table) Time(x float64) time.Time {
+	return t.Origin.Add(time.Duration(math.Round(x * float64(t.Unit))))
+}
+
+// Type Error is an error in a field of the data.
+type Error struct {
+	Line   int    // from 1
+	Column int    // from 1
+	Name   string // of the column
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d, column %d (%s): %v",
+		e.Line, e.Column, e.Name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Type kind is the kind of values in a column, determined by
+// the first value which is not missing.
+type kind int
+
+const (
+	unknown kind = iota
+	number
+	timestamp
+)
+
+// layouts are the accepted layouts of ISO-8601 timestamps;
+// timestamps without a time zone are in UTC.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime parses an ISO-8601 timestamp.
+func parseTime(s string) (t time.Time, err error) {
+	for _, layout := range layouts {
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return t, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// Load loads the data. Errors in the fields are of type *Error;
+// errors of the CSV syntax are of type *csv.ParseError.
+func Load(r io.Reader, opts Options) (*Table, error) {
+	br := bufio.NewReader(r)
+	comma := opts.Comma
+	if comma == 0 {
+		// Detect the delimiter on the first line.
+		line, err := br.Peek(br.Size())
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if i := strings.IndexByte(string(line), '\n'); i != -1 {
+			line = line[:i]
+		}
+		comma = ','
+		if strings.ContainsRune(string(line), '\t') {
+			comma = '\t'
+		}
+	}
+	rdr := csv.NewReader(br)
+	rdr.Comma = comma
+	rdr.TrimLeadingSpace = true
+
+	// Columns
+	first, err := rdr.Read()
+	if err == io.EOF {
+		return nil, errors.New("no data")
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(first))
+	for i := range names {
+		if opts.Header {
+			names[i] = strings.TrimSpace(first[i])
+		} else {
+			names[i] = strconv.Itoa(i + 1)
+		}
+	}
+	column := func(name string) (int, error) {
+		for i := range names {
+			if names[i] == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no column %q", name)
+	}
+	out := len(names) - 1
+	if opts.NoOutput {
+		out = -1
+	} else if opts.Output != "" {
+		if out, err = column(opts.Output); err != nil {
+			return nil, err
+		}
+	}
+	var ins []int
+	if len(opts.Inputs) == 0 {
+		for i := range names {
+			if i != out {
+				ins = append(ins, i)
+			}
+		}
+	} else {
+		for _, name := range opts.Inputs {
+			i, err := column(name)
+			if err != nil {
+				return nil, err
+			}
+			ins = append(ins, i)
+		}
+	}
+	if len(ins) == 0 {
+		return nil, errors.New("no input columns")
+	}
+
+	t := &Table{Origin: opts.Origin, Unit: opts.Unit}
+	if out != -1 {
+		t.Output = names[out]
+	}
+	for _, i := range ins {
+		t.Inputs = append(t.Inputs, names[i])
+	}
+	if t.Unit == 0 {
+		t.Unit = 24 * time.Hour
+	}
+	na := make(map[string]bool)
+	if opts.NA == nil {
+		opts.NA = NA
+	}
+	for _, s := range opts.NA {
+		na[s] = true
+	}
+
+	// Rows
+	kinds := make([]kind, len(names))
+	hasTime := false
+	// parse parses field i of the record, and reports whether
+	// the value is missing.
+	parse := func(record []string, i int) (v float64, ok bool, err error) {
+		s := strings.TrimSpace(record[i])
+		if na[s] {
+			return 0, false, nil
+		}
+		if kinds[i] == unknown {
+			kinds[i] = number
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				if _, err := parseTime(s); err == nil {
+					kinds[i] = timestamp
+				}
+			}
+		}
+		switch kinds[i] {
+		case number:
+			v, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				return 0, false, fmt.Errorf("invalid number %q", s)
+			}
+			return v, !math.IsNaN(v), nil
+		default:
+			ts, err := parseTime(s)
+			if err != nil {
+				return 0, false, err
+			}
+			if !hasTime {
+				hasTime = true
+				if t.Origin.IsZero() {
+					t.Origin = ts
+				}
+			}
+			return float64(ts.Sub(t.Origin)) / float64(t.Unit), true, nil
+		}
+	}
+
+	record := first
+	if opts.Header {
+		record, err = rdr.Read()
+	}
+	for ; err != io.EOF; record, err = rdr.Read() {
+		if err != nil {
+			return nil, err
+		}
+		line, _ := rdr.FieldPos(0)
+		x := make([]float64, len(ins))
+		var (
+			y   float64
+			ok  = true
+			err error
+			i   = out
+		)
+		if out != -1 {
+			y, ok, err = parse(record, out)
+		}
+		for j := 0; ok && err == nil && j != len(ins); j++ {
+			i = ins[j]
+			x[j], ok, err = parse(record, i)
+		}
+		if err != nil {
+			return nil, &Error{Line: line, Column: i + 1, Name: names[i],
+				Err: err}
+		}
+		if !ok {
+			t.Skipped++
+			continue
+		}
+		t.X = append(t.X, x)
+		if out != -1 {
+			t.Y = append(t.Y, y)
+		}
+		t.Lines = append(t.Lines, line)
+	}
+	if !hasTime {
+		t.Origin, t.Unit = time.Time{}, 0
+	}
+	if len(t.X) == 0 {
+		return nil, errors.New("no data")
+	}
+	return t, nil
+}
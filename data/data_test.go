@@ -0,0 +1,186 @@
+package data
+
+import (
+	"encoding/csv"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		data   string
+		opts   Options
+		inputs []string
+		output string
+		x      [][]float64
+		y      []float64
+		lines  []int
+		skip   int
+	}{
+		{"numeric",
+			"0,1\n0.5,2\n1,3\n",
+			Options{},
+			[]string{"1"}, "2",
+			[][]float64{{0}, {0.5}, {1}}, []float64{1, 2, 3},
+			[]int{1, 2, 3}, 0},
+		{"header",
+			"a,b,c\n1,2,3\n4,5,6\n",
+			Options{Header: true, Inputs: []string{"c", "a"}, Output: "b"},
+			[]string{"c", "a"}, "b",
+			[][]float64{{3, 1}, {6, 4}}, []float64{2, 5},
+			[]int{2, 3}, 0},
+		{"tsv",
+			"x\ty\n1\t2\n3\t4\n",
+			Options{Header: true},
+			[]string{"x"}, "y",
+			[][]float64{{1}, {3}}, []float64{2, 4},
+			[]int{2, 3}, 0},
+		{"missing",
+			"x,y\n1,2\nNA,3\n4,\n5, NaN\n6,7\n",
+			Options{Header: true},
+			[]string{"x"}, "y",
+			[][]float64{{1}, {6}}, []float64{2, 7},
+			[]int{2, 6}, 3},
+		{"custom missing",
+			"1,-\n2,3\n",
+			Options{NA: []string{"-"}},
+			[]string{"1"}, "2",
+			[][]float64{{2}}, []float64{3},
+			[]int{2}, 1},
+		{"output first",
+			"y,x\n1,2\n",
+			Options{Header: true, Output: "y"},
+			[]string{"x"}, "y",
+			[][]float64{{2}}, []float64{1},
+			[]int{2}, 0},
+		{"no output",
+			"a,b\n1,2\n3,NA\n",
+			Options{Header: true, NoOutput: true},
+			[]string{"a", "b"}, "",
+			[][]float64{{1, 2}}, nil,
+			[]int{2}, 1},
+		{"timestamps",
+			"date,value\n2020-01-01,1\n2020-01-02T12:00:00Z,2\n" +
+				"2020-01-04 00:00,3\n",
+			Options{Header: true},
+			[]string{"date"}, "value",
+			[][]float64{{0}, {1.5}, {3}}, []float64{1, 2, 3},
+			[]int{2, 3, 4}, 0},
+		{"hours from origin",
+			"2020-01-01T01:00:00+01:00,1\n2020-01-01T03:00:00Z,2\n",
+			Options{
+				Origin: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				Unit:   time.Hour,
+			},
+			[]string{"1"}, "2",
+			[][]float64{{0}, {3}}, []float64{1, 2},
+			[]int{1, 2}, 0},
+	} {
+		tab, err := Load(strings.NewReader(c.data), c.opts)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tab.Inputs, c.inputs) || tab.Output != c.output {
+			t.Errorf("%s: wrong columns: got %v, %q, want %v, %q",
+				c.name, tab.Inputs, tab.Output, c.inputs, c.output)
+		}
+		if !reflect.DeepEqual(tab.X, c.x) || !reflect.DeepEqual(tab.Y, c.y) {
+			t.Errorf("%s: wrong data: got %v, %v, want %v, %v",
+				c.name, tab.X, tab.Y, c.x, c.y)
+		}
+		if !reflect.DeepEqual(tab.Lines, c.lines) {
+			t.Errorf("%s: wrong lines: got %v, want %v",
+				c.name, tab.Lines, c.lines)
+		}
+		if tab.Skipped != c.skip {
+			t.Errorf("%s: wrong number of skipped rows: got %d, want %d",
+				c.name, tab.Skipped, c.skip)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	tab, err := Load(strings.NewReader("2021-03-01T06:00:00Z,1\n"+
+		"2021-03-02T18:00:00Z,2\n"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2021, 3, 1, 6, 0, 0, 0, time.UTC)
+	if !tab.Origin.Equal(want) || tab.Unit != 24*time.Hour {
+		t.Errorf("wrong origin or unit: got %v, %v", tab.Origin, tab.Unit)
+	}
+	want = time.Date(2021, 3, 2, 18, 0, 0, 0, time.UTC)
+	if got := tab.Time(tab.X[1][0]); !got.Equal(want) {
+		t.Errorf("wrong time: got %v, want %v", got, want)
+	}
+	if math.Abs(tab.X[1][0]-1.5) > 1e-12 {
+		t.Errorf("wrong numeric time: got %v, want 1.5", tab.X[1][0])
+	}
+
+	// There is no origin without timestamps.
+	tab, err = Load(strings.NewReader("1,2\n"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tab.Origin.IsZero() || tab.Unit != 0 {
+		t.Errorf("origin or unit without timestamps: %v, %v",
+			tab.Origin, tab.Unit)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		name         string
+		data         string
+		opts         Options
+		line, column int
+	}{
+		{"number", "1,2\n3,x\n", Options{}, 2, 2},
+		{"header", "a,b\n1,2\n\n3,4\n5,six\n", Options{Header: true}, 5, 2},
+		{"timestamp", "2020-01-01,1\n2,1\n", Options{}, 2, 1},
+		{"neither", "a,1\n", Options{}, 1, 1},
+		{"tsv", "a\tb\tc\n1\t2\t3\n1\tz\t3\n",
+			Options{Header: true, Inputs: []string{"a", "b"}}, 3, 2},
+	} {
+		_, err := Load(strings.NewReader(c.data), c.opts)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: wrong error %v", c.name, err)
+			continue
+		}
+		if e.Line != c.line || e.Column != c.column {
+			t.Errorf("%s: wrong position: got %d:%d, want %d:%d: %v",
+				c.name, e.Line, e.Column, c.line, c.column, err)
+		}
+	}
+
+	// Other errors
+	for _, c := range []struct {
+		name string
+		data string
+		opts Options
+	}{
+		{"empty", "", Options{}},
+		{"only header", "a,b\n", Options{Header: true}},
+		{"all missing", "1,NA\n", Options{}},
+		{"no column", "a,b\n1,2\n", Options{Header: true, Output: "c"}},
+		{"no inputs", "1\n2\n", Options{}},
+	} {
+		if _, err := Load(strings.NewReader(c.data), c.opts); err == nil {
+			t.Errorf("%s: no error", c.name)
+		}
+	}
+
+	// CSV syntax errors are reported by the CSV reader.
+	_, err := Load(strings.NewReader("1,2\n3,4,5\n"), Options{})
+	var pe *csv.ParseError
+	if !errors.As(err, &pe) || pe.Line != 2 {
+		t.Errorf("wrong error for a ragged row: %v", err)
+	}
+}
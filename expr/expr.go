@@ -0,0 +1,187 @@
+// Package expr builds kernels from expressions, such as
+//   c*Matern52(l) + Periodic(l2, p)*Normal(l3) + UniformNoise(s)
+// Kernels of the library are combined by sums and products,
+// and multiplied by parameters, such as the output scale c, or
+// by numbers. The arguments of a kernel are the names of its
+// parameters, or numbers for fixed values; parameters with the
+// same name are shared. The parameters of a kernel written
+// without arguments are prefixed by the kernel's name, as in
+// matern52.l. ConstantNoise takes the standard deviation of the
+// noise, a number. The inputs are one-dimensional.
+//
+// The terms of the sum with noise kernels, UniformNoise and
+// ConstantNoise, form the noise kernel, the other terms form
+// the similarity kernel. The parameters are log-transformed.
+package expr
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+)
+
+// Parse parses a kernel expression into the similarity and the
+// noise kernels. The noise kernel is nil if there are no noise
+// terms, and the GP then adds the default noise. Parse errors
+// are of type *Error.
+func Parse(s string) (simil, noise gp.Kernel, err error) {
+	root, err := parse(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sterms, nterms []node
+	for _, term := range terms(root) {
+		if term.kind()&noiseKind != 0 {
+			nterms = append(nterms, term)
+		} else {
+			sterms = append(sterms, term)
+		}
+	}
+	if len(sterms) == 0 {
+		return nil, nil, &Error{Expr: s, Col: 1, Msg: "no similarity kernel"}
+	}
+
+	sns := newNamespace()
+	sroot := join(sterms)
+	sroot.bind(sns)
+	simil = &Kernel{expression{root: sroot, names: sns.names}}
+	if len(nterms) == 0 {
+		return simil, nil, nil
+	}
+	nns := newNamespace()
+	nroot := join(nterms)
+	nroot.bind(nns)
+	for _, name := range nns.names {
+		if _, ok := sns.index[name]; ok {
+			return nil, nil, &Error{
+				Expr: s,
+				Col:  nns.pos[name] + 1,
+				Msg:  "parameter " + name + " in both similarity and noise kernels",
+			}
+		}
+	}
+	noise = &Noise{expression{root: nroot, names: nns.names}}
+	return simil, noise, nil
+}
+
+// terms returns the terms of the sum at the root which are
+// either similarity or noise.
+func terms(n node) []node {
+	if s, ok := n.(*sum); ok && s.kind()&noiseKind != 0 {
+		return append(terms(s.a), terms(s.b)...)
+	}
+	return []node{n}
+}
+
+// join returns the sum of the terms.
+func join(terms []node) node {
+	n := terms[0]
+	for _, term := range terms[1:] {
+		n = &sum{n, term}
+	}
+	return n
+}
+
+// Type expression is an elemental model computing a kernel
+// through the expression tree. The gradient is computed
+// analytically, without the automatic differentiation tape,
+// hence expressions are replicated rather than serialized in
+// parallel mode (see gp.Replicable).
+type expression struct {
+	root  node
+	names []string
+	grad  []float64
+}
+
+func (e *expression) Observe(x []float64) float64 {
+	// The gradient is allocated on every call, since the caller
+	// may keep it.
+	v, grad := e.root.eval(x, len(e.names))
+	if grad == nil {
+		grad = make([]float64, len(x))
+	}
+	e.grad = grad
+	return v
+}
+
+func (e *expression) Gradient() []float64 {
+	return e.grad
+}
+
+func (e *expression) NTheta() int {
+	return len(e.names)
+}
+
+func (e *expression) Names() []string {
+	return e.names
+}
+
+func (e *expression) String() string {
+	return e.root.String()
+}
+
+// Type Kernel is a similarity kernel defined by an expression.
+// Blocks of covariances are computed by the kernels of the
+// library, bypassing automatic differentiation.
+type Kernel struct {
+	expression
+}
+
+// Replicate returns a kernel for a concurrent worker, see
+// gp.Replicable.
+func (k *Kernel) Replicate() gp.Kernel {
+	return &Kernel{expression{root: k.root, names: k.names}}
+}
+
+func (k *Kernel) Covariances(
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) ([]float64, [][]float64) {
+	return k.block(theta, len(xa)*len(xb),
+		func(prim primitive, theta []float64, withGrad bool) (
+			[]float64, [][]float64,
+		) {
+			return prim.(batch).Covariances(theta, xa, xb, withGrad)
+		}, withGrad)
+}
+
+func (k *Kernel) DiffCovariances(
+	theta []float64,
+	r [][]float64,
+	withGrad bool,
+) ([]float64, [][]float64) {
+	return k.block(theta, len(r[0]),
+		func(prim primitive, theta []float64, withGrad bool) (
+			[]float64, [][]float64,
+		) {
+			return prim.(batch).DiffCovariances(theta, r, withGrad)
+		}, withGrad)
+}
+
+// block computes a block of n covariances, with zero
+// derivatives allocated.
+func (k *Kernel) block(
+	theta []float64,
+	n int,
+	cov blockFunc,
+	withGrad bool,
+) ([]float64, [][]float64) {
+	cv, dcv := k.root.block(theta, n, cov, withGrad)
+	for p := range dcv {
+		if dcv[p] == nil {
+			dcv[p] = make([]float64, n)
+		}
+	}
+	return cv, dcv
+}
+
+// Type Noise is a noise kernel defined by an expression.
+type Noise struct {
+	expression
+}
+
+// Replicate returns a kernel for a concurrent worker, see
+// gp.Replicable.
+func (k *Noise) Replicate() gp.Kernel {
+	return &Noise{expression{root: k.root, names: k.names}}
+}
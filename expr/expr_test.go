@@ -0,0 +1,304 @@
+package expr
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		expr         string
+		simil, noise string
+		snames       []string
+		nnames       []string
+	}{
+		{"c*Matern52(l) + Periodic(l2,p)*Normal(l3) + UniformNoise",
+			"c*Matern52(l) + Periodic(l2, p)*Normal(l3)", "UniformNoise",
+			[]string{"c", "l", "l2", "p", "l3"},
+			[]string{"uniformnoise.s"}},
+		{"c*Matern52", "c*Matern52", "",
+			[]string{"c", "matern52.l"}, nil},
+		{"0.01*UniformNoise(s) + c*(Normal(l) + Matern32(l))",
+			"c*(Normal(l) + Matern32(l))", "0.01*UniformNoise(s)",
+			[]string{"c", "l"}, []string{"s"}},
+		{"c*Periodic(l, 7) + ConstantNoise(0.1)",
+			"c*Periodic(l, 7)", "ConstantNoise(0.1)",
+			[]string{"c", "l"}, []string{}},
+		{"(Normal(l) + UniformNoise(s))", "Normal(l)", "UniformNoise(s)",
+			[]string{"l"}, []string{"s"}},
+		{"2", "2", "", []string{}, nil},
+	} {
+		simil, noise, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if s := simil.(*Kernel).String(); s != c.simil {
+			t.Errorf("%q: wrong similarity: got %q, want %q",
+				c.expr, s, c.simil)
+		}
+		if names := gp.KernelNames(simil); !equalNames(names, c.snames) {
+			t.Errorf("%q: wrong similarity names: got %v, want %v",
+				c.expr, names, c.snames)
+		}
+		if c.noise == "" {
+			if noise != nil {
+				t.Errorf("%q: got noise %v, want none", c.expr, noise)
+			}
+			continue
+		}
+		if noise == nil {
+			t.Errorf("%q: got no noise, want %q", c.expr, c.noise)
+			continue
+		}
+		if s := noise.(*Noise).String(); s != c.noise {
+			t.Errorf("%q: wrong noise: got %q, want %q", c.expr, s, c.noise)
+		}
+		if names := gp.KernelNames(noise); !equalNames(names, c.nnames) {
+			t.Errorf("%q: wrong noise names: got %v, want %v",
+				c.expr, names, c.nnames)
+		}
+	}
+}
+
+func equalNames(a, b []string) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		col  int
+	}{
+		{"", 1},
+		{"c*", 3},
+		{"c*Matern5(l)", 3},
+		{"c*Matern52(l, p)", 3},
+		{"Periodic(l,)", 12},
+		{"Normal(l) + UniformNoise(s", 27},
+		{"Normal(l) $ c", 11},
+		{"Normal(l))", 10},
+		{"Normal(Matern52)", 8},
+		{"Normal(l)*UniformNoise(s)", 1},
+		{"(Normal(l) + UniformNoise(s))*c", 1},
+		{"Normal(l) + ConstantNoise", 13},
+		{"Normal(l) + ConstantNoise(s)", 27},
+		{"UniformNoise(s)", 1},
+		{"Normal(s) + UniformNoise(s)", 26},
+		{"1e*Normal", 1},
+	} {
+		_, _, err := Parse(c.expr)
+		if err == nil {
+			t.Errorf("%q: no error", c.expr)
+			continue
+		}
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: wrong error type %T", c.expr, err)
+			continue
+		}
+		if e.Col != c.col {
+			t.Errorf("%q: wrong column: got %d, want %d: %v",
+				c.expr, e.Col, c.col, err)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	simil, noise, err := Parse(
+		"c*Matern52(l) + Periodic(l2, 3)*Normal(l) + 0.5*UniformNoise(s)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c, l, l2, xa, xb
+	x := []float64{1.5, 0.7, 1.2, 0.3, -0.4}
+	got := simil.Observe(x)
+	want := x[0]*kernel.Matern52.Observe([]float64{x[1], x[3], x[4]}) +
+		kernel.Periodic.Observe([]float64{x[2], 3, x[3], x[4]})*
+			kernel.Normal.Observe([]float64{x[1], x[3], x[4]})
+	if math.Abs(got-want) > 1e-12 {
+		t.Errorf("wrong similarity: got %.6g, want %.6g", got, want)
+	}
+	got, want = noise.Observe([]float64{0.2, 0.3}), 0.5*0.2*0.2
+	if math.Abs(got-want) > 1e-12 {
+		t.Errorf("wrong noise: got %.6g, want %.6g", got, want)
+	}
+
+	// The gradient, by the parameters and the inputs, agrees
+	// with finite differences.
+	const (
+		dx  = 1e-7
+		eps = 1e-5
+	)
+	for _, c := range []struct {
+		k gp.Kernel
+		x []float64
+	}{
+		{simil, x},
+		{simil, []float64{1.5, 0.7, 1.2, -0.4, 0.3}},
+		{simil, []float64{1.5, 0.7, 1.2, 0.3, 0.3}},
+		{noise, []float64{0.2, 0.3}},
+	} {
+		v := c.k.Observe(c.x)
+		grad := model.Gradient(c.k)
+		if len(grad) != len(c.x) {
+			t.Fatalf("%v: wrong gradient length: got %d, want %d",
+				c.k, len(grad), len(c.x))
+		}
+		for i := range c.x {
+			xi := append([]float64{}, c.x...)
+			xi[i] += dx
+			if d := (c.k.Observe(xi) - v) / dx; math.Abs(d-grad[i]) > eps {
+				t.Errorf("%v: d/dx%d mismatch: got %.6g, want %.6g",
+					c.k, i, grad[i], d)
+			}
+		}
+	}
+}
+
+// Type elemental hides the block interfaces of the kernel.
+type elemental struct {
+	gp.Kernel
+}
+
+func (k elemental) Gradient() []float64 {
+	return model.Gradient(k.Kernel)
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		xa = [][]float64{{0}, {0.5}, {1.5}, {2}, {3}}
+		xb = [][]float64{{-1}, {0.25}, {2.5}, {4}}
+	)
+	for _, expr := range []string{
+		"c*Matern52(l)",
+		"c*Periodic(l, p) + Normal(l2)",
+		"Matern32(l)*(c + Normal(l2)) + 2",
+		"Periodic(l, l)",
+	} {
+		simil, _, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("%q: %v", expr, err)
+		}
+		theta := []float64{1.5, 0.7, 1.2, 0.9}[:simil.NTheta()]
+
+		// Block covariances agree with Observe.
+		k, dk := simil.(gp.Batch).Covariances(theta, xa, xb, true)
+		e, de := gp.Covariances(elemental{simil}, theta, xa, xb, true)
+		for i := range e {
+			if math.Abs(k[i]-e[i]) > 1e-12 {
+				t.Errorf("%q: wrong covariances: got %v, want %v", expr, k, e)
+				break
+			}
+		}
+		for p := range de {
+			for i := range de[p] {
+				if math.Abs(dk[p][i]-de[p][i]) > 1e-12 {
+					t.Errorf("%q: wrong derivatives by %d: got %v, want %v",
+						expr, p, dk[p], de[p])
+					break
+				}
+			}
+		}
+
+		// Covariances from differences agree with the block.
+		r := [][]float64{make([]float64, len(xa)*len(xb))}
+		for i := range xa {
+			for j := range xb {
+				r[0][i*len(xb)+j] = xa[i][0] - xb[j][0]
+			}
+		}
+		kr, dkr := simil.(gp.Stationary).DiffCovariances(theta, r, true)
+		for i := range k {
+			if math.Abs(k[i]-kr[i]) > 1e-12 {
+				t.Errorf("%q: wrong covariances from differences: "+
+					"got %v, want %v", expr, kr, k)
+				break
+			}
+		}
+		for p := range dk {
+			for i := range dk[p] {
+				if math.Abs(dk[p][i]-dkr[p][i]) > 1e-12 {
+					t.Errorf("%q: wrong derivatives from differences "+
+						"by %d: got %v, want %v", expr, p, dkr[p], dk[p])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestGP(t *testing.T) {
+	// An expression kernel fits as the kernel written in Go.
+	var (
+		x = [][]float64{{0}, {0.5}, {1.5}, {2}, {3}}
+		y = []float64{0.1, 0.6, 0.4, -0.2, -0.5}
+	)
+	simil, noise, err := Parse("c*Matern32(l) + UniformNoise(s)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theta := []float64{0.2, -0.3, -1}
+	g := &gp.GP{NDim: 1, Simil: simil, Noise: noise, X: x, Y: y}
+	h := &gp.GP{NDim: 1, Simil: &scaledMatern32{}, Noise: kernel.UniformNoise,
+		X: x, Y: y}
+	got, want := g.Observe(theta), h.Observe(theta)
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("wrong LML: got %.6g, want %.6g", got, want)
+	}
+	ggrad, hgrad := model.Gradient(g), model.Gradient(h)
+	for i := range hgrad {
+		if math.Abs(ggrad[i]-hgrad[i]) > 1e-9 {
+			t.Errorf("wrong gradient: got %v, want %v", ggrad, hgrad)
+			break
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	// Expressions are evaluated concurrently in parallel mode.
+	x := make([][]float64, 50)
+	y := make([]float64, len(x))
+	for i := range x {
+		x[i] = []float64{0.1 * float64(i)}
+		y[i] = math.Sin(x[i][0])
+	}
+	theta := []float64{0.2, -0.3, 0.5, -1}
+	lml := make([]float64, 2)
+	for i, parallel := range []bool{false, true} {
+		simil, noise, err := Parse("c*Matern52(l)*Periodic(l, p) + UniformNoise(s)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := &gp.GP{NDim: 1, Simil: simil, Noise: noise, X: x, Y: y,
+			Parallel: parallel, Workers: 4}
+		lml[i] = g.Observe(theta)
+	}
+	if math.Abs(lml[0]-lml[1]) > 1e-9 {
+		t.Errorf("parallel LML differs: got %.6g, want %.6g", lml[1], lml[0])
+	}
+}
+
+// Type scaledMatern32 is c*Matern32(l) written in Go.
+type scaledMatern32 struct {
+	grad []float64
+}
+
+func (k *scaledMatern32) Observe(x []float64) float64 {
+	v := kernel.Matern32.Observe(x[1:])
+	k.grad = append([]float64{v}, model.Gradient(kernel.Matern32)...)
+	for i := 1; i != len(k.grad); i++ {
+		k.grad[i] *= x[0]
+	}
+	return x[0] * v
+}
+
+func (k *scaledMatern32) Gradient() []float64 {
+	return k.grad
+}
+
+func (*scaledMatern32) NTheta() int { return 2 }
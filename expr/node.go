@@ -0,0 +1,353 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Expression tree
+//
+// The parameters of a kernel are numbered in the order of their
+// first appearance in the expression. A node is evaluated on
+// the arguments of the kernel's Observe, the parameters followed
+// by the inputs, and returns the value and the gradient by the
+// arguments; a nil gradient is zero. A node of a similarity
+// kernel also computes blocks of covariances and their
+// derivatives by the parameters; a nil derivative is zero.
+
+// Type kind is the set of kinds of primitive kernels in a
+// subtree.
+type kind int
+
+const (
+	similKind kind = 1 << iota
+	noiseKind
+)
+
+// Type blockFunc computes a block of covariances of primitive
+// kernel k with parameters theta, see gp.Batch.
+type blockFunc func(k primitive, theta []float64, withGrad bool) (
+	[]float64, [][]float64)
+
+// Type node is a node of the expression tree.
+type node interface {
+	// eval returns the value and the gradient by x, the
+	// ntheta parameters followed by the inputs.
+	eval(x []float64, ntheta int) (v float64, grad []float64)
+	// block returns a block of n covariances, and, when
+	// withGrad is true, the derivatives by theta.
+	block(theta []float64, n int, cov blockFunc, withGrad bool) (
+		k []float64, dk [][]float64)
+	// bind assigns the indices of the parameters in namespace ns.
+	bind(ns *namespace)
+	kind() kind
+	String() string
+}
+
+// Type namespace numbers the parameters of a kernel by name.
+type namespace struct {
+	names []string
+	index map[string]int
+	pos   map[string]int // of the first appearance, for errors
+}
+
+func newNamespace() *namespace {
+	return &namespace{
+		index: make(map[string]int),
+		pos:   make(map[string]int),
+	}
+}
+
+// add returns the index of the named parameter, adding it if
+// the parameter is new.
+func (ns *namespace) add(name string, pos int) int {
+	if i, ok := ns.index[name]; ok {
+		return i
+	}
+	i := len(ns.names)
+	ns.names = append(ns.names, name)
+	ns.index[name], ns.pos[name] = i, pos
+	return i
+}
+
+// Type constant is a number.
+type constant float64
+
+func (c constant) eval(x []float64, ntheta int) (float64, []float64) {
+	return float64(c), nil
+}
+
+func (c constant) block(
+	theta []float64, n int, cov blockFunc, withGrad bool,
+) ([]float64, [][]float64) {
+	return fill(n, float64(c)), zeroGrad(theta, withGrad)
+}
+
+func (constant) bind(*namespace) {}
+
+func (constant) kind() kind { return 0 }
+
+func (c constant) String() string {
+	return strconv.FormatFloat(float64(c), 'g', -1, 64)
+}
+
+// Type param is a parameter, by itself a factor of a product,
+// such as the output scale.
+type param struct {
+	name string
+	pos  int
+	i    int
+}
+
+func (p *param) eval(x []float64, ntheta int) (float64, []float64) {
+	grad := make([]float64, len(x))
+	grad[p.i] = 1
+	return x[p.i], grad
+}
+
+func (p *param) block(
+	theta []float64, n int, cov blockFunc, withGrad bool,
+) ([]float64, [][]float64) {
+	dk := zeroGrad(theta, withGrad)
+	if withGrad {
+		dk[p.i] = fill(n, 1)
+	}
+	return fill(n, theta[p.i]), dk
+}
+
+func (p *param) bind(ns *namespace) {
+	p.i = ns.add(p.name, p.pos)
+}
+
+func (*param) kind() kind { return 0 }
+
+func (p *param) String() string {
+	return p.name
+}
+
+// Type arg is an argument of a primitive kernel, either a
+// parameter or a fixed value.
+type arg struct {
+	name  string // empty if fixed
+	value float64
+	pos   int
+	i     int
+}
+
+func (a arg) String() string {
+	if a.name == "" {
+		return constant(a.value).String()
+	}
+	return a.name
+}
+
+// Type call is a primitive kernel applied to arguments.
+type call struct {
+	name string
+	k    primitive
+	grad gradFunc
+	args []arg
+	bare bool // written without arguments
+	kd   kind
+}
+
+func (c *call) eval(x []float64, ntheta int) (float64, []float64) {
+	ktheta := make([]float64, len(c.args))
+	for j, a := range c.args {
+		if a.name == "" {
+			ktheta[j] = a.value
+		} else {
+			ktheta[j] = x[a.i]
+		}
+	}
+	v, kgrad := c.grad(ktheta, x[ntheta:])
+
+	grad := make([]float64, len(x))
+	for j, a := range c.args {
+		if a.name != "" {
+			grad[a.i] += kgrad[j]
+		}
+	}
+	for j := ntheta; j != len(x); j++ {
+		grad[j] = kgrad[len(c.args)+j-ntheta]
+	}
+	return v, grad
+}
+
+func (c *call) block(
+	theta []float64, n int, cov blockFunc, withGrad bool,
+) ([]float64, [][]float64) {
+	ktheta := make([]float64, len(c.args))
+	for j, a := range c.args {
+		if a.name == "" {
+			ktheta[j] = a.value
+		} else {
+			ktheta[j] = theta[a.i]
+		}
+	}
+	k, kdk := cov(c.k, ktheta, withGrad)
+	dk := zeroGrad(theta, withGrad)
+	if withGrad {
+		for j, a := range c.args {
+			if a.name != "" {
+				dk[a.i] = add(dk[a.i], kdk[j])
+			}
+		}
+	}
+	return k, dk
+}
+
+func (c *call) bind(ns *namespace) {
+	for j := range c.args {
+		if c.args[j].name != "" {
+			c.args[j].i = ns.add(c.args[j].name, c.args[j].pos)
+		}
+	}
+}
+
+func (c *call) kind() kind { return c.kd }
+
+func (c *call) String() string {
+	if c.bare {
+		return c.name
+	}
+	args := make([]string, len(c.args))
+	for j := range c.args {
+		args[j] = c.args[j].String()
+	}
+	return c.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Type sum is the sum of two kernels.
+type sum struct {
+	a, b node
+}
+
+func (s *sum) eval(x []float64, ntheta int) (float64, []float64) {
+	va, ga := s.a.eval(x, ntheta)
+	vb, gb := s.b.eval(x, ntheta)
+	return va + vb, axpy(1, gb, axpy(1, ga, nil))
+}
+
+func (s *sum) block(
+	theta []float64, n int, cov blockFunc, withGrad bool,
+) ([]float64, [][]float64) {
+	ka, dka := s.a.block(theta, n, cov, withGrad)
+	kb, dkb := s.b.block(theta, n, cov, withGrad)
+	for i := range ka {
+		ka[i] += kb[i]
+	}
+	for p := range dka {
+		dka[p] = add(dka[p], dkb[p])
+	}
+	return ka, dka
+}
+
+func (s *sum) bind(ns *namespace) {
+	s.a.bind(ns)
+	s.b.bind(ns)
+}
+
+func (s *sum) kind() kind { return s.a.kind() | s.b.kind() }
+
+func (s *sum) String() string {
+	return s.a.String() + " + " + s.b.String()
+}
+
+// Type product is the product of two kernels.
+type product struct {
+	a, b node
+}
+
+func (p *product) eval(x []float64, ntheta int) (float64, []float64) {
+	va, ga := p.a.eval(x, ntheta)
+	vb, gb := p.b.eval(x, ntheta)
+	return va * vb, axpy(va, gb, axpy(vb, ga, nil))
+}
+
+func (p *product) block(
+	theta []float64, n int, cov blockFunc, withGrad bool,
+) ([]float64, [][]float64) {
+	ka, dka := p.a.block(theta, n, cov, withGrad)
+	kb, dkb := p.b.block(theta, n, cov, withGrad)
+	// d(ka kb) = dka kb + ka dkb
+	for q := range dka {
+		if dka[q] != nil {
+			for i := range dka[q] {
+				dka[q][i] *= kb[i]
+			}
+		}
+		if dkb[q] != nil {
+			for i := range dkb[q] {
+				dkb[q][i] *= ka[i]
+			}
+		}
+		dka[q] = add(dka[q], dkb[q])
+	}
+	for i := range ka {
+		ka[i] *= kb[i]
+	}
+	return ka, dka
+}
+
+func (p *product) bind(ns *namespace) {
+	p.a.bind(ns)
+	p.b.bind(ns)
+}
+
+func (p *product) kind() kind { return p.a.kind() | p.b.kind() }
+
+func (p *product) String() string {
+	return factor(p.a) + "*" + factor(p.b)
+}
+
+// factor returns the string of a factor of a product,
+// parenthesized if the factor is a sum.
+func factor(n node) string {
+	if _, ok := n.(*sum); ok {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+// fill returns a slice of n values v.
+func fill(n int, v float64) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+// zeroGrad returns zero derivatives by theta, or nil if withGrad
+// is false.
+func zeroGrad(theta []float64, withGrad bool) [][]float64 {
+	if !withGrad {
+		return nil
+	}
+	return make([][]float64, len(theta))
+}
+
+// axpy returns a*x + y, adding to y in place; nil x and y are
+// zero, and y is allocated if needed.
+func axpy(a float64, x, y []float64) []float64 {
+	if x == nil {
+		return y
+	}
+	if y == nil {
+		y = make([]float64, len(x))
+	}
+	for i := range x {
+		y[i] += a * x[i]
+	}
+	return y
+}
+
+// add returns x + y, adding y to x in place; nil x and y are
+// zero.
+func add(x, y []float64) []float64 {
+	if x == nil {
+		return y
+	}
+	return axpy(1, y, x)
+}
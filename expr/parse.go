@@ -0,0 +1,316 @@
+package expr
+
+import (
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Type primitive is a primitive kernel of the library.
+type primitive interface {
+	NTheta() int
+	Names() []string
+}
+
+// Type batch is a primitive similarity kernel computing blocks
+// of covariances, see gp.Batch and gp.Stationary.
+type batch interface {
+	Covariances(theta []float64, xa, xb [][]float64, withGrad bool) (
+		[]float64, [][]float64)
+	DiffCovariances(theta []float64, r [][]float64, withGrad bool) (
+		[]float64, [][]float64)
+}
+
+// Type gradFunc computes the value of a primitive kernel for
+// parameters theta and inputs x, and the gradient by the
+// parameters followed by the inputs, without the automatic
+// differentiation tape.
+type gradFunc func(theta, x []float64) (float64, []float64)
+
+// primitives are the kernels of the library by name.
+var primitives = map[string]struct {
+	k    primitive
+	grad gradFunc
+	kd   kind
+}{
+	"Normal":        {kernel.Normal, stationary(kernel.Normal), similKind},
+	"Periodic":      {kernel.Periodic, stationary(kernel.Periodic), similKind},
+	"Matern32":      {kernel.Matern32, stationary(kernel.Matern32), similKind},
+	"Matern52":      {kernel.Matern52, stationary(kernel.Matern52), similKind},
+	"UniformNoise":  {kernel.UniformNoise, uniformNoise, noiseKind},
+	"ConstantNoise": {kernel.UniformNoise, uniformNoise, noiseKind},
+}
+
+// stationary returns the gradient function of a stationary
+// kernel of the library, for a pair of one-dimensional inputs.
+func stationary(k interface {
+	DiffGradient(theta []float64, r float64) (float64, []float64)
+}) gradFunc {
+	return func(theta, x []float64) (float64, []float64) {
+		v, dk := k.DiffGradient(theta, x[0]-x[1])
+		n := len(theta)
+		grad := make([]float64, n+2)
+		copy(grad, dk[:n])
+		grad[n], grad[n+1] = dk[n], -dk[n]
+		return v, grad
+	}
+}
+
+// uniformNoise is the gradient function of UniformNoise, the
+// variance of the noise with standard deviation theta[0].
+func uniformNoise(theta, x []float64) (float64, []float64) {
+	grad := make([]float64, 1+len(x))
+	grad[0] = 2 * theta[0]
+	return theta[0] * theta[0], grad
+}
+
+// Type Error is a parse error at column Col, counted from 1, of
+// the expression.
+type Error struct {
+	Expr string
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kernel %q, column %d: %s", e.Expr, e.Col, e.Msg)
+}
+
+// Type token is a lexical token; kind is the character of an
+// operator or a parenthesis, 'a' for an identifier, '0' for a
+// number, and 0 for the end of the expression.
+type token struct {
+	kind rune
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case 0:
+		return "end of expression"
+	case 'a', '0':
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+// Type parser is a recursive-descent parser of kernel
+// expressions:
+//   sum     = product {"+" product}
+//   product = factor {"*" factor}
+//   factor  = number | name | name "(" [args] ")" | "(" sum ")"
+//   args    = arg {"," arg}
+//   arg     = number | name
+type parser struct {
+	expr string
+	pos  int   // of the next token
+	tok  token // current token
+}
+
+// parse parses the expression into a tree.
+func parse(s string) (n node, err error) {
+	p := &parser{expr: s}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*Error); ok {
+				n, err = nil, e
+				return
+			}
+			panic(r)
+		}
+	}()
+	p.next()
+	if p.tok.kind == 0 {
+		p.fail(p.tok.pos, "empty expression")
+	}
+	n = p.sum()
+	if p.tok.kind != 0 {
+		p.fail(p.tok.pos, "unexpected %v", p.tok)
+	}
+	return n, nil
+}
+
+// fail aborts parsing with an error at position pos.
+func (p *parser) fail(pos int, format string, args ...interface{}) {
+	panic(&Error{
+		Expr: p.expr,
+		Col:  pos + 1,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// next scans the next token.
+func (p *parser) next() {
+	for p.pos != len(p.expr) && unicode.IsSpace(rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.expr) {
+		p.tok = token{pos: start}
+		return
+	}
+	c := rune(p.expr[p.pos])
+	switch {
+	case strings.ContainsRune("+*(),", c):
+		p.pos++
+		p.tok = token{kind: c, text: string(c), pos: start}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos != len(p.expr) && isNameChar(rune(p.expr[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: 'a', text: p.expr[start:p.pos], pos: start}
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos != len(p.expr) && isNumberChar(p.expr, p.pos) {
+			p.pos++
+		}
+		p.tok = token{kind: '0', text: p.expr[start:p.pos], pos: start}
+	default:
+		p.fail(start, "unexpected character %q", c)
+	}
+}
+
+// isNameChar reports whether c may continue a name; names of
+// parameters may contain dots, as in matern52.l.
+func isNameChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+// isNumberChar reports whether the character at position i of
+// s continues a number, including the sign of an exponent.
+func isNumberChar(s string, i int) bool {
+	switch c := s[i]; {
+	case '0' <= c && c <= '9', c == '.', c == 'e', c == 'E':
+		return true
+	case c == '+' || c == '-':
+		return i > 0 && (s[i-1] == 'e' || s[i-1] == 'E')
+	default:
+		return false
+	}
+}
+
+// expect consumes a token of the kind, or fails.
+func (p *parser) expect(kind rune, what string) token {
+	if p.tok.kind != kind {
+		p.fail(p.tok.pos, "expected %s, got %v", what, p.tok)
+	}
+	t := p.tok
+	p.next()
+	return t
+}
+
+func (p *parser) sum() node {
+	n := p.product()
+	for p.tok.kind == '+' {
+		p.next()
+		n = &sum{n, p.product()}
+	}
+	return n
+}
+
+func (p *parser) product() node {
+	pos := p.tok.pos
+	n := p.factor()
+	for p.tok.kind == '*' {
+		p.next()
+		n = &product{n, p.factor()}
+		if n.kind() == similKind|noiseKind {
+			p.fail(pos, "similarity and noise kernels multiplied")
+		}
+	}
+	return n
+}
+
+func (p *parser) factor() node {
+	switch p.tok.kind {
+	case '0':
+		return constant(p.number())
+	case 'a':
+		name := p.tok
+		p.next()
+		if _, ok := primitives[name.text]; ok {
+			return p.call(name)
+		}
+		if p.tok.kind == '(' {
+			p.fail(name.pos, "unknown kernel %s", name.text)
+		}
+		return &param{name: name.text, pos: name.pos}
+	case '(':
+		p.next()
+		n := p.sum()
+		p.expect(')', "')'")
+		return n
+	default:
+		p.fail(p.tok.pos, "expected a kernel, a parameter, "+
+			"or a number, got %v", p.tok)
+		return nil
+	}
+}
+
+// number parses a number.
+func (p *parser) number() float64 {
+	t := p.expect('0', "a number")
+	v, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		p.fail(t.pos, "invalid number %s", t.text)
+	}
+	return v
+}
+
+// call parses the arguments of primitive kernel name, if any.
+func (p *parser) call(name token) node {
+	prim := primitives[name.text]
+	c := &call{name: name.text, k: prim.k, grad: prim.grad, kd: prim.kd}
+
+	if p.tok.kind != '(' {
+		if name.text == "ConstantNoise" {
+			p.fail(name.pos, "ConstantNoise: expected the noise, "+
+				"as in ConstantNoise(0.1)")
+		}
+		// The parameters of a bare kernel are prefixed by the
+		// kernel's name.
+		c.bare = true
+		names := kernel.Prefix(strings.ToLower(name.text), c.k.Names())
+		for _, n := range names {
+			c.args = append(c.args, arg{name: n, pos: name.pos})
+		}
+		return c
+	}
+
+	p.next()
+	if p.tok.kind != ')' {
+		for {
+			switch p.tok.kind {
+			case '0':
+				pos := p.tok.pos
+				c.args = append(c.args, arg{value: p.number(), pos: pos})
+			case 'a':
+				if _, ok := primitives[p.tok.text]; ok {
+					p.fail(p.tok.pos, "kernel %s as an argument", p.tok.text)
+				}
+				c.args = append(c.args, arg{name: p.tok.text, pos: p.tok.pos})
+				p.next()
+			default:
+				p.fail(p.tok.pos, "expected a parameter or a number, got %v",
+					p.tok)
+			}
+			if p.tok.kind != ',' {
+				break
+			}
+			p.next()
+		}
+	}
+	p.expect(')', "',' or ')'")
+
+	if len(c.args) != c.k.NTheta() {
+		p.fail(name.pos, "%s: %d arguments, want %d",
+			name.text, len(c.args), c.k.NTheta())
+	}
+	if name.text == "ConstantNoise" && c.args[0].name != "" {
+		p.fail(c.args[0].pos, "ConstantNoise: the noise must be a number")
+	}
+	return c
+}
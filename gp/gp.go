@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"gonum.org/v1/gonum/mat"
 	"math"
-	"sync"
 )
 
 // Type Kernel is the kernel interface, implemented by
@@ -16,6 +15,29 @@ type Kernel interface {
 	NTheta() int
 }
 
+// Type Transformed is the optional interface of a kernel
+// declaring transforms of the hyperparameters. Hyperparameters
+// of a kernel not implementing Transformed are log-transformed.
+type Transformed interface {
+	Transforms() []kernel.Transform
+}
+
+// Type Batch is the optional interface of a similarity kernel
+// computing a block of covariances at once, bypassing automatic
+// differentiation. Covariances returns the covariances of
+// inputs xa and xb for kernel parameters theta, in row-major
+// order, and, when withGrad is true, the derivatives by each of
+// the parameters, in the same layout. When the inputs are
+// inferred, covariances are computed through Observe. In
+// parallel mode, Covariances is called concurrently.
+type Batch interface {
+	Covariances(
+		theta []float64,
+		xa, xb [][]float64,
+		withGrad bool,
+	) (k []float64, dk [][]float64)
+}
+
 // Type GP is the barebone implementation of GP.
 type GP struct {
 	// Configuration
@@ -29,12 +51,33 @@ type GP struct {
 
 	// Optimizations
 	Parallel bool // when true, covariances are computed in parallel
-	withObs  bool // set to true when observations are inferred
+	Workers  int  // number of parallel workers, GOMAXPROCS by default
+	// When true, the kernels are assumed stationary, and for
+	// regularly spaced inputs the covariance matrix is Toeplitz
+	// and is factorized in O(n^2) instead of Cholesky decomposition.
+	// Noise depending on the inputs breaks the structure; the
+	// matrix is then decomposed by Cholesky.
+	Toeplitz bool
+	// When not nil, the covariance matrix is not formed, and
+	// computations are delegated to the solver (see Solver).
+	Solver  Solver
+	withObs bool // set to true when observations are inferred
+
+	// Derivatives of hyperparameter transforms, for the chain rule
+	dThetaSimil, dThetaNoise []float64
 
 	// Cached computations
-	L     mat.Cholesky    // Cholesky decomposition of K
-	Alpha *mat.VecDense   // K^-1 y
-	dK    []*mat.SymDense // gradient of K
+	L        mat.Cholesky    // Cholesky decomposition of K
+	Alpha    *mat.VecDense   // K^-1 y
+	dK       []*mat.SymDense // gradient of K by hyperparameters
+	dX       []*mat.Dense    // gradient of K by inputs, see inputGradient
+	toeplitz *toeplitz       // factorization of Toeplitz K, replaces L
+	// Differences of the inputs by tile, for stationary kernels,
+	// and the inputs for which they were computed
+	diffs map[tile][][]float64
+	diffX [][]float64
+	// K as an operator, when Solver is set
+	covariance *covariance
 }
 
 // Default noise, present for numerical stability; can
@@ -43,17 +86,71 @@ type GP struct {
 const nonoise = 1e-5
 
 func (gp *GP) defaults() {
-	if gp.Noise == nil {
-		gp.Noise = kernel.ConstantNoise(nonoise)
+	gp.Noise = DefaultNoise(gp.Noise)
+	gp.ThetaSimil = DefaultTheta(gp.Simil, gp.ThetaSimil)
+	gp.ThetaNoise = DefaultTheta(gp.Noise, gp.ThetaNoise)
+}
+
+// The defaults, the transforms, and the restoration of the
+// hyperparameters are shared by the GP backends (packages grid,
+// rff, statespace), whose kernels have parameters but need not
+// be kernels of GP.
+
+// DefaultNoise returns the noise kernel, or the default noise
+// if noise is nil.
+func DefaultNoise(noise Kernel) Kernel {
+	if noise == nil {
+		return kernel.ConstantNoise(nonoise)
 	}
+	return noise
+}
 
-	if len(gp.ThetaSimil) == 0 {
-		gp.ThetaSimil = make([]float64, gp.Simil.NTheta())
+// DefaultTheta returns theta, or zero parameters of kernel k if
+// theta is empty.
+func DefaultTheta(k interface{ NTheta() int }, theta []float64) []float64 {
+	if len(theta) == 0 {
+		return make([]float64, k.NTheta())
 	}
+	return theta
+}
 
-	if len(gp.ThetaNoise) == 0 {
-		gp.ThetaNoise = make([]float64, gp.Noise.NTheta())
+// KernelTransforms returns the transforms of the kernel's
+// hyperparameters, log unless the kernel implements
+// Transformed.
+func KernelTransforms(k interface{ NTheta() int }) []kernel.Transform {
+	if k, ok := k.(Transformed); ok {
+		return k.Transforms()
 	}
+	ts := make([]kernel.Transform, k.NTheta())
+	for i := range ts {
+		ts[i] = kernel.Log
+	}
+	return ts
+}
+
+// Restore maps the unconstrained values x of kernel parameters
+// into theta by transforms ts, and returns the derivatives of
+// the transforms, reusing dtheta when possible.
+func Restore(
+	ts []kernel.Transform,
+	theta, dtheta, x []float64,
+) []float64 {
+	if len(dtheta) != len(x) {
+		dtheta = make([]float64, len(x))
+	}
+	for i, t := range ts {
+		theta[i] = t.Forward(x[i])
+		dtheta[i] = t.Deriv(x[i])
+	}
+	return dtheta
+}
+
+// Transforms returns the transforms of the hyperparameters,
+// in the order of the arguments of Observe: similarity kernel
+// parameters followed by noise kernel parameters.
+func (gp *GP) Transforms() []kernel.Transform {
+	gp.defaults()
+	return append(KernelTransforms(gp.Simil), KernelTransforms(gp.Noise)...)
 }
 
 // addTodK adds gradient components to the corresponding
@@ -71,6 +168,17 @@ func (gp *GP) addTodK(
 	}
 }
 
+// addTodX adds the gradient components by the input in row i
+// to the corresponding elements of dX.
+func (gp *GP) addTodX(
+	i, j int,
+	jpar0 /* over grad */ int,
+	grad []float64) {
+	for d, dX := range gp.dX {
+		dX.Set(i, j, dX.At(i, j)+grad[jpar0+d])
+	}
+}
+
 const (
 	withoutGradient = false
 	withGradient    = true
@@ -87,14 +195,14 @@ func (gp *GP) Absorb(x [][]float64, y []float64) (err error) {
 }
 
 func (gp *GP) absorb(withGrad bool) (err error) {
+	gp.dX = nil
 	if withGrad {
-		// K's gradient by parameters and inputs
+		// K's gradient by parameters
+		gp.dK = make([]*mat.SymDense,
+			gp.Simil.NTheta()+gp.Noise.NTheta())
 		if gp.withObs {
-			gp.dK = make([]*mat.SymDense,
-				gp.Simil.NTheta()+gp.Noise.NTheta()+gp.NDim*len(gp.X))
-		} else {
-			gp.dK = make([]*mat.SymDense,
-				gp.Simil.NTheta()+gp.Noise.NTheta())
+			// and by inputs, a single matrix per dimension
+			gp.dX = make([]*mat.Dense, gp.NDim)
 		}
 	}
 
@@ -103,56 +211,109 @@ func (gp *GP) absorb(withGrad bool) (err error) {
 		return nil
 	}
 
+	if gp.Solver != nil {
+		return gp.absorbSolver(withGrad)
+	}
+	gp.covariance = nil
+
 	// Covariance matrix
 	K := mat.NewSymDense(len(gp.X), nil)
 
-	cov := func(i, j int, kargs, nargs []float64) {
+	noise := func(i int, nargs []float64, o observer) float64 {
+		copy(nargs[gp.Noise.NTheta():], gp.X[i])
+		n, ngrad := o.Observe(nargs, withGrad)
+		if withGrad {
+			for i := 0; i != gp.Noise.NTheta(); i++ {
+				ngrad[i] *= gp.dThetaNoise[i]
+			}
+			gp.addTodK(i, i,
+				gp.Simil.NTheta(), 0, gp.Noise.NTheta(), ngrad)
+			if gp.withObs {
+				gp.addTodX(i, i, gp.Noise.NTheta(), ngrad)
+			}
+		}
+		return n
+	}
+
+	cov := func(i, j int, kargs, nargs []float64, simil, noiseo observer) {
 		copy(kargs[gp.Simil.NTheta()+gp.NDim:], gp.X[j])
-		k := gp.Simil.Observe(kargs)
+		k, kgrad := simil.Observe(kargs, withGrad)
 		if withGrad {
-			kgrad := model.Gradient(gp.Simil)
 			for i := 0; i != gp.Simil.NTheta(); i++ {
-				kgrad[i] *= gp.ThetaSimil[i]
+				kgrad[i] *= gp.dThetaSimil[i]
 			}
 			gp.addTodK(i, j, 0, 0, gp.Simil.NTheta(), kgrad)
 			if gp.withObs {
-				gp.addTodK(i, j,
-					gp.Simil.NTheta()+gp.Noise.NTheta()+i*gp.NDim,
-					gp.Simil.NTheta(),
-					gp.NDim,
-					kgrad)
-				gp.addTodK(i, j,
-					gp.Simil.NTheta()+gp.Noise.NTheta()+j*gp.NDim,
-					gp.Simil.NTheta()+gp.NDim,
-					gp.NDim,
-					kgrad)
+				gp.addTodX(i, j, gp.Simil.NTheta(), kgrad)
+				gp.addTodX(j, i, gp.Simil.NTheta()+gp.NDim, kgrad)
 			}
-		} else {
-			model.DropGradient(gp.Simil)
 		}
 		if j == i { // Diagonal, add noise
-			copy(nargs[gp.Noise.NTheta():], gp.X[j])
-			n := gp.Noise.Observe(nargs)
-			if withGrad {
-				ngrad := model.Gradient(gp.Noise)
-				for i := 0; i != gp.Noise.NTheta(); i++ {
-					ngrad[i] *= gp.ThetaNoise[i]
+			k += noise(i, nargs, noiseo)
+		}
+		K.SetSym(i, j, k)
+	}
+
+	// Differences of the inputs, for stationary kernels,
+	// are cached for the tiles of the upper triangle.
+	var diffs map[tile][][]float64
+
+	// newFiller returns a function filling the upper triangle
+	// of a tile of the covariance matrix and of its gradient,
+	// with its own argument buffers and kernel observers.
+	newFiller := func() func(t tile) {
+		nargs := make([]float64, gp.Noise.NTheta()+gp.NDim)
+		copy(nargs, gp.ThetaNoise)
+		noiseo := gp.observer(gp.Noise)
+
+		// block computes the covariances of the tile at once,
+		// if the kernel can.
+		var block func(t tile) ([]float64, [][]float64)
+		if s, ok := gp.Simil.(Stationary); ok && !gp.withObs {
+			block = func(t tile) ([]float64, [][]float64) {
+				r, ok := diffs[t]
+				if !ok {
+					r = gp.differences(t)
 				}
-				gp.addTodK(i, j,
-					gp.Simil.NTheta(), 0, gp.Noise.NTheta(), ngrad)
-				if gp.withObs {
-					gp.addTodK(i, j,
-						gp.Simil.NTheta()+gp.Noise.NTheta()+j*gp.NDim,
-						gp.Noise.NTheta(),
-						gp.NDim,
-						ngrad)
+				return s.DiffCovariances(gp.ThetaSimil, r, withGrad)
+			}
+		} else if b, ok := gp.Simil.(Batch); ok && !gp.withObs {
+			block = func(t tile) ([]float64, [][]float64) {
+				return b.Covariances(gp.ThetaSimil,
+					gp.X[t.i0:t.i1], gp.X[t.j0:t.j1], withGrad)
+			}
+		}
+		if block != nil {
+			return func(t tile) {
+				k, dk := block(t)
+				w := t.j1 - t.j0
+				for i := t.i0; i != t.i1; i++ {
+					for j := max(i, t.j0); j < t.j1; j++ {
+						ij := (i-t.i0)*w + j - t.j0
+						for p := range dk {
+							gp.dK[p].SetSym(i, j, dk[p][ij]*gp.dThetaSimil[p])
+						}
+						kij := k[ij]
+						if j == i {
+							kij += noise(i, nargs, noiseo)
+						}
+						K.SetSym(i, j, kij)
+					}
+				}
+			}
+		}
+
+		kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
+		copy(kargs, gp.ThetaSimil)
+		simil := gp.observer(gp.Simil)
+		return func(t tile) {
+			for i := t.i0; i != t.i1; i++ {
+				copy(kargs[gp.Simil.NTheta():], gp.X[i])
+				for j := max(i, t.j0); j < t.j1; j++ {
+					cov(i, j, kargs, nargs, simil, noiseo)
 				}
-			} else {
-				model.DropGradient(gp.Noise)
 			}
-			k += n
 		}
-		K.SetSym(i, j, k)
 	}
 
 	if withGrad {
@@ -160,77 +321,65 @@ func (gp *GP) absorb(withGrad bool) (err error) {
 			gp.dK[i] = mat.NewSymDense(len(gp.X), nil)
 			gp.dK[i].Zero()
 		}
+		for i := range gp.dX {
+			gp.dX[i] = mat.NewDense(len(gp.X), len(gp.X), nil)
+		}
 	}
 
-	if gp.Parallel {
-		// Computing covariances in parallel --- for small
-		// number of observations computing the covariance
-		// matrix dominates the computation time.
-
-		// argument buffer pools
-		kpool := sync.Pool{
-			New: func() interface{} {
-				kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
-				copy(kargs, gp.ThetaSimil)
-				return kargs
-			},
+	gp.toeplitz = nil
+	if gp.Toeplitz && !gp.withObs && regular(gp.X) && gp.constantNoise() {
+		// Stationary kernels on a regular grid, with constant
+		// noise, the covariance matrix is Toeplitz and is defined
+		// by the first row.
+		newFiller()(tile{0, 1, 0, len(gp.X)})
+		row := make([]float64, len(gp.X))
+		for j := range row {
+			row[j] = K.At(0, j)
 		}
-		npool := sync.Pool{
-			New: func() interface{} {
-				nargs := make([]float64, gp.Noise.NTheta()+gp.NDim)
-				copy(nargs, gp.ThetaNoise)
-				return nargs
-			},
-		}
-
-		// sync channel
-		wait := make(chan bool, len(gp.X))
-
-		// Ultimately, all elements of the covariance matrix
-		// could be computed in parallel; however, the number of
-		// goroutines would grow quadratically with the number
-		// of inputs. A compromise taken here is to compute
-		// all covariances in each row of the (upper triangle of)
-		// the covariance matrix in parallel, spawning as many
-		// goroutines as there are inputs.
-		for i := range gp.X {
-			go func() {
-				kargs := kpool.Get().([]float64)
-				copy(kargs[gp.Simil.NTheta():], gp.X[i])
+		// The rest of dK is filled from the first row.
+		for _, dK := range gp.dK {
+			for i := 1; i != len(gp.X); i++ {
 				for j := i; j != len(gp.X); j++ {
-					nargs := npool.Get().([]float64)
-					cov(i, j, kargs, nargs)
-					npool.Put(nargs)
+					dK.SetSym(i, j, dK.At(0, j-i))
 				}
-				wait <- true
-				kpool.Put(kargs)
-			}()
+			}
 		}
-
-		// Wait for all goroutines to finish
-		for range gp.X {
-			<-wait
+		gp.toeplitz = &toeplitz{}
+		if !gp.toeplitz.Factorize(row) {
+			return fmt.Errorf("Factorize(%v)", row)
 		}
 	} else {
-		kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
-		nargs := make([]float64, gp.Noise.NTheta()+gp.NDim)
-		copy(kargs, gp.ThetaSimil)
-		copy(nargs, gp.ThetaNoise)
-
-		for i := range gp.X {
-			copy(kargs[gp.Simil.NTheta():], gp.X[i])
-			for j := i; j != len(gp.X); j++ {
-				cov(i, j, kargs, nargs)
+		ts := tiles(len(gp.X), len(gp.X), true)
+		if _, ok := gp.Simil.(Stationary); ok && !gp.withObs {
+			diffs = gp.cachedDifferences(ts)
+		}
+		if gp.Parallel {
+			// Computing covariances in parallel --- for small
+			// number of observations computing the covariance
+			// matrix dominates the computation time.
+			//
+			// The tiles of the upper triangle of the covariance
+			// matrix are computed by the workers.
+			gp.parallel(len(ts), func() func(int) {
+				fill := newFiller()
+				return func(itile int) {
+					fill(ts[itile])
+				}
+			})
+		} else {
+			fill := newFiller()
+			for _, t := range ts {
+				fill(t)
 			}
 		}
 	}
 
-	if !gp.L.Factorize(K) {
+	if gp.toeplitz == nil && !gp.L.Factorize(K) {
 		return fmt.Errorf("Factorize(%v)", mat.Formatted(K))
 	}
 
 	gp.Alpha = mat.NewVecDense(len(gp.X), nil)
-	err = gp.L.SolveVecTo(gp.Alpha, mat.NewVecDense(len(gp.Y), gp.Y))
+	err = gp.factor().SolveVecTo(gp.Alpha, mat.NewVecDense(len(gp.Y), gp.Y))
 	if err != nil {
 		return err
 	}
@@ -247,13 +396,13 @@ func (gp *GP) LML() float64 {
 		return lml
 	}
 	lml -= 0.5 * float64(len(gp.X)) * math.Log(2*math.Pi)
-	lml -= 0.5 * gp.L.LogDet()
+	lml -= 0.5 * gp.factor().LogDet()
 	lml -= 0.5 * mat.Dot(mat.NewVecDense(len(gp.Y), gp.Y), gp.Alpha)
 	return lml
 }
 
 // Produce computes predictions. Depends on ThetaSimil, ThetaNoise,
-// X, L, Alpha; this fields must be set if Produce is used on stored
+// X, L (unless K is Toeplitz), Alpha; this fields must be set if Produce is used on stored
 // results of a call to Absorb.
 func (gp *GP) Produce(x [][]float64) (
 	mu, sigma []float64,
@@ -262,83 +411,107 @@ func (gp *GP) Produce(x [][]float64) (
 	// Set the defaults
 	gp.defaults()
 
-	mean := mat.NewVecDense(len(x), nil)
 	variance := mat.NewVecDense(len(x), nil)
-	covariance := mat.NewDense(len(x), len(x), nil)
 
 	// Prior variance does not depend on observations
 	kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
 	copy(kargs, gp.ThetaSimil)
+	simil := gp.observer(gp.Simil)
 	for i := range x {
 		copy(kargs[gp.Simil.NTheta():], x[i])
 		copy(kargs[gp.Simil.NTheta()+gp.NDim:], x[i])
-		k := gp.Simil.Observe(kargs)
-		model.DropGradient(gp.Simil)
+		k, _ := simil.Observe(kargs, withoutGradient)
 		variance.SetVec(i, k)
 	}
 
 	// Mean and covariance are computed from observations
 	// if available
+	mean, covariance, err := gp.posterior(x)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mu = make([]float64, len(x))
+	for i := range mu {
+		mu[i] = mean.AtVec(i)
+	}
+
+	sigma = make([]float64, len(x))
+	for i := range sigma {
+		sigma[i] = math.Sqrt(variance.AtVec(i) - covariance.At(i, i))
+	}
+
+	return mu, sigma, nil
+}
+
+// posterior computes the posterior mean at x and the reduction
+// of the prior covariance by the observations,
+//   K*^⊤ Σ^-1 K*, where K* is the covariance of X and x.
+func (gp *GP) posterior(x [][]float64) (
+	mean *mat.VecDense,
+	covariance *mat.Dense,
+	err error,
+) {
+	mean = mat.NewVecDense(len(x), nil)
+	covariance = mat.NewDense(len(x), len(x), nil)
 	if len(gp.X) > 0 {
 		Kstar := mat.NewDense(len(gp.X), len(x), nil)
 
-		if gp.Parallel {
-			// Computing covariances in parallel --- for small
-			// number of observations computing the covariance
-			// matrix dominates the computation time.
-
-			// argument buffer pool
-			kpool := sync.Pool{
-				New: func() interface{} {
-					kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
-					copy(kargs, gp.ThetaSimil)
-					return kargs
-				},
+		// newFiller returns a function filling a tile of Kstar,
+		// with its own argument buffer.
+		newFiller := func() func(t tile) {
+			if b, ok := gp.Simil.(Batch); ok {
+				return func(t tile) {
+					k, _ := b.Covariances(gp.ThetaSimil,
+						gp.X[t.i0:t.i1], x[t.j0:t.j1], withoutGradient)
+					w := t.j1 - t.j0
+					for i := t.i0; i != t.i1; i++ {
+						for j := t.j0; j != t.j1; j++ {
+							Kstar.Set(i, j, k[(i-t.i0)*w+j-t.j0])
+						}
+					}
+				}
 			}
 
-			// sync channel
-			wait := make(chan bool, len(x))
-
-			for i := range gp.X {
-				go func() {
-					kargs := kpool.Get().([]float64)
+			kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
+			copy(kargs, gp.ThetaSimil)
+			simil := gp.observer(gp.Simil)
+			return func(t tile) {
+				for i := t.i0; i != t.i1; i++ {
 					copy(kargs[gp.Simil.NTheta():], gp.X[i])
-					for j := range x {
+					for j := t.j0; j != t.j1; j++ {
 						copy(kargs[gp.Simil.NTheta()+gp.NDim:], x[j])
-						k := gp.Simil.Observe(kargs)
-						model.DropGradient(gp.Simil)
+						k, _ := simil.Observe(kargs, withoutGradient)
 						Kstar.Set(i, j, k)
 					}
-					wait <- true
-					kpool.Put(kargs)
-				}()
+				}
 			}
+		}
 
-			// Wait for all goroutines to finish
-			for range gp.X {
-				<-wait
-			}
-		} else {
-			kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
-			copy(kargs, gp.ThetaSimil)
-			for i := range gp.X {
-				copy(kargs[gp.Simil.NTheta():], gp.X[i])
-				for j := range x {
-					copy(kargs[gp.Simil.NTheta()+gp.NDim:], x[j])
-					k := gp.Simil.Observe(kargs)
-					model.DropGradient(gp.Simil)
-					Kstar.Set(i, j, k)
+		ts := tiles(len(gp.X), len(x), false)
+		if gp.Parallel {
+			// Computing covariances in parallel --- for small
+			// number of observations computing the covariance
+			// matrix dominates the computation time.
+			gp.parallel(len(ts), func() func(int) {
+				fill := newFiller()
+				return func(itile int) {
+					fill(ts[itile])
 				}
+			})
+		} else {
+			fill := newFiller()
+			for _, t := range ts {
+				fill(t)
 			}
 		}
 
 		mean.MulVec(Kstar.T(), gp.Alpha)
 
 		v := mat.NewDense(len(gp.X), len(x), nil)
-		if err := gp.L.SolveTo(v, Kstar); err != nil {
+		if err := gp.factor().SolveTo(v, Kstar); err != nil {
 			return nil, nil, err
 		}
-		covariance = mat.NewDense(len(x), len(x), nil)
 		covariance.Mul(Kstar.T(), v)
 	} else {
 		// No observations
@@ -346,17 +519,38 @@ func (gp *GP) Produce(x [][]float64) (
 		covariance.Zero()
 	}
 
-	mu = make([]float64, len(x))
-	for i := range mu {
-		mu[i] = mean.AtVec(i)
+	return mean, covariance, nil
+}
+
+// ProduceCov computes predictions with the joint covariance
+// of the latent function values at x, without the noise.
+// Depends on the same fields as Produce.
+func (gp *GP) ProduceCov(x [][]float64) (
+	mu []float64,
+	cov *mat.SymDense,
+	err error,
+) {
+	// Set the defaults
+	gp.defaults()
+
+	mean, covariance, err := gp.posterior(x)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	sigma = make([]float64, len(x))
-	for i := range sigma {
-		sigma[i] = math.Sqrt(variance.AtVec(i) - covariance.At(i, i))
+	kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
+	prior, _ := gp.covariances(x, x, withoutGradient, kargs, gp.observer(gp.Simil))
+	mu = make([]float64, len(x))
+	cov = mat.NewSymDense(len(x), nil)
+	for i := range x {
+		mu[i] = mean.AtVec(i)
+		for j := i; j != len(x); j++ {
+			cov.SetSym(i, j, prior[i*len(x)+j]-
+				0.5*(covariance.At(i, j)+covariance.At(j, i)))
+		}
 	}
 
-	return mu, sigma, nil
+	return mu, cov, nil
 }
 
 // Observe and Gradient implement Infergo's ElementalModel.
@@ -365,24 +559,39 @@ func (gp *GP) Produce(x [][]float64) (
 
 // Observe computes log marginal likelihood of the parameters
 // given the observations. The argument is the concatenation of
-// log-transformed hyperparameters, inputs, and outputs.
+// transformed hyperparameters, inputs, and outputs.
+// Hyperparameters are mapped into the domains of kernel
+// parameters by the transforms declared by the kernels (see
+// Transformed), log by default.
 //
-// Optionally, the input can be only log-transformed
+// Optionally, the input can be only transformed
 // hyperparameters, and then
 // * only hyperparameters are inferred;
 // * inputs must be assigned to fields X, Y of gp.
 func (gp *GP) Observe(x []float64) float64 {
-	gp.defaults()
+	gp.destructure(x)
 
-	// Restore parameters from log scale
-	theta := x[:gp.Simil.NTheta()+gp.Noise.NTheta()]
-	for i := range theta {
-		theta[i] = math.Exp(theta[i])
+	err := gp.absorb(withGradient)
+	if err != nil {
+		panic(err)
 	}
 
+	return gp.LML()
+}
+
+// destructure assigns the hyperparameters and, optionally,
+// the inputs and the outputs from the argument of Observe.
+func (gp *GP) destructure(x []float64) {
+	gp.defaults()
+
+	// Restore parameters from the unconstrained space,
+	// remembering derivatives of the transforms
+	gp.dThetaSimil = Restore(KernelTransforms(gp.Simil),
+		gp.ThetaSimil, gp.dThetaSimil, model.Shift(&x, gp.Simil.NTheta()))
+	gp.dThetaNoise = Restore(KernelTransforms(gp.Noise),
+		gp.ThetaNoise, gp.dThetaNoise, model.Shift(&x, gp.Noise.NTheta()))
+
 	// Destructure
-	copy(gp.ThetaSimil, model.Shift(&x, gp.Simil.NTheta()))
-	copy(gp.ThetaNoise, model.Shift(&x, gp.Noise.NTheta()))
 	gp.withObs = len(x) > 0
 	if gp.withObs {
 		// Observations are inferred as well as parameters,
@@ -398,23 +607,15 @@ func (gp *GP) Observe(x []float64) float64 {
 	if len(x) != 0 {
 		panic("len(x)")
 	}
-
-	err := gp.absorb(withGradient)
-	if err != nil {
-		panic(err)
-	}
-
-	// Transform parameters back to log scale
-	for i := range theta {
-		theta[i] = math.Log(theta[i])
-	}
-
-	return gp.LML()
 }
 
 // Gradient computes the gradient of the log-likelihood with
 // respect to the parameters and the inputs (GPML:5.9):
 //   ∇L = ½ tr((α α^⊤ - Σ^−1) ∂Σ/∂θ), where α = Σ^-1 y
+// W = α α^⊤ - Σ^−1 is computed once, and then, since both W
+// and ∂Σ/∂θ are symmetric, the trace is the sum of elements
+// of the elementwise product W ∘ ∂Σ/∂θ, in O(n^2) for each
+// parameter.
 func (gp *GP) Gradient() []float64 {
 	var grad []float64
 	if gp.withObs {
@@ -429,71 +630,90 @@ func (gp *GP) Gradient() []float64 {
 		return grad
 	}
 
-	// Gradient by parameters (and possibly inputs)
-	// α α^⊤
-	a := mat.NewDense(len(gp.Y), len(gp.Y), nil)
-	a.Mul(gp.Alpha, gp.Alpha.T())
-	if gp.Parallel {
-		// register pool
-		rpool := sync.Pool{
-			New: func() interface{} {
-				return mat.NewDense(len(gp.Y), len(gp.Y), nil)
-			},
-		}
-		// sync channel
-		wait := make(chan bool, len(gp.dK))
+	if gp.Solver != nil {
+		gp.solverGradient(grad)
+		return grad
+	}
 
-		for i := range gp.dK {
-			go func() {
-				// registers
-				// α α^⊤ ∂Σ/∂θ
-				r0 := rpool.Get().(*mat.Dense)
-				r0.Mul(a, gp.dK[i])
-				// Σ^−1 ∂Σ/∂θ
-				r1 := rpool.Get().(*mat.Dense)
-				gp.L.SolveTo(r1, gp.dK[i])
-				// (α α^⊤ - Σ^−1) ∂Σ/∂θ
-				r2 := rpool.Get().(*mat.Dense)
-				r2.Sub(r0, r1)
-				rpool.Put(r0)
-				rpool.Put(r1)
-
-				grad[i] = 0.5 * mat.Trace(r2)
-				wait <- true
-				rpool.Put(r2)
-			}()
-		}
+	// Gradient by parameters (and possibly inputs)
+	// W = α α^⊤ - Σ^−1
+	W, err := gp.inverse()
+	if err != nil {
+		panic(err)
+	}
+	W.ScaleSym(-1, W)
+	W.SymRankOne(W, 1, gp.Alpha)
 
-		// Wait for all goroutines to finish
-		for range gp.dK {
-			<-wait
-		}
+	if gp.Parallel {
+		gp.parallel(len(gp.dK), func() func(int) {
+			return func(i int) {
+				grad[i] = 0.5 * sumProd(W, gp.dK[i])
+			}
+		})
 	} else {
-		// registers
-		r0 := mat.NewDense(len(gp.Y), len(gp.Y), nil)
-		r1 := mat.NewDense(len(gp.Y), len(gp.Y), nil)
-		r2 := mat.NewDense(len(gp.Y), len(gp.Y), nil)
 		for i := range gp.dK {
-			// α α^⊤ ∂Σ/∂θ
-			r0.Mul(a, gp.dK[i])
-			// Σ^−1 ∂Σ/∂θ
-			gp.L.SolveTo(r1, gp.dK[i])
-			// (α α^⊤ - Σ^−1) ∂Σ/∂θ
-			r2.Sub(r0, r1)
-
-			grad[i] = 0.5 * mat.Trace(r2)
+			grad[i] = 0.5 * sumProd(W, gp.dK[i])
 		}
 	}
 
 	if gp.withObs {
+		// Gradient by inputs
+		gp.inputGradient(grad[len(gp.dK):], W, 0.5)
 		// Gradient by outputs
 		for i := range gp.Y {
-			grad[len(gp.dK)+i] = -gp.Alpha.AtVec(i)
+			grad[len(gp.dK)+len(gp.X)*gp.NDim+i] = -gp.Alpha.AtVec(i)
 		}
 	}
 
-	// forget dK to release memory
-	gp.dK = nil
+	// forget dK and dX to release memory
+	gp.dK, gp.dX = nil, nil
 
 	return grad
 }
+
+// inputGradient stores in grad the sums of elements of the
+// elementwise products of symmetric W and ∂Σ/∂x_id, scaled by
+// s, for every input i and dimension d. ∂Σ/∂x_id is non-zero
+// in row and column i only, and row i of dX[d] holds
+// [∂Σ/∂x_id]_ij, hence
+//   ∑_ab W_ab [∂Σ/∂x_id]_ab = 2 ∑_j W_ij [dX_d]_ij - W_ii [dX_d]_ii,
+// in O(n) for each input, and the gradient by the inputs takes
+// O(n^2) memory rather than O(n^3) with a matrix per input.
+func (gp *GP) inputGradient(grad []float64, W mat.Symmetric, s float64) {
+	n := len(gp.X)
+	row := func(i int) {
+		for d, dX := range gp.dX {
+			sum := -W.At(i, i) * dX.At(i, i)
+			for j := 0; j != n; j++ {
+				sum += 2 * W.At(i, j) * dX.At(i, j)
+			}
+			grad[i*gp.NDim+d] = s * sum
+		}
+	}
+	if gp.Parallel {
+		gp.parallel(n, func() func(int) {
+			return row
+		})
+	} else {
+		for i := 0; i != n; i++ {
+			row(i)
+		}
+	}
+}
+
+// sumProd computes the sum of elements of the elementwise
+// product of symmetric matrices a and b, visiting the upper
+// triangle only.
+func sumProd(a, b *mat.SymDense) float64 {
+	ra, rb := a.RawSymmetric(), b.RawSymmetric()
+	sum := 0.
+	for i := 0; i != ra.N; i++ {
+		rowa := ra.Data[i*ra.Stride : i*ra.Stride+ra.N]
+		rowb := rb.Data[i*rb.Stride : i*rb.Stride+rb.N]
+		sum += rowa[i] * rowb[i]
+		for j := i + 1; j != ra.N; j++ {
+			sum += 2 * rowa[j] * rowb[j]
+		}
+	}
+	return sum
+}
@@ -3,7 +3,12 @@ package gp
 import (
 	"bitbucket.org/dtolpin/gogp/kernel/ad"
 	"bitbucket.org/dtolpin/infergo/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
 	"math"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -267,3 +272,1037 @@ func TestElementalModel(t *testing.T) {
 		}
 	}
 }
+
+// Type transformed overrides the transforms of a kernel.
+type transformed struct {
+	Kernel
+	ts []kernel.Transform
+}
+
+func (k transformed) Transforms() []kernel.Transform {
+	return k.ts
+}
+
+func TestTransforms(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		gp    *GP
+		x     []float64
+		theta []float64
+	}{
+		{
+			name: "log",
+			gp: &GP{
+				NDim:  1,
+				Simil: kernel.Normal,
+				Noise: kernel.UniformNoise,
+			},
+			x:     []float64{0.5, -1, 0, 1, 2, 1, 0, -1},
+			theta: []float64{math.Exp(0.5), math.Exp(-1)},
+		},
+		{
+			name: "softplus",
+			gp: &GP{
+				NDim: 1,
+				Simil: transformed{kernel.Normal,
+					[]kernel.Transform{kernel.Softplus}},
+				Noise: kernel.UniformNoise,
+			},
+			x:     []float64{0.5, -1, 0, 1, 2, 1, 0, -1},
+			theta: []float64{math.Log1p(math.Exp(0.5)), math.Exp(-1)},
+		},
+		{
+			name: "logit",
+			gp: &GP{
+				NDim:  1,
+				Simil: kernel.Normal,
+				Noise: transformed{kernel.UniformNoise,
+					[]kernel.Transform{kernel.Logit{Lo: 0.1, Hi: 0.5}}},
+			},
+			x:     []float64{0.5, 0, 0, 1, 2, 1, 0, -1},
+			theta: []float64{math.Exp(0.5), 0.3},
+		},
+		{
+			name: "identity",
+			gp: &GP{
+				NDim:  1,
+				Simil: kernel.Normal,
+				Noise: transformed{kernel.UniformNoise,
+					[]kernel.Transform{kernel.Identity}},
+			},
+			x:     []float64{0.5, 0.2, 0, 1, 2, 1, 0, -1},
+			theta: []float64{math.Exp(0.5), 0.2},
+		},
+	} {
+		x := make([]float64, len(c.x))
+		copy(x, c.x)
+		ll := c.gp.Observe(x)
+		dll := c.gp.Gradient()
+		for i := range x {
+			if x[i] != c.x[i] {
+				t.Errorf("%s: argument modified: got %v, want %v",
+					c.name, x, c.x)
+				break
+			}
+		}
+		theta := append(c.gp.ThetaSimil, c.gp.ThetaNoise...)
+		for i := range theta {
+			if math.Abs(theta[i]-c.theta[i]) > 1e-6 {
+				t.Errorf("%s: wrong parameters: got %v, want %v",
+					c.name, theta, c.theta)
+				break
+			}
+		}
+		for i, tr := range c.gp.Transforms() {
+			if math.Abs(tr.Backward(tr.Forward(x[i]))-x[i]) > 1e-6 {
+				t.Errorf("%s: transform %d is not invertible",
+					c.name, i)
+			}
+		}
+		for j := range x {
+			x0 := x[j]
+			x[j] += dx
+			llj := c.gp.Observe(x)
+			dldx := (llj - ll) / dx
+			x[j] = x0
+			if math.Abs(dll[j]-dldx) > eps {
+				t.Errorf("%s: dl/dx%d mismatch: got %.4f, want %.4f",
+					c.name, j, dldx, dll[j])
+			}
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		gp     *GP
+		names  []string
+		params map[string]float64
+		descr  string
+	}{
+		{
+			name: "named",
+			gp: &GP{
+				NDim:       1,
+				Simil:      kernel.Periodic,
+				Noise:      kernel.UniformNoise,
+				ThetaSimil: []float64{1, 2},
+				ThetaNoise: []float64{0.1},
+			},
+			names: []string{"simil.l", "simil.p", "noise.s"},
+			params: map[string]float64{
+				"simil.l": 1, "simil.p": 2, "noise.s": 0.1,
+			},
+			descr: "Periodic(l=1, p=2) + UniformNoise(s=0.1)",
+		},
+		{
+			name: "anonymous",
+			gp: &GP{
+				NDim: 1,
+				Simil: transformed{kernel.Normal,
+					[]kernel.Transform{kernel.Log}},
+				Noise:      kernel.ConstantNoise(0.5),
+				ThetaSimil: []float64{3},
+			},
+			names:  []string{"simil.theta0"},
+			params: map[string]float64{"simil.theta0": 3},
+			descr:  "gp.transformed(theta0=3) + ConstantNoise(0.5)",
+		},
+	} {
+		names := c.gp.Names()
+		if len(names) != len(c.names) {
+			t.Errorf("%s: wrong names: got %v, want %v",
+				c.name, names, c.names)
+			continue
+		}
+		for i := range names {
+			if names[i] != c.names[i] {
+				t.Errorf("%s: wrong names: got %v, want %v",
+					c.name, names, c.names)
+				break
+			}
+			if c.gp.Index(names[i]) != i {
+				t.Errorf("%s: wrong index of %q: got %d, want %d",
+					c.name, names[i], c.gp.Index(names[i]), i)
+			}
+		}
+		if c.gp.Index("nosuchname") != -1 {
+			t.Errorf("%s: index of unknown name is not -1", c.name)
+		}
+		params := c.gp.Params()
+		if len(params) != len(c.params) {
+			t.Errorf("%s: wrong params: got %v, want %v",
+				c.name, params, c.params)
+		}
+		for name, value := range c.params {
+			if params[name] != value {
+				t.Errorf("%s: wrong params: got %v, want %v",
+					c.name, params, c.params)
+				break
+			}
+		}
+		if descr := c.gp.String(); descr != c.descr {
+			t.Errorf("%s: wrong description: got %q, want %q",
+				c.name, descr, c.descr)
+		}
+	}
+}
+
+func TestProduceCov(t *testing.T) {
+	x := [][]float64{{0}, {0.5}, {1.5}, {2}}
+	y := []float64{1, 0.5, -0.5, 0}
+	z := [][]float64{{-1}, {0.25}, {0.5}, {3}}
+	for _, c := range []struct {
+		name  string
+		simil Kernel
+		x     [][]float64
+		y     []float64
+	}{
+		{"prior", kernel.Matern52, nil, nil},
+		{"posterior", kernel.Matern52, x, y},
+		{"elemental", elemental{kernel.Matern52}, x, y},
+	} {
+		gp := &GP{
+			NDim:       1,
+			Simil:      c.simil,
+			Noise:      kernel.ConstantNoise(0.01),
+			ThetaSimil: []float64{0.8},
+		}
+		if err := gp.Absorb(c.x, c.y); err != nil {
+			t.Fatalf("%s: absorb: %v", c.name, err)
+		}
+		mu, sigma, err := gp.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", c.name, err)
+		}
+		cmu, cov, err := gp.ProduceCov(z)
+		if err != nil {
+			t.Fatalf("%s: produce covariance: %v", c.name, err)
+		}
+		for i := range z {
+			if math.Abs(mu[i]-cmu[i]) > 1e-12 {
+				t.Errorf("%s: wrong mean: got %v, want %v", c.name, cmu, mu)
+				break
+			}
+			if math.Abs(sigma[i]*sigma[i]-cov.At(i, i)) > 1e-12 {
+				t.Errorf("%s: wrong variance at %v: got %.6g, want %.6g",
+					c.name, z[i], cov.At(i, i), sigma[i]*sigma[i])
+			}
+			if len(c.x) == 0 {
+				for j := range z {
+					want, _ := kernel.Matern52.Covariances([]float64{0.8},
+						z[i:i+1], z[j:j+1], false)
+					if math.Abs(cov.At(i, j)-want[0]) > 1e-12 {
+						t.Errorf("%s: wrong prior covariance at %d,%d: "+
+							"got %.6g, want %.6g",
+							c.name, i, j, cov.At(i, j), want[0])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLOO(t *testing.T) {
+	x := [][]float64{{0}, {0.5}, {1.5}, {2}, {3}}
+	y := []float64{1, 0.5, -0.5, 0, 1}
+	gp := &GP{
+		NDim:       1,
+		Simil:      kernel.Normal,
+		Noise:      kernel.UniformNoise,
+		ThetaSimil: []float64{1},
+		ThetaNoise: []float64{0.3},
+	}
+	if err := gp.Absorb(x, y); err != nil {
+		t.Fatalf("absorb: %v", err)
+	}
+	mu, variance, lpp, err := gp.LOO()
+	if err != nil {
+		t.Fatalf("loo: %v", err)
+	}
+
+	// Compare to predictions with each point left out in turn
+	wlpp := 0.
+	for i := range x {
+		xi := append(append([][]float64{}, x[:i]...), x[i+1:]...)
+		yi := append(append([]float64{}, y[:i]...), y[i+1:]...)
+		gpi := &GP{
+			NDim:       1,
+			Simil:      kernel.Normal,
+			Noise:      kernel.UniformNoise,
+			ThetaSimil: gp.ThetaSimil,
+			ThetaNoise: gp.ThetaNoise,
+		}
+		if err := gpi.Absorb(xi, yi); err != nil {
+			t.Fatalf("absorb without %d: %v", i, err)
+		}
+		wmu, wsigma, err := gpi.Produce(x[i : i+1])
+		if err != nil {
+			t.Fatalf("produce without %d: %v", i, err)
+		}
+		wvariance := wsigma[0]*wsigma[0] +
+			gp.ThetaNoise[0]*gp.ThetaNoise[0]
+		if math.Abs(mu[i]-wmu[0]) > 1e-6 {
+			t.Errorf("wrong mu[%d]: got %f, want %f", i, mu[i], wmu[0])
+		}
+		if math.Abs(variance[i]-wvariance) > 1e-6 {
+			t.Errorf("wrong variance[%d]: got %f, want %f",
+				i, variance[i], wvariance)
+		}
+		d := y[i] - wmu[0]
+		wlpp -= 0.5 * (math.Log(2*math.Pi*wvariance) + d*d/wvariance)
+	}
+	if math.Abs(lpp-wlpp) > 1e-6 {
+		t.Errorf("wrong lpp: got %f, want %f", lpp, wlpp)
+	}
+}
+
+func TestLOOModel(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		m    *LOOModel
+		x    []float64
+	}{
+		{
+			name: "hyperparameters",
+			m: &LOOModel{&GP{
+				NDim:  1,
+				Simil: kernel.Normal,
+				Noise: kernel.UniformNoise,
+				X:     [][]float64{{0}, {0.5}, {1.5}, {2}},
+				Y:     []float64{1, 0.5, -0.5, 0},
+			}},
+			x: []float64{0.2, -1},
+		},
+		{
+			name: "observations",
+			m: &LOOModel{&GP{
+				NDim:  1,
+				Simil: kernel.Normal,
+				Noise: kernel.UniformNoise,
+			}},
+			x: []float64{0.2, -1, 0, 0.5, 1.5, 2, 1, 0.5, -0.5, 0},
+		},
+	} {
+		ll := c.m.Observe(c.x)
+		dll := c.m.Gradient()
+		if len(dll) != len(c.x) {
+			t.Errorf("%s: wrong gradient size: got %d, want %d",
+				c.name, len(dll), len(c.x))
+			continue
+		}
+		for j := range c.x {
+			x0 := c.x[j]
+			c.x[j] += dx
+			llj := c.m.Observe(c.x)
+			dldx := (llj - ll) / dx
+			c.x[j] = x0
+			if math.Abs(dll[j]-dldx) > eps {
+				t.Errorf("%s: dl/dx%d mismatch: got %.4f, want %.4f",
+					c.name, j, dll[j], dldx)
+			}
+		}
+	}
+}
+
+// Type radial is a two-dimensional normal kernel, an elemental
+// model with the gradient by both the length scale and the inputs.
+type radial struct {
+	grad []float64
+}
+
+func (k *radial) Observe(x []float64) float64 {
+	l, xa, xb := x[0], x[1:3], x[3:5]
+	d0, d1 := (xa[0]-xb[0])/l, (xa[1]-xb[1])/l
+	v := math.Exp(-(d0*d0 + d1*d1) / 2)
+	k.grad = []float64{
+		v * (d0*d0 + d1*d1) / l,
+		-v * d0 / l, -v * d1 / l,
+		v * d0 / l, v * d1 / l,
+	}
+	return v
+}
+
+func (k *radial) Gradient() []float64 { return k.grad }
+
+func (*radial) NTheta() int { return 1 }
+
+func (*radial) Replicate() Kernel { return &radial{} }
+
+// Type slopedNoise is noise growing with the first input, an
+// elemental model with the gradient by the inputs.
+type slopedNoise struct {
+	grad []float64
+}
+
+func (n *slopedNoise) Observe(x []float64) float64 {
+	s, x0 := x[0], x[1]
+	n.grad = []float64{1 + x0*x0, 2 * s * x0, 0}
+	return s * (1 + x0*x0)
+}
+
+func (n *slopedNoise) Gradient() []float64 { return n.grad }
+
+func (*slopedNoise) NTheta() int { return 1 }
+
+func TestInputGradient(t *testing.T) {
+	x := []float64{
+		0.2, -1,
+		0, 0.1, 0.5, -0.3, 1.5, 0.4, 2, 1.2, 0.7, 0.8,
+		1, 0.5, -0.5, 0, 0.3,
+	}
+	for _, c := range []struct {
+		name string
+		m    model.Model
+	}{
+		{
+			name: "lml",
+			m: &GP{
+				NDim:  2,
+				Simil: &radial{},
+				Noise: &slopedNoise{},
+			},
+		},
+		{
+			name: "loo",
+			m: &LOOModel{&GP{
+				NDim:  2,
+				Simil: &radial{},
+				Noise: &slopedNoise{},
+			}},
+		},
+	} {
+		ll := c.m.Observe(x)
+		dll := model.Gradient(c.m)
+		if len(dll) != len(x) {
+			t.Errorf("%s: wrong gradient size: got %d, want %d",
+				c.name, len(dll), len(x))
+			continue
+		}
+		for j := range x {
+			x0 := x[j]
+			x[j] += dx
+			llj := c.m.Observe(x)
+			model.DropGradient(c.m)
+			dldx := (llj - ll) / dx
+			x[j] = x0
+			if math.Abs(dll[j]-dldx) > eps {
+				t.Errorf("%s: dl/dx%d mismatch: got %.4f, want %.4f",
+					c.name, j, dll[j], dldx)
+			}
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	// Enough points for several tiles
+	const n = 70
+	x := []float64{0.2, -1}
+	for i := 0; i != n; i++ {
+		x = append(x, 0.1*float64(i))
+	}
+	for i := 0; i != n; i++ {
+		x = append(x, math.Sin(0.3*float64(i)))
+	}
+	z := [][]float64{{-1}, {0.55}, {7.5}}
+
+	var grads, mus [][]float64
+	for _, c := range []struct {
+		parallel bool
+		workers  int
+	}{
+		{false, 0},
+		{true, 0},
+		{true, 1},
+		{true, 3},
+	} {
+		gp := &GP{
+			NDim:     1,
+			Simil:    kernel.Matern52,
+			Noise:    kernel.UniformNoise,
+			Parallel: c.parallel,
+			Workers:  c.workers,
+		}
+		gp.Observe(x)
+		grads = append(grads, gp.Gradient())
+		mu, _, err := gp.Produce(z)
+		if err != nil {
+			t.Fatalf("produce: %v", err)
+		}
+		mus = append(mus, mu)
+	}
+	for k := 1; k != len(grads); k++ {
+		for i := range grads[0] {
+			if grads[0][i] != grads[k][i] {
+				t.Errorf("serial and parallel gradients differ: %v, %v",
+					grads[0], grads[k])
+				break
+			}
+		}
+		for i := range mus[0] {
+			if mus[0][i] != mus[k][i] {
+				t.Errorf("serial and parallel predictions differ: %v, %v",
+					mus[0], mus[k])
+				break
+			}
+		}
+	}
+}
+
+func TestSharedTape(t *testing.T) {
+	// Calls through the tape are serialized as if the tape were
+	// not thread-safe.
+	defer func(f func() bool) { isMTSafe = f }(isMTSafe)
+	isMTSafe = func() bool { return false }
+
+	// Enough points for several tiles
+	const n = 40
+	theta := []float64{0.2, -1}
+	X := make([][]float64, n)
+	Y := make([]float64, n)
+	for i := range X {
+		X[i] = []float64{0.1 * float64(i), math.Cos(0.2 * float64(i))}
+		Y[i] = math.Sin(0.3 * float64(i))
+	}
+	for _, c := range []struct {
+		name         string
+		ndim         int
+		simil, noise Kernel
+		withObs      bool
+	}{
+		// automatically differentiated
+		{"tape", 1, elemental{kernel.Matern52}, kernel.UniformNoise, false},
+		// replicated and shared elemental kernels
+		{"replicated", 2, &radial{}, &slopedNoise{}, false},
+		{"inputs", 2, &radial{}, &slopedNoise{}, true},
+	} {
+		x := append([]float64{}, theta...)
+		Xc := make([][]float64, n)
+		for i := range Xc {
+			Xc[i] = X[i][:c.ndim]
+		}
+		if c.withObs {
+			for i := range Xc {
+				x = append(x, Xc[i]...)
+			}
+			x = append(x, Y...)
+		}
+		var grads [][]float64
+		for _, parallel := range []bool{false, true} {
+			gp := &GP{
+				NDim:     c.ndim,
+				Simil:    c.simil,
+				Noise:    c.noise,
+				Parallel: parallel,
+				X:        Xc,
+				Y:        Y,
+			}
+			gp.Observe(x)
+			grads = append(grads, gp.Gradient())
+		}
+		for i := range grads[0] {
+			if grads[0][i] != grads[1][i] {
+				t.Errorf("%s: serial and parallel gradients differ: %v, %v",
+					c.name, grads[0], grads[1])
+				break
+			}
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	// Several GPs with kernels differentiated through the tape
+	// are used at once, serial and parallel; the calls through
+	// the shared tape are serialized as if the tape were not
+	// thread-safe.
+	defer func(f func() bool) { isMTSafe = f }(isMTSafe)
+	isMTSafe = func() bool { return false }
+
+	const n = 40
+	x := []float64{0.2, -1}
+	for i := 0; i != n; i++ {
+		x = append(x, 0.1*float64(i))
+	}
+	for i := 0; i != n; i++ {
+		x = append(x, math.Sin(0.3*float64(i)))
+	}
+	z := [][]float64{{-1}, {0.55}, {4.5}}
+
+	// run fits and forecasts, and returns the gradient and the
+	// predictions.
+	run := func(parallel bool) (grad, mu, sigma []float64, err error) {
+		gp := &GP{
+			NDim:     1,
+			Simil:    elemental{kernel.Matern52},
+			Noise:    kernel.UniformNoise,
+			Parallel: parallel,
+			Workers:  2,
+		}
+		for i := 0; i != 3; i++ {
+			gp.Observe(x)
+			grad = gp.Gradient()
+			if mu, sigma, err = gp.Produce(z); err != nil {
+				return nil, nil, nil, err
+			}
+			if _, _, err = gp.ProduceCov(z); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		return grad, mu, sigma, nil
+	}
+
+	grad, mu, sigma, err := run(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ngps = 6
+	var wg sync.WaitGroup
+	errs := make([]error, ngps)
+	for k := 0; k != ngps; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			gradk, muk, sigmak, err := run(k%2 == 1)
+			switch {
+			case err != nil:
+				errs[k] = err
+			case !reflect.DeepEqual(gradk, grad):
+				errs[k] = fmt.Errorf("gradients differ: %v, %v", gradk, grad)
+			case !reflect.DeepEqual(muk, mu) || !reflect.DeepEqual(sigmak, sigma):
+				errs[k] = fmt.Errorf("predictions differ: %v, %v; %v, %v",
+					muk, mu, sigmak, sigma)
+			}
+		}(k)
+	}
+	wg.Wait()
+	for k, err := range errs {
+		if err != nil {
+			t.Errorf("GP %d: %v", k, err)
+		}
+	}
+}
+
+func TestToeplitz(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		ndim    int
+		x       []float64
+		noise   Kernel
+		regular bool
+	}{
+		{"single", 1, []float64{0.5, 1}, kernel.UniformNoise, true},
+		{"pair", 1, []float64{0.5, 1, 1.5, 2}, kernel.UniformNoise, true},
+		{"series", 1, []float64{
+			-1, -0.5, 0, 0.5, 1, 1.5, 2,
+			0.3, -0.2, 0.1, 0.5, 0.9, 0.4, 0.2}, kernel.UniformNoise, true},
+		{"default noise", 1, []float64{
+			1, 2, 3, 4, 5,
+			0.3, -0.2, 0.1, 0.5, 0.9}, nil, true},
+		{"decreasing", 1, []float64{
+			2, 1.5, 1, 0.5,
+			0.3, -0.2, 0.1, 0.5}, kernel.UniformNoise, true},
+		{"irregular", 1, []float64{
+			0, 0.5, 2, 2.5,
+			0.3, -0.2, 0.1, 0.5}, kernel.UniformNoise, false},
+		// Regular, but the noise depends on the inputs
+		{"sloped noise", 1, []float64{
+			0, 0.5, 1, 1.5,
+			0.3, -0.2, 0.1, 0.5}, &slopedNoise{}, true},
+	} {
+		n := len(c.x) / (c.ndim + 1)
+		X := make([][]float64, n)
+		for i := range X {
+			X[i] = c.x[i*c.ndim : (i+1)*c.ndim]
+		}
+		Y := c.x[n*c.ndim:]
+		if regular(X) != c.regular {
+			t.Errorf("%s: regular: got %v, want %v",
+				c.name, !c.regular, c.regular)
+		}
+		theta := []float64{-0.5}
+		if c.noise != nil {
+			theta = append(theta, -1)
+		}
+
+		var lmls []float64
+		var grads, mus, sigmas, loos [][]float64
+		for _, toeplitz := range []bool{false, true} {
+			gp := &GP{
+				NDim:     c.ndim,
+				Simil:    kernel.Normal,
+				Noise:    c.noise,
+				Toeplitz: toeplitz,
+				X:        X,
+				Y:        Y,
+			}
+			lmls = append(lmls, gp.Observe(theta))
+			_, sloped := c.noise.(*slopedNoise)
+			if toeplitz && (gp.toeplitz != nil) != (c.regular && !sloped) {
+				t.Errorf("%s: Toeplitz factorization used: %v",
+					c.name, gp.toeplitz != nil)
+			}
+			grads = append(grads, gp.Gradient())
+			mu, sigma, err := gp.Produce([][]float64{
+				make([]float64, c.ndim), X[n-1]})
+			if err != nil {
+				t.Fatalf("%s: produce: %v", c.name, err)
+			}
+			mus = append(mus, mu)
+			sigmas = append(sigmas, sigma)
+			_, variance, _, err := gp.LOO()
+			if err != nil {
+				t.Fatalf("%s: loo: %v", c.name, err)
+			}
+			loos = append(loos, variance)
+		}
+
+		if math.Abs(lmls[0]-lmls[1]) > 1e-8 {
+			t.Errorf("%s: lml mismatch: got %.6g, want %.6g",
+				c.name, lmls[1], lmls[0])
+		}
+		for _, v := range []struct {
+			what string
+			vals [][]float64
+		}{
+			{"gradient", grads},
+			{"mu", mus},
+			{"sigma", sigmas},
+			{"loo variance", loos},
+		} {
+			for i := range v.vals[0] {
+				if math.Abs(v.vals[0][i]-v.vals[1][i]) > 1e-8 {
+					t.Errorf("%s: %s mismatch: got %v, want %v",
+						c.name, v.what, v.vals[1], v.vals[0])
+					break
+				}
+			}
+		}
+	}
+}
+
+// Type elemental hides the Batch interface of the kernel.
+type elemental struct {
+	Kernel
+}
+
+// Type batchOnly hides the Stationary interface of the kernel.
+type batchOnly struct {
+	Kernel
+}
+
+func (k batchOnly) Covariances(
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) ([]float64, [][]float64) {
+	return k.Kernel.(Batch).Covariances(theta, xa, xb, withGrad)
+}
+
+func TestBatch(t *testing.T) {
+	// Enough points for several tiles
+	const n = 40
+	var x []float64
+	for i := 0; i != n; i++ {
+		x = append(x, 0.1*float64(i*i%17))
+	}
+	for i := 0; i != n; i++ {
+		x = append(x, math.Sin(0.3*float64(i)))
+	}
+	X := make([][]float64, n)
+	for i := range X {
+		X[i] = x[i : i+1]
+	}
+	Y := x[n:]
+	z := [][]float64{{-1}, {0.55}, {7.5}}
+
+	for _, c := range []struct {
+		name     string
+		simil    Kernel
+		theta    []float64
+		parallel bool
+	}{
+		{"normal", kernel.Normal, []float64{0.2, -1}, false},
+		{"periodic", kernel.Periodic, []float64{0.2, 0.5, -1}, false},
+		{"matern32", kernel.Matern32, []float64{0.2, -1}, false},
+		{"matern52", kernel.Matern52, []float64{0.2, -1}, false},
+		{"parallel", kernel.Matern52, []float64{0.2, -1}, true},
+	} {
+		if _, ok := c.simil.(Batch); !ok {
+			t.Fatalf("%s: kernel does not implement Batch", c.name)
+		}
+		var lmls []float64
+		var grads, mus, sigmas [][]float64
+		var gp *GP
+		for _, simil := range []Kernel{
+			elemental{c.simil}, batchOnly{c.simil}, c.simil,
+		} {
+			gp = &GP{
+				NDim:     1,
+				Simil:    simil,
+				Noise:    kernel.UniformNoise,
+				Parallel: c.parallel,
+				X:        X,
+				Y:        Y,
+			}
+			lmls = append(lmls, gp.Observe(c.theta))
+			grads = append(grads, gp.Gradient())
+			mu, sigma, err := gp.Produce(z)
+			if err != nil {
+				t.Fatalf("%s: produce: %v", c.name, err)
+			}
+			mus = append(mus, mu)
+			sigmas = append(sigmas, sigma)
+		}
+
+		for k := 1; k != len(lmls); k++ {
+			if math.Abs(lmls[0]-lmls[k]) > 1e-8 {
+				t.Errorf("%s: lml mismatch: got %.6g, want %.6g",
+					c.name, lmls[k], lmls[0])
+			}
+			for _, v := range []struct {
+				what string
+				vals [][]float64
+			}{
+				{"gradient", grads},
+				{"mu", mus},
+				{"sigma", sigmas},
+			} {
+				for i := range v.vals[0] {
+					if math.Abs(v.vals[0][i]-v.vals[k][i]) > 1e-8 {
+						t.Errorf("%s: %s mismatch: got %v, want %v",
+							c.name, v.what, v.vals[k], v.vals[0])
+						break
+					}
+				}
+			}
+		}
+
+		// The gradient is checked against finite differences.
+		const (
+			dx  = 1e-8
+			eps = 1e-4
+		)
+		ll := gp.Observe(c.theta)
+		for i := range c.theta {
+			theta := append([]float64{}, c.theta...)
+			theta[i] += dx
+			dldx := (gp.Observe(theta) - ll) / dx
+			if math.Abs(grads[2][i]-dldx) > eps*math.Max(1, math.Abs(dldx)) {
+				t.Errorf("%s: dl/dx%d mismatch: got %.4f, want %.4f",
+					c.name, i, grads[2][i], dldx)
+			}
+		}
+	}
+}
+
+func TestStationary(t *testing.T) {
+	// Enough points for several tiles
+	const n = 40
+	X := make([][]float64, n)
+	Y := make([]float64, n)
+	for i := range X {
+		X[i] = []float64{0.1 * float64(i*i%17)}
+		Y[i] = math.Sin(0.3 * float64(i))
+	}
+	theta := []float64{0.2, -1}
+
+	for _, parallel := range []bool{false, true} {
+		gp := &GP{
+			NDim:     1,
+			Simil:    kernel.Matern52,
+			Noise:    kernel.UniformNoise,
+			Parallel: parallel,
+			X:        X,
+			Y:        Y,
+		}
+		gp.Observe(theta)
+		if len(gp.diffs) == 0 {
+			t.Fatalf("parallel=%v: differences are not cached", parallel)
+		}
+		diffs := gp.diffs
+
+		// The cache is reused while the inputs are the same.
+		gp.Observe(theta)
+		if len(gp.diffs) != len(diffs) ||
+			&gp.diffs[tile{0, 32, 0, 32}][0][0] != &diffs[tile{0, 32, 0, 32}][0][0] {
+			t.Errorf("parallel=%v: differences are recomputed", parallel)
+		}
+
+		// The cache is invalidated when the inputs change, even
+		// in place.
+		x0 := X[3][0]
+		X[3][0] += 0.5
+		lml := gp.Observe(theta)
+		fresh := &GP{
+			NDim:  1,
+			Simil: kernel.Matern52,
+			Noise: kernel.UniformNoise,
+			X:     X,
+			Y:     Y,
+		}
+		if want := fresh.Observe(theta); math.Abs(lml-want) > 1e-10 {
+			t.Errorf("parallel=%v: stale differences: got lml %.6g, want %.6g",
+				parallel, lml, want)
+		}
+		X[3][0] = x0
+	}
+}
+
+// Type dense is a dense matrix as an operator, without access
+// to the elements.
+type dense struct {
+	a *mat.SymDense
+}
+
+func (d dense) Dim() int {
+	return d.a.Symmetric()
+}
+
+func (d dense) MulTo(dst, v [][]float64) {
+	for i := range v {
+		mat.NewVecDense(len(dst[i]), dst[i]).MulVec(d.a,
+			mat.NewVecDense(len(v[i]), v[i]))
+	}
+}
+
+// Type denseColumns is a dense matrix as an operator with
+// access to the diagonal and the columns.
+type denseColumns struct {
+	dense
+}
+
+func (d denseColumns) Diag(dst []float64) {
+	for i := range dst {
+		dst[i] = d.a.At(i, i)
+	}
+}
+
+func (d denseColumns) Column(j int, dst []float64) {
+	mat.Col(dst, j, d.a)
+}
+
+func TestIterative(t *testing.T) {
+	const n = 60
+	X := make([][]float64, n)
+	Y := make([]float64, n)
+	for i := range X {
+		X[i] = []float64{0.1 * float64(i*i%37)}
+		Y[i] = math.Sin(0.3 * float64(i))
+	}
+	k, _ := kernel.Matern32.Covariances([]float64{0.5}, X, X, false)
+	a := mat.NewSymDense(n, k)
+	for i := range X {
+		a.SetSym(i, i, a.At(i, i)+0.1)
+	}
+	var chol mat.Cholesky
+	chol.Factorize(a)
+	want := mat.NewVecDense(n, nil)
+	chol.SolveVecTo(want, mat.NewVecDense(n, Y))
+
+	// The solver needs only the products with vectors.
+	for _, c := range []struct {
+		name   string
+		op     Operator
+		solver *Iterative
+		eps    float64 // relative tolerance of the log-determinant
+	}{
+		{"products only", dense{a}, &Iterative{}, 0.05},
+		{"diagonal", denseColumns{dense{a}}, &Iterative{Rank: -1}, 0.05},
+		{"pivoted Cholesky", denseColumns{dense{a}}, &Iterative{}, 0.05},
+		{"full rank", denseColumns{dense{a}}, &Iterative{Rank: n}, 1e-5},
+	} {
+		if err := c.solver.Factorize(c.op); err != nil {
+			t.Fatalf("%s: factorize: %v", c.name, err)
+		}
+		got := make([]float64, n)
+		if err := c.solver.SolveTo(
+			[][]float64{got}, [][]float64{Y}); err != nil {
+			t.Fatalf("%s: solve: %v", c.name, err)
+		}
+		for i := range got {
+			if math.Abs(got[i]-want.AtVec(i)) > 1e-6*math.Max(1, math.Abs(want.AtVec(i))) {
+				t.Errorf("%s: solution mismatch: got %.6g, want %.6g",
+					c.name, got[i], want.AtVec(i))
+				break
+			}
+		}
+		if math.Abs(c.solver.LogDet()-chol.LogDet()) > c.eps*math.Abs(chol.LogDet()) {
+			t.Errorf("%s: log-determinant mismatch: got %.6g, want %.6g",
+				c.name, c.solver.LogDet(), chol.LogDet())
+		}
+	}
+
+	// LML and gradient are estimated, predictions are exact.
+	z := [][]float64{{-1}, {0.55}, {7.5}}
+	theta := []float64{0.2, -2}
+	gp := &GP{
+		NDim:  1,
+		Simil: kernel.Matern52,
+		Noise: kernel.UniformNoise,
+		X:     X,
+		Y:     Y,
+	}
+	lml := gp.Observe(theta)
+	grad := gp.Gradient()
+	mu, sigma, err := gp.Produce(z)
+	if err != nil {
+		t.Fatalf("produce: %v", err)
+	}
+	for _, c := range []struct {
+		name string
+		gp   *GP
+	}{
+		{"iterative", &GP{
+			NDim:   1,
+			Simil:  kernel.Matern52,
+			Noise:  kernel.UniformNoise,
+			X:      X,
+			Y:      Y,
+			Solver: &Iterative{},
+		}},
+		{"parallel", &GP{
+			NDim:     1,
+			Simil:    kernel.Matern52,
+			Noise:    kernel.UniformNoise,
+			X:        X,
+			Y:        Y,
+			Solver:   &Iterative{},
+			Parallel: true,
+		}},
+		{"elemental", &GP{
+			NDim:   1,
+			Simil:  elemental{kernel.Matern52},
+			Noise:  kernel.UniformNoise,
+			X:      X,
+			Y:      Y,
+			Solver: &Iterative{},
+		}},
+	} {
+		ilml := c.gp.Observe(theta)
+		if math.Abs(ilml-lml) > 0.01*math.Abs(lml) {
+			t.Errorf("%s: lml mismatch: got %.6g, want %.6g",
+				c.name, ilml, lml)
+		}
+		igrad := c.gp.Gradient()
+		for i := range grad {
+			if math.Abs(igrad[i]-grad[i]) > 0.05*math.Abs(grad[i]) {
+				t.Errorf("%s: gradient mismatch: got %v, want %v",
+					c.name, igrad, grad)
+				break
+			}
+		}
+		imu, isigma, err := c.gp.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", c.name, err)
+		}
+		for i := range mu {
+			if math.Abs(imu[i]-mu[i]) > 1e-6 ||
+				math.Abs(isigma[i]-sigma[i]) > 1e-6 {
+				t.Errorf("%s: prediction mismatch: got %v, %v, want %v, %v",
+					c.name, imu, isigma, mu, sigma)
+				break
+			}
+		}
+	}
+}
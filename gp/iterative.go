@@ -0,0 +1,427 @@
+package gp
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"math/rand"
+)
+
+// Type Operator is a symmetric positive definite linear operator,
+// such as the covariance matrix, given by its products with
+// vectors; the matrix of the operator need not be formed.
+type Operator interface {
+	// Dim returns the dimension of the operator.
+	Dim() int
+	// MulTo computes dst[i] = A v[i] for each of vectors v.
+	MulTo(dst, v [][]float64)
+}
+
+// Type Columns is the optional interface of an operator giving
+// access to the diagonal and the columns of its matrix, used for
+// preconditioning.
+type Columns interface {
+	Diag(dst []float64)
+	Column(j int, dst []float64)
+}
+
+// Type Solver is a pluggable backend solving systems with the
+// covariance matrix given as an operator, and estimating the
+// log-determinant of the matrix.
+type Solver interface {
+	// Factorize prepares the solver for matrix K.
+	Factorize(K Operator) error
+	// LogDet returns (an estimate of) log|K|.
+	LogDet() float64
+	// SolveTo solves K x[i] = b[i] for each of vectors b.
+	SolveTo(x, b [][]float64) error
+	// Probes returns vectors u and w such that the average of
+	// the outer products u[i] w[i]^⊤ is an unbiased estimate of
+	// K^-1, for stochastic estimation of traces.
+	Probes() (u, w [][]float64)
+}
+
+// Type Iterative is the iterative solver. Systems are solved by
+// preconditioned conjugate gradients, and the log-determinant is
+// estimated by stochastic Lanczos quadrature, from the
+// coefficients of conjugate gradients on probe vectors (Gardner
+// et al., 2018). The preconditioner is the partial pivoted
+// Cholesky decomposition of K plus the diagonal residual:
+//   P = V V^⊤ + D
+// and is used when the operator implements Columns. The probe
+// vectors are drawn once, and reused in further factorizations of
+// the same dimension, so that the estimates change smoothly with
+// the hyperparameters.
+type Iterative struct {
+	Rank    int        // rank of the preconditioner, 10 by default, negative for diagonal
+	NProbes int        // number of probe vectors, 10 by default
+	Tol     float64    // relative residual tolerance, 1e-8 by default
+	MaxIter int        // maximum number of iterations, 1000 by default
+	Rand    *rand.Rand // source of probe vectors, seeded with 1 by default
+
+	op Operator // the matrix
+
+	// Preconditioner
+	v      *mat.Dense   // n×k, V
+	d      []float64    // D
+	c      mat.Cholesky // I + V^⊤ D^-1 V
+	logdet float64
+
+	eps  [][]float64 // standard normal noise for the probes
+	u, w [][]float64 // probe solves
+}
+
+const (
+	defaultRank    = 10
+	defaultProbes  = 10
+	defaultTol     = 1e-8
+	defaultMaxIter = 1000
+)
+
+func (s *Iterative) Factorize(K Operator) error {
+	s.op = K
+	n := K.Dim()
+	s.precondition()
+
+	// Probe vectors z ~ N(0, P), z = V ε_1 + D^½ ε_2
+	nprobes := s.NProbes
+	if nprobes <= 0 {
+		nprobes = defaultProbes
+	}
+	k := 0
+	if s.v != nil {
+		_, k = s.v.Dims()
+	}
+	if len(s.eps) != nprobes || len(s.eps[0]) != n+k {
+		if s.Rand == nil {
+			s.Rand = rand.New(rand.NewSource(1))
+		}
+		s.eps = make([][]float64, nprobes)
+		for i := range s.eps {
+			s.eps[i] = make([]float64, n+k)
+			for j := range s.eps[i] {
+				s.eps[i][j] = s.Rand.NormFloat64()
+			}
+		}
+	}
+	z := make([][]float64, nprobes)
+	for i := range z {
+		z[i] = make([]float64, n)
+		for j := 0; j != n; j++ {
+			z[i][j] = math.Sqrt(s.d[j]) * s.eps[i][k+j]
+		}
+		if k > 0 {
+			zi := mat.NewVecDense(n, z[i])
+			vz := mat.NewVecDense(n, nil)
+			vz.MulVec(s.v, mat.NewVecDense(k, s.eps[i][:k]))
+			zi.AddVec(zi, vz)
+		}
+	}
+
+	// Solve for the probes, and estimate the log-determinant
+	// of the preconditioned matrix from the Lanczos quadrature:
+	//   log|K| = log|P| + E[‖z‖²_{P^-1} e_1^⊤ log(T) e_1]
+	s.u = make([][]float64, nprobes)
+	for i := range s.u {
+		s.u[i] = make([]float64, n)
+	}
+	ts, w, err := s.pcg(s.u, z, true)
+	if err != nil {
+		return err
+	}
+	s.w = w
+	logdet := 0.
+	for _, t := range ts {
+		q, err := t.logQuadrature()
+		if err != nil {
+			return err
+		}
+		logdet += q
+	}
+	s.logdet += logdet / float64(nprobes)
+	return nil
+}
+
+func (s *Iterative) LogDet() float64 {
+	return s.logdet
+}
+
+func (s *Iterative) SolveTo(x, b [][]float64) error {
+	_, _, err := s.pcg(x, b, false)
+	return err
+}
+
+func (s *Iterative) Probes() (u, w [][]float64) {
+	return s.u, s.w
+}
+
+// precondition computes the preconditioner and its
+// log-determinant.
+func (s *Iterative) precondition() {
+	n := s.op.Dim()
+	s.v = nil
+	s.d = make([]float64, n)
+	cols, ok := s.op.(Columns)
+	if !ok {
+		// No preconditioner
+		for i := range s.d {
+			s.d[i] = 1
+		}
+		s.logdet = 0
+		return
+	}
+
+	// Partial pivoted Cholesky decomposition
+	cols.Diag(s.d)
+	dmax := 0.
+	for _, d := range s.d {
+		dmax = math.Max(dmax, d)
+	}
+	rank := s.Rank
+	if rank == 0 {
+		rank = defaultRank
+	}
+	rank = min(max(rank, 0), n)
+	v := make([][]float64, 0, rank) // columns of V
+	col := make([]float64, n)
+	for len(v) != rank {
+		j := 0
+		for i := range s.d {
+			if s.d[i] > s.d[j] {
+				j = i
+			}
+		}
+		if s.d[j] <= 1e-12*dmax {
+			break
+		}
+		cols.Column(j, col)
+		vj := make([]float64, n)
+		djs := math.Sqrt(s.d[j])
+		for i := range vj {
+			vij := col[i]
+			for _, vq := range v {
+				vij -= vq[i] * vq[j]
+			}
+			vj[i] = vij / djs
+		}
+		for i := range s.d {
+			s.d[i] -= vj[i] * vj[i]
+		}
+		s.d[j] = 0
+		v = append(v, vj)
+	}
+	// The residual diagonal is bounded away from zero
+	for i := range s.d {
+		s.d[i] = math.Max(s.d[i], 1e-6*dmax)
+	}
+
+	s.logdet = 0
+	for _, d := range s.d {
+		s.logdet += math.Log(d)
+	}
+	if len(v) == 0 {
+		return
+	}
+	// Woodbury identity and matrix determinant lemma:
+	//   P^-1 = D^-1 - D^-1 V (I + V^⊤ D^-1 V)^-1 V^⊤ D^-1
+	//   |P| = |D| |I + V^⊤ D^-1 V|
+	s.v = mat.NewDense(n, len(v), nil)
+	for q := range v {
+		s.v.SetCol(q, v[q])
+	}
+	a := mat.NewSymDense(len(v), nil)
+	for p := range v {
+		for q := p; q != len(v); q++ {
+			apq := 0.
+			for i := 0; i != n; i++ {
+				apq += v[p][i] * v[q][i] / s.d[i]
+			}
+			if q == p {
+				apq++
+			}
+			a.SetSym(p, q, apq)
+		}
+	}
+	if !s.c.Factorize(a) {
+		// Should not happen, a is positive definite
+		s.v = nil
+		return
+	}
+	s.logdet += s.c.LogDet()
+}
+
+// precondVecTo computes dst = P^-1 r.
+func (s *Iterative) precondVecTo(dst, r []float64) {
+	for i := range r {
+		dst[i] = r[i] / s.d[i]
+	}
+	if s.v == nil {
+		return
+	}
+	_, k := s.v.Dims()
+	vtdr := mat.NewVecDense(k, nil)
+	vtdr.MulVec(s.v.T(), mat.NewVecDense(len(dst), dst))
+	if err := s.c.SolveVecTo(vtdr, vtdr); err != nil {
+		panic(err)
+	}
+	vvtdr := mat.NewVecDense(len(dst), nil)
+	vvtdr.MulVec(s.v, vtdr)
+	for i := range dst {
+		dst[i] -= vvtdr.AtVec(i) / s.d[i]
+	}
+}
+
+// Type tridiag holds the coefficients of conjugate gradients,
+// which define the symmetric tridiagonal matrix T of Lanczos
+// coefficients:
+//   T_00 = 1/α_0, T_ii = 1/α_i + β_{i-1}/α_{i-1},
+//   T_{i-1,i} = √β_{i-1}/α_{i-1},
+// and the squared norm ‖z‖² of the starting vector.
+type tridiag struct {
+	alpha, beta []float64
+	norm2       float64
+}
+
+// logQuadrature computes ‖z‖² e_1^⊤ log(T) e_1.
+func (t tridiag) logQuadrature() (float64, error) {
+	m := len(t.alpha)
+	if m == 0 {
+		return 0, nil
+	}
+	a := mat.NewSymDense(m, nil)
+	for i := 0; i != m; i++ {
+		if i == 0 {
+			a.SetSym(i, i, 1/t.alpha[i])
+		} else {
+			a.SetSym(i, i, 1/t.alpha[i]+t.beta[i-1]/t.alpha[i-1])
+			a.SetSym(i-1, i, math.Sqrt(t.beta[i-1])/t.alpha[i-1])
+		}
+	}
+	var eig mat.EigenSym
+	if !eig.Factorize(a, true) {
+		return 0, errors.New("Lanczos quadrature: eigendecomposition failed")
+	}
+	var vecs mat.Dense
+	eig.VectorsTo(&vecs)
+	q := 0.
+	for k, theta := range eig.Values(nil) {
+		if theta <= 0 {
+			return 0, fmt.Errorf("Lanczos quadrature: eigenvalue %g", theta)
+		}
+		tau := vecs.At(0, k)
+		q += tau * tau * math.Log(theta)
+	}
+	return t.norm2 * q, nil
+}
+
+// pcg solves K x[i] = b[i] by preconditioned conjugate gradients,
+// iterating on all of the vectors together, so that the products
+// with K are computed in blocks. When lanczos is true, the Lanczos
+// matrices and P^-1 b are returned as well.
+func (s *Iterative) pcg(x, b [][]float64, lanczos bool) (
+	ts []tridiag,
+	pb [][]float64,
+	err error,
+) {
+	n := s.op.Dim()
+	tol := s.Tol
+	if tol <= 0 {
+		tol = defaultTol
+	}
+	maxIter := s.MaxIter
+	if maxIter <= 0 {
+		maxIter = defaultMaxIter
+	}
+
+	nv := len(b)
+	r := make([][]float64, nv)
+	z := make([][]float64, nv)
+	p := make([][]float64, nv)
+	q := make([][]float64, nv)
+	rz := make([]float64, nv)
+	alpha := make([]float64, nv)
+	bnorm := make([]float64, nv)
+	if lanczos {
+		ts = make([]tridiag, nv)
+		pb = make([][]float64, nv)
+	}
+	var active []int
+	for i := range b {
+		r[i] = append([]float64{}, b[i]...)
+		z[i] = make([]float64, n)
+		q[i] = make([]float64, n)
+		for j := range x[i] {
+			x[i][j] = 0
+		}
+		s.precondVecTo(z[i], r[i])
+		p[i] = append([]float64{}, z[i]...)
+		rz[i] = dot(r[i], z[i])
+		bnorm[i] = math.Sqrt(dot(b[i], b[i]))
+		if lanczos {
+			ts[i].norm2 = rz[i]
+			pb[i] = append([]float64{}, z[i]...)
+		}
+		if bnorm[i] > 0 {
+			active = append(active, i)
+		}
+	}
+
+	for iter := 0; len(active) > 0; iter++ {
+		if iter == maxIter {
+			return nil, nil, fmt.Errorf(
+				"conjugate gradients: no convergence in %d iterations",
+				maxIter)
+		}
+		// Products with K for all active vectors at once
+		pa := make([][]float64, len(active))
+		qa := make([][]float64, len(active))
+		for k, i := range active {
+			pa[k], qa[k] = p[i], q[i]
+		}
+		s.op.MulTo(qa, pa)
+
+		var next []int
+		for _, i := range active {
+			pq := dot(p[i], q[i])
+			if !(pq > 0) {
+				return nil, nil, fmt.Errorf(
+					"conjugate gradients: not positive definite, p^⊤Kp=%g", pq)
+			}
+			alpha[i] = rz[i] / pq
+			for j := 0; j != n; j++ {
+				x[i][j] += alpha[i] * p[i][j]
+				r[i][j] -= alpha[i] * q[i][j]
+			}
+			s.precondVecTo(z[i], r[i])
+			rzNext := dot(r[i], z[i])
+			beta := rzNext / rz[i]
+			rz[i] = rzNext
+
+			if lanczos {
+				ts[i].alpha = append(ts[i].alpha, alpha[i])
+				ts[i].beta = append(ts[i].beta, beta)
+			}
+
+			if math.Sqrt(dot(r[i], r[i])) <= tol*bnorm[i] {
+				continue
+			}
+			for j := 0; j != n; j++ {
+				p[i][j] = z[i][j] + beta*p[i][j]
+			}
+			next = append(next, i)
+		}
+		active = next
+	}
+
+	return ts, pb, nil
+}
+
+// dot computes the dot product of vectors a and b.
+func dot(a, b []float64) float64 {
+	s := 0.
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
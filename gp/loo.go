@@ -0,0 +1,162 @@
+package gp
+
+import (
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// inverse computes Σ^-1 from the factorization of Σ.
+func (gp *GP) inverse() (*mat.SymDense, error) {
+	Kinv := mat.NewSymDense(len(gp.X), nil)
+	if err := gp.factor().InverseTo(Kinv); err != nil {
+		return nil, err
+	}
+	return Kinv, nil
+}
+
+// LOO computes the leave-one-out predictive means and
+// variances of the outputs, and the leave-one-out log
+// predictive probability, in closed form (GPML:5.12):
+//   μ_i = y_i - α_i/[Σ^-1]_ii, σ_i^2 = 1/[Σ^-1]_ii
+// Depends on X, Y, L, Alpha, which are set by Absorb or
+// Observe. Unlike the variances returned by Produce, the
+// variances include the noise.
+func (gp *GP) LOO() (
+	mu, variance []float64,
+	lpp float64,
+	err error,
+) {
+	if len(gp.X) == 0 {
+		return nil, nil, 0, nil
+	}
+
+	Kinv, err := gp.inverse()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	mu = make([]float64, len(gp.X))
+	variance = make([]float64, len(gp.X))
+	for i := range gp.X {
+		c := Kinv.At(i, i)
+		a := gp.Alpha.AtVec(i)
+		mu[i] = gp.Y[i] - a/c
+		variance[i] = 1 / c
+		// GPML:5.10
+		lpp -= 0.5 * (math.Log(variance[i]) + a*a/c + math.Log(2*math.Pi))
+	}
+
+	return mu, variance, lpp, nil
+}
+
+// Type LOOModel is the model for fitting hyperparameters by
+// leave-one-out cross-validation rather than by the marginal
+// likelihood. The arguments of Observe are the same as of
+// GP.Observe.
+type LOOModel struct {
+	*GP
+}
+
+// Observe computes the leave-one-out log predictive probability
+// of the observations.
+func (m *LOOModel) Observe(x []float64) float64 {
+	m.destructure(x)
+
+	err := m.absorb(withGradient)
+	if err != nil {
+		panic(err)
+	}
+
+	_, _, lpp, err := m.LOO()
+	if err != nil {
+		panic(err)
+	}
+
+	return lpp
+}
+
+// Gradient computes the gradient of the leave-one-out log
+// predictive probability (GPML:5.13):
+//   ∂L/∂θ = ∑_i (α_i [Z α]_i - ½ (1 + α_i^2/[Σ^-1]_ii) [Z Σ^-1]_ii)/[Σ^-1]_ii,
+// where Z = Σ^-1 ∂Σ/∂θ.
+func (m *LOOModel) Gradient() []float64 {
+	gp := m.GP
+	var grad []float64
+	if gp.withObs {
+		grad = make([]float64,
+			gp.Simil.NTheta()+gp.Noise.NTheta()+len(gp.X)*(gp.NDim+1))
+	} else {
+		grad = make([]float64, gp.Simil.NTheta()+gp.Noise.NTheta())
+	}
+
+	if len(gp.X) == 0 {
+		// no observations, return zero gradient
+		return grad
+	}
+
+	Kinv, err := gp.inverse()
+	if err != nil {
+		panic(err)
+	}
+
+	n := len(gp.X)
+	Z := mat.NewDense(n, n, nil)
+	Za := mat.NewVecDense(n, nil)
+	for j := range gp.dK {
+		// Z = Σ^-1 ∂Σ/∂θ
+		Z.Mul(Kinv, gp.dK[j])
+		Za.MulVec(Z, gp.Alpha)
+		for i := 0; i != n; i++ {
+			c := Kinv.At(i, i)
+			a := gp.Alpha.AtVec(i)
+			// [Z Σ^-1]_ii
+			zk := 0.
+			for l := 0; l != n; l++ {
+				zk += Z.At(i, l) * Kinv.At(l, i)
+			}
+			grad[j] += (a*Za.AtVec(i) - 0.5*(1+a*a/c)*zk) / c
+		}
+	}
+
+	if gp.withObs {
+		// Gradient by outputs: ∂L/∂y = -Σ^-1 (α/diag(Σ^-1))
+		ac := mat.NewVecDense(n, nil)
+		for i := 0; i != n; i++ {
+			ac.SetVec(i, gp.Alpha.AtVec(i)/Kinv.At(i, i))
+		}
+		r := mat.NewVecDense(n, nil)
+		r.MulVec(Kinv, ac)
+		for i := 0; i != n; i++ {
+			grad[len(gp.dK)+n*gp.NDim+i] = -r.AtVec(i)
+		}
+
+		// Gradient by inputs: the sum over i above is the sum of
+		// elements of the elementwise product of ∂Σ/∂θ and
+		//   W = ½ (r α^⊤ + α r^⊤) - Σ^-1 H Σ^-1,
+		// H = diag(½ (1 + α_i^2/[Σ^-1]_ii)/[Σ^-1]_ii).
+		KH := mat.NewDense(n, n, nil)
+		for i := 0; i != n; i++ {
+			c := Kinv.At(i, i)
+			a := gp.Alpha.AtVec(i)
+			h := 0.5 * (1 + a*a/c) / c
+			for l := 0; l != n; l++ {
+				KH.Set(l, i, Kinv.At(l, i)*h)
+			}
+		}
+		var KHK mat.Dense
+		KHK.Mul(KH, Kinv)
+		W := mat.NewSymDense(n, nil)
+		for i := 0; i != n; i++ {
+			for l := i; l != n; l++ {
+				W.SetSym(i, l, 0.5*(r.AtVec(i)*gp.Alpha.AtVec(l)+
+					gp.Alpha.AtVec(i)*r.AtVec(l))-KHK.At(i, l))
+			}
+		}
+		gp.inputGradient(grad[len(gp.dK):], W, 1)
+	}
+
+	// forget dK and dX to release memory
+	gp.dK, gp.dX = nil, nil
+
+	return grad
+}
@@ -15,7 +15,8 @@ type Model struct {
 func (m *Model) Observe(x []float64) float64 {
 	var gll, pll float64
 	gll, m.gGrad = m.GP.Observe(x), model.Gradient(m.GP)
-	pll, m.pGrad = m.Priors.Observe(x), model.Gradient(m.Priors)
+	// The priors may be differentiated through the tape.
+	pll, m.pGrad = m.GP.observer(m.Priors).Observe(x, withGradient)
 	return gll + pll
 }
 
@@ -26,3 +27,27 @@ func (m *Model) Gradient() []float64 {
 
 	return m.gGrad
 }
+
+// FuncGrad returns the function to minimize and the gradient,
+// suitable as fields for gonum optimize.Problem, corresponding
+// to maximization of the log-likelihood of m, a GP or a Model.
+// Unlike infer.FuncGrad, the calls are not serialized by a lock
+// of their own: the GP serializes its calls through the tape,
+// hence GPs are fit concurrently.
+func FuncGrad(m model.ElementalModel) (
+	Func func(x []float64) float64,
+	Grad func(grad, x []float64),
+) {
+	Func = func(x []float64) float64 {
+		ll := m.Observe(x)
+		model.DropGradient(m)
+		return -ll
+	}
+	Grad = func(grad, x []float64) {
+		_, grad_ := m.Observe(x), model.Gradient(m)
+		for i := range grad_ {
+			grad[i] = -grad_[i]
+		}
+	}
+	return Func, Grad
+}
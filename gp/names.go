@@ -0,0 +1,99 @@
+package gp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type Named is the optional interface of a kernel reporting
+// the names of the hyperparameters. A composite kernel should
+// prefix the names of its children's hyperparameters (see
+// kernel.Prefix). Hyperparameters of a kernel not implementing
+// Named are called theta0, theta1, ....
+type Named interface {
+	Names() []string
+}
+
+// KernelNames returns the names of the kernel's hyperparameters.
+func KernelNames(k Kernel) []string {
+	if k, ok := k.(Named); ok {
+		return k.Names()
+	}
+	names := make([]string, k.NTheta())
+	for i := range names {
+		names[i] = fmt.Sprintf("theta%d", i)
+	}
+	return names
+}
+
+// Names returns the names of the hyperparameters, in the order
+// of the arguments of Observe. The names of the similarity kernel
+// parameters are prefixed by "simil.", of the noise kernel
+// parameters --- by "noise.".
+func (gp *GP) Names() []string {
+	gp.defaults()
+	var all []string
+	for _, name := range KernelNames(gp.Simil) {
+		all = append(all, "simil."+name)
+	}
+	for _, name := range KernelNames(gp.Noise) {
+		all = append(all, "noise."+name)
+	}
+	return all
+}
+
+// Index returns the index of the named hyperparameter in the
+// arguments of Observe, or -1 if there is no such hyperparameter.
+func (gp *GP) Index(name string) int {
+	for i, n := range gp.Names() {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Params returns the values of the hyperparameters, ThetaSimil
+// and ThetaNoise, by name.
+func (gp *GP) Params() map[string]float64 {
+	gp.defaults()
+	params := make(map[string]float64)
+	for i, name := range KernelNames(gp.Simil) {
+		params["simil."+name] = gp.ThetaSimil[i]
+	}
+	for i, name := range KernelNames(gp.Noise) {
+		params["noise."+name] = gp.ThetaNoise[i]
+	}
+	return params
+}
+
+// describe returns a readable description of the kernel with
+// the values of the hyperparameters.
+func describe(k Kernel, theta []float64) string {
+	b := strings.Builder{}
+	if s, ok := k.(fmt.Stringer); ok {
+		b.WriteString(s.String())
+	} else {
+		fmt.Fprintf(&b, "%T", k)
+	}
+	if k.NTheta() > 0 {
+		b.WriteString("(")
+		for i, name := range KernelNames(k) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s=%.6g", name, theta[i])
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// String returns a readable description of the process: the
+// similarity and the noise kernels, with the values of the
+// hyperparameters.
+func (gp *GP) String() string {
+	gp.defaults()
+	return describe(gp.Simil, gp.ThetaSimil) + " + " +
+		describe(gp.Noise, gp.ThetaNoise)
+}
@@ -0,0 +1,184 @@
+package gp
+
+import (
+	"bitbucket.org/dtolpin/infergo/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"runtime"
+	"sync"
+)
+
+// Parallel computations
+//
+// In parallel mode, work is distributed among a bounded number
+// of workers. The covariance matrices are split into square
+// tiles, and each tile is computed by a single worker. Each
+// worker owns its buffers, so the memory used in addition to
+// the results is proportional to the number of workers rather
+// than to the size of the matrices. Every element of the
+// results is computed by exactly one worker, independently of
+// the order in which the tiles are processed, hence the results
+// are deterministic.
+//
+// Parallel mode does not require thread-safe automatic
+// differentiation (ad.MTSafeOn), which slows down all models in
+// the process. Kernels implementing Batch or Stationary do not
+// use the tape, and their blocks are computed concurrently.
+// A kernel implementing Replicable gives each worker its own
+// instance. Other kernels share the single tape, and their
+// calls are serialized, unless the tape is thread-safe.
+//
+// The tape is shared by all models in the process. A GP
+// serializes its calls through the tape by a single process-wide
+// lock, whether the GP is parallel or not, hence several GPs can
+// be used concurrently. Code calling kernels, or other models
+// differentiated through the tape, outside of a GP must call them
+// through Call, which takes the same lock; the GPs of
+// packages statespace, grid and rff do. infer.FuncGrad takes a
+// lock of its own around every call of a GP, FuncGrad does not,
+// hence GPs fit by FuncGrad are fit concurrently.
+//
+// The kernels of package kernel are differentiated through the
+// tape: their blocks of covariances are computed concurrently,
+// but single evaluations, such as of the noise and of the prior
+// variances of predictions, are serialized across all workers
+// and GPs. Kernel expressions (package expr) do not use the tape
+// and are not serialized.
+
+// tileSize is the side of a tile of a covariance matrix.
+const tileSize = 32
+
+// Type tile is a block [i0, i1) × [j0, j1) of a matrix.
+type tile struct {
+	i0, i1, j0, j1 int
+}
+
+// tiles splits an n×m matrix into tiles. When upper is true,
+// only tiles intersecting the upper triangle of the matrix
+// are returned.
+func tiles(n, m int, upper bool) []tile {
+	var ts []tile
+	for i0 := 0; i0 < n; i0 += tileSize {
+		j0 := 0
+		if upper {
+			j0 = i0
+		}
+		for ; j0 < m; j0 += tileSize {
+			ts = append(ts, tile{
+				i0, min(i0+tileSize, n),
+				j0, min(j0+tileSize, m),
+			})
+		}
+	}
+	return ts
+}
+
+// workers returns the number of workers.
+func (gp *GP) workers() int {
+	if gp.Workers > 0 {
+		return gp.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallel performs n tasks, indexed 0 to n-1, on the workers.
+// newWorker is called once in each worker and returns the
+// function performing a task; the worker's buffers should be
+// allocated in newWorker.
+func (gp *GP) parallel(n int, newWorker func() func(k int)) {
+	tasks := make(chan int, n)
+	for k := 0; k != n; k++ {
+		tasks <- k
+	}
+	close(tasks)
+
+	var wg sync.WaitGroup
+	for w := 0; w != min(gp.workers(), n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work := newWorker()
+			for k := range tasks {
+				work(k)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Type Replicable is the optional interface of a kernel which
+// does not use the automatic differentiation tape, for example
+// an elemental model with a hand-written gradient, but keeps
+// state between Observe and Gradient. Replicate returns a new
+// instance of the kernel; in parallel mode, each worker calls
+// Replicate and uses its own instance concurrently with others.
+type Replicable interface {
+	Replicate() Kernel
+}
+
+// tapeMutex serializes the calls through the shared tape, when
+// the tape is not thread-safe.
+var tapeMutex sync.Mutex
+
+// isMTSafe reports whether the tape is thread-safe; replaced in
+// tests.
+var isMTSafe = ad.IsMTSafe
+
+// Type observer calls a kernel in a worker.
+type observer struct {
+	k    model.Model
+	lock bool // when true, the calls are serialized
+}
+
+// observer returns the observer of kernel k for a worker. It
+// must be called in newWorker, once for each worker, and by
+// serial code before calling the kernel.
+func (gp *GP) observer(k model.Model) observer {
+	if r, ok := k.(Replicable); ok {
+		if gp.Parallel {
+			return observer{k: r.Replicate()}
+		}
+		return observer{k: k}
+	}
+	return newObserver(k)
+}
+
+// newObserver returns the observer of model m outside of a
+// worker; other GPs may use the tape concurrently.
+func newObserver(m model.Model) observer {
+	o := observer{k: m}
+	if _, ok := m.(Replicable); !ok {
+		o.lock = !isMTSafe()
+	}
+	return o
+}
+
+// Call returns the value of model m, such as a kernel, for
+// arguments x and, when withGrad is true, the gradient. Unless m
+// is Replicable or the tape is thread-safe, the call is
+// serialized with the calls through the tape of all GPs.
+func Call(
+	m model.Model,
+	x []float64,
+	withGrad bool,
+) (v float64, grad []float64) {
+	return newObserver(m).Observe(x, withGrad)
+}
+
+// Observe returns the value of the kernel for arguments x and,
+// when withGrad is true, the gradient.
+func (o observer) Observe(
+	x []float64,
+	withGrad bool,
+) (v float64, grad []float64) {
+	if o.lock {
+		tapeMutex.Lock()
+		defer tapeMutex.Unlock()
+	}
+	v = o.k.Observe(x)
+	if withGrad {
+		grad = model.Gradient(o.k)
+	} else {
+		model.DropGradient(o.k)
+	}
+	return v, grad
+}
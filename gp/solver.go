@@ -0,0 +1,323 @@
+package gp
+
+import (
+	"errors"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Matrix-free inference
+//
+// When GP.Solver is set, the covariance matrix is never formed.
+// The covariances are recomputed, block by block, in each
+// product of the covariance matrix with vectors, and the
+// gradient of the log marginal likelihood is estimated from the
+// probe vectors of the solver:
+//   ∇L = ½ ∑_ab (α_a α_b - [Σ^-1]_ab) [∂Σ/∂θ]_ab,
+//   Σ^-1 ≈ 1/m ∑_i u_i w_i^⊤
+// in a single pass over the blocks. Inputs cannot be inferred.
+
+// Covariances computes a block of covariances of kernel k with
+// parameters theta between inputs xa and xb, in row-major order,
+// and, when withGrad is true, their derivatives by the parameters.
+// The kernel computes the block at once if it implements Batch,
+// otherwise the covariances are computed through Observe.
+func Covariances(
+	k Kernel,
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	if b, ok := k.(Batch); ok {
+		return b.Covariances(theta, xa, xb, withGrad)
+	}
+	ndim := 0
+	if len(xa) > 0 {
+		ndim = len(xa[0])
+	}
+	kargs := make([]float64, k.NTheta()+2*ndim)
+	return covariances(newObserver(k), theta, xa, xb, withGrad, kargs)
+}
+
+// covariances computes the covariances through Observe of the
+// kernel observer, with argument buffer kargs.
+func covariances(
+	o observer,
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+	kargs []float64,
+) (cov []float64, dcov [][]float64) {
+	cov = make([]float64, len(xa)*len(xb))
+	if withGrad {
+		dcov = make([][]float64, len(theta))
+		for p := range dcov {
+			dcov[p] = make([]float64, len(cov))
+		}
+	}
+	copy(kargs, theta)
+	for i := range xa {
+		copy(kargs[len(theta):], xa[i])
+		for j := range xb {
+			copy(kargs[len(theta)+len(xa[i]):], xb[j])
+			var kgrad []float64
+			cov[i*len(xb)+j], kgrad = o.Observe(kargs, withGrad)
+			for p := range dcov {
+				dcov[p][i*len(xb)+j] = kgrad[p]
+			}
+		}
+	}
+	return cov, dcov
+}
+
+// covariances computes a block of covariances of the similarity
+// kernel, with the derivatives by the transformed parameters.
+// kargs is the argument buffer of Observe, and simil is the
+// observer of the similarity kernel.
+func (gp *GP) covariances(
+	xa, xb [][]float64,
+	withGrad bool,
+	kargs []float64,
+	simil observer,
+) (k []float64, dk [][]float64) {
+	if b, ok := gp.Simil.(Batch); ok {
+		k, dk = b.Covariances(gp.ThetaSimil, xa, xb, withGrad)
+	} else {
+		k, dk = covariances(simil, gp.ThetaSimil, xa, xb, withGrad, kargs)
+	}
+	for p := range dk {
+		for ij := range dk[p] {
+			dk[p][ij] *= gp.dThetaSimil[p]
+		}
+	}
+	return k, dk
+}
+
+// Type covariance is the covariance matrix of the observations
+// as an Operator.
+type covariance struct {
+	gp     *GP
+	noise  []float64   // noise at the inputs
+	dnoise [][]float64 // derivatives of the noise
+}
+
+// newCovariance creates the covariance operator, computing the
+// noise and, when withGrad is true, its derivatives by the
+// transformed parameters.
+func (gp *GP) newCovariance(withGrad bool) *covariance {
+	c := &covariance{
+		gp:    gp,
+		noise: make([]float64, len(gp.X)),
+	}
+	if withGrad {
+		c.dnoise = make([][]float64, gp.Noise.NTheta())
+		for p := range c.dnoise {
+			c.dnoise[p] = make([]float64, len(gp.X))
+		}
+	}
+	nargs := make([]float64, gp.Noise.NTheta()+gp.NDim)
+	copy(nargs, gp.ThetaNoise)
+	noise := gp.observer(gp.Noise)
+	for i := range gp.X {
+		copy(nargs[gp.Noise.NTheta():], gp.X[i])
+		var ngrad []float64
+		c.noise[i], ngrad = noise.Observe(nargs, withGrad)
+		for p := range c.dnoise {
+			c.dnoise[p][i] = ngrad[p] * gp.dThetaNoise[p]
+		}
+	}
+	return c
+}
+
+func (c *covariance) Dim() int {
+	return len(c.gp.X)
+}
+
+// rows applies f to each row block of the matrix, split into
+// tiles, in parallel in parallel mode. f receives the tile, the
+// covariances and, when withGrad is true, their derivatives, as
+// returned by GP.covariances.
+func (c *covariance) rows(
+	withGrad bool,
+	newWorker func() func(t tile, k []float64, dk [][]float64),
+) {
+	gp := c.gp
+	n := len(gp.X)
+	nblocks := (n + tileSize - 1) / tileSize
+	worker := func() func(int) {
+		kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
+		simil := gp.observer(gp.Simil)
+		f := newWorker()
+		return func(ib int) {
+			i0, i1 := ib*tileSize, min((ib+1)*tileSize, n)
+			for j0 := 0; j0 < n; j0 += tileSize {
+				j1 := min(j0+tileSize, n)
+				k, dk := gp.covariances(
+					gp.X[i0:i1], gp.X[j0:j1], withGrad, kargs, simil)
+				f(tile{i0, i1, j0, j1}, k, dk)
+			}
+		}
+	}
+	if gp.Parallel {
+		gp.parallel(nblocks, worker)
+	} else {
+		w := worker()
+		for ib := 0; ib != nblocks; ib++ {
+			w(ib)
+		}
+	}
+}
+
+func (c *covariance) MulTo(dst, v [][]float64) {
+	// Each row block of dst is computed by a single worker.
+	c.rows(withoutGradient, func() func(tile, []float64, [][]float64) {
+		return func(t tile, k []float64, _ [][]float64) {
+			w := t.j1 - t.j0
+			for r := range v {
+				for i := t.i0; i != t.i1; i++ {
+					if t.j0 == 0 {
+						dst[r][i] = c.noise[i] * v[r][i]
+					}
+					row := k[(i-t.i0)*w : (i-t.i0+1)*w]
+					dst[r][i] += dot(row, v[r][t.j0:t.j1])
+				}
+			}
+		}
+	})
+}
+
+func (c *covariance) Diag(dst []float64) {
+	gp := c.gp
+	kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
+	simil := gp.observer(gp.Simil)
+	for i := range gp.X {
+		k, _ := gp.covariances(
+			gp.X[i:i+1], gp.X[i:i+1], withoutGradient, kargs, simil)
+		dst[i] = k[0] + c.noise[i]
+	}
+}
+
+func (c *covariance) Column(j int, dst []float64) {
+	gp := c.gp
+	kargs := make([]float64, gp.Simil.NTheta()+2*gp.NDim)
+	k, _ := gp.covariances(
+		gp.X, gp.X[j:j+1], withoutGradient, kargs, gp.observer(gp.Simil))
+	copy(dst, k)
+	dst[j] += c.noise[j]
+}
+
+// absorbSolver absorbs the observations through the solver.
+func (gp *GP) absorbSolver(withGrad bool) error {
+	if gp.withObs {
+		return errors.New("inputs cannot be inferred with Solver")
+	}
+	gp.dK = nil
+	gp.toeplitz = nil
+	gp.covariance = gp.newCovariance(withGrad)
+	if err := gp.Solver.Factorize(gp.covariance); err != nil {
+		return err
+	}
+	alpha := make([]float64, len(gp.X))
+	if err := gp.Solver.SolveTo(
+		[][]float64{alpha}, [][]float64{gp.Y}); err != nil {
+		return err
+	}
+	gp.Alpha = mat.NewVecDense(len(gp.X), alpha)
+	return nil
+}
+
+// solverGradient estimates the gradient by the hyperparameters
+// from the probe vectors of the solver.
+func (gp *GP) solverGradient(grad []float64) {
+	n := len(gp.X)
+	u, w := gp.Solver.Probes()
+	// Probes by element, for locality
+	ut := make([][]float64, n)
+	wt := make([][]float64, n)
+	for a := 0; a != n; a++ {
+		ut[a] = make([]float64, len(u))
+		wt[a] = make([]float64, len(w))
+		for i := range u {
+			ut[a][i] = u[i][a] / float64(len(u))
+			wt[a][i] = w[i][a]
+		}
+	}
+	// W_ab = α_a α_b - [Σ^-1]_ab
+	W := func(a, b int) float64 {
+		return gp.Alpha.AtVec(a)*gp.Alpha.AtVec(b) - dot(ut[a], wt[b])
+	}
+
+	// Partial sums are accumulated for each row block and then
+	// added in order, for deterministic results.
+	ns := gp.Simil.NTheta()
+	nblocks := (n + tileSize - 1) / tileSize
+	partial := make([][]float64, nblocks)
+	for ib := range partial {
+		partial[ib] = make([]float64, ns)
+	}
+	gp.covariance.rows(withGradient, func() func(tile, []float64, [][]float64) {
+		return func(t tile, _ []float64, dk [][]float64) {
+			s := partial[t.i0/tileSize]
+			w := t.j1 - t.j0
+			for a := t.i0; a != t.i1; a++ {
+				for b := t.j0; b != t.j1; b++ {
+					wab := W(a, b)
+					for p := range dk {
+						s[p] += dk[p][(a-t.i0)*w+b-t.j0] * wab
+					}
+				}
+			}
+		}
+	})
+	for _, s := range partial {
+		for p := range s {
+			grad[p] += 0.5 * s[p]
+		}
+	}
+	for p, dn := range gp.covariance.dnoise {
+		for a := range dn {
+			grad[ns+p] += 0.5 * dn[a] * W(a, a)
+		}
+	}
+}
+
+// Type solverFactor adapts a Solver to the factor interface.
+type solverFactor struct {
+	Solver
+}
+
+func (f solverFactor) SolveTo(dst *mat.Dense, b mat.Matrix) error {
+	n, m := b.Dims()
+	x := make([][]float64, m)
+	bs := make([][]float64, m)
+	for j := range bs {
+		x[j] = make([]float64, n)
+		bs[j] = make([]float64, n)
+		mat.Col(bs[j], j, b)
+	}
+	if err := f.Solver.SolveTo(x, bs); err != nil {
+		return err
+	}
+	for j := range x {
+		dst.SetCol(j, x[j])
+	}
+	return nil
+}
+
+func (f solverFactor) SolveVecTo(dst *mat.VecDense, b mat.Vector) error {
+	x := make([]float64, b.Len())
+	bs := make([]float64, b.Len())
+	for i := range bs {
+		bs[i] = b.AtVec(i)
+	}
+	if err := f.Solver.SolveTo([][]float64{x}, [][]float64{bs}); err != nil {
+		return err
+	}
+	for i := range x {
+		dst.SetVec(i, x[i])
+	}
+	return nil
+}
+
+func (f solverFactor) InverseTo(*mat.SymDense) error {
+	return errors.New("the inverse is not computed with Solver")
+}
@@ -0,0 +1,89 @@
+package gp
+
+// Cached differences of inputs
+//
+// The covariances of a stationary kernel depend on the inputs
+// only through their differences. During inference on the
+// hyperparameters the inputs do not change, hence the
+// differences are computed once, tile by tile, and reused in
+// every call to Observe until X changes.
+
+// Type Stationary is the optional interface of a stationary
+// similarity kernel. DiffCovariances returns the covariances for
+// differences r of pairs of inputs, where r[k] holds the
+// differences xa[k] - xb[k] in dimension k, and, when withGrad
+// is true, their derivatives by each of the parameters, in the
+// same layout as Batch.Covariances. In parallel mode,
+// DiffCovariances is called concurrently.
+type Stationary interface {
+	DiffCovariances(
+		theta []float64,
+		r [][]float64,
+		withGrad bool,
+	) (k []float64, dk [][]float64)
+}
+
+// differences computes the differences of the inputs in tile t,
+// in row-major order, by dimension.
+func (gp *GP) differences(t tile) [][]float64 {
+	w := t.j1 - t.j0
+	r := make([][]float64, gp.NDim)
+	for k := range r {
+		r[k] = make([]float64, (t.i1-t.i0)*w)
+		for i := t.i0; i != t.i1; i++ {
+			for j := t.j0; j != t.j1; j++ {
+				r[k][(i-t.i0)*w+j-t.j0] = gp.X[i][k] - gp.X[j][k]
+			}
+		}
+	}
+	return r
+}
+
+// cachedDifferences returns the differences of the inputs in
+// tiles ts, recomputing them if the inputs have changed since
+// the last call.
+func (gp *GP) cachedDifferences(ts []tile) map[tile][][]float64 {
+	if gp.diffs != nil && sameInputs(gp.diffX, gp.X) {
+		return gp.diffs
+	}
+	rs := make([][][]float64, len(ts))
+	if gp.Parallel {
+		gp.parallel(len(ts), func() func(int) {
+			return func(it int) {
+				rs[it] = gp.differences(ts[it])
+			}
+		})
+	} else {
+		for it, t := range ts {
+			rs[it] = gp.differences(t)
+		}
+	}
+	gp.diffs = make(map[tile][][]float64, len(ts))
+	for it, t := range ts {
+		gp.diffs[t] = rs[it]
+	}
+	// The inputs are copied, since X may be modified in place.
+	gp.diffX = make([][]float64, len(gp.X))
+	for i := range gp.X {
+		gp.diffX[i] = append([]float64{}, gp.X[i]...)
+	}
+	return gp.diffs
+}
+
+// sameInputs returns true if the inputs are equal.
+func sameInputs(xa, xb [][]float64) bool {
+	if len(xa) != len(xb) {
+		return false
+	}
+	for i := range xa {
+		if len(xa[i]) != len(xb[i]) {
+			return false
+		}
+		for k := range xa[i] {
+			if xa[i][k] != xb[i][k] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package gp
+
+import (
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// Type factor is a factorization of the covariance matrix,
+// used to solve systems and compute the log-determinant.
+// mat.Cholesky is a factor.
+type factor interface {
+	LogDet() float64
+	SolveTo(dst *mat.Dense, b mat.Matrix) error
+	SolveVecTo(dst *mat.VecDense, b mat.Vector) error
+	InverseTo(dst *mat.SymDense) error
+}
+
+// factor returns the factorization of the covariance matrix.
+func (gp *GP) factor() factor {
+	if gp.Solver != nil {
+		return solverFactor{gp.Solver}
+	}
+	if gp.toeplitz != nil {
+		return gp.toeplitz
+	}
+	return &gp.L
+}
+
+// Type toeplitz is a factorization of a symmetric positive
+// definite Toeplitz matrix, given by its first row. The inverse
+// is computed by the Trench algorithm and the log-determinant
+// by the Durbin algorithm (Golub and Van Loan, 4.7), both in
+// O(n^2).
+type toeplitz struct {
+	inv    *mat.SymDense
+	logdet float64
+}
+
+// Factorize factorizes the Toeplitz matrix with first row t,
+// and returns false if the matrix is not positive definite.
+func (f *toeplitz) Factorize(t []float64) (ok bool) {
+	n := len(t)
+	f.inv = mat.NewSymDense(n, nil)
+	if t[0] <= 0 {
+		return false
+	}
+	f.logdet = float64(n) * math.Log(t[0])
+	if n == 1 {
+		f.inv.SetSym(0, 0, 1/t[0])
+		return true
+	}
+
+	// Normalized first row, r[k] = t[k+1]/t[0]
+	r := make([]float64, n-1)
+	for k := range r {
+		r[k] = t[k+1] / t[0]
+	}
+
+	// Durbin: solve T_{n-1} y = -r, accumulating the
+	// log-determinant from the prediction error variances.
+	y := make([]float64, n-1)
+	z := make([]float64, n-1)
+	y[0] = -r[0]
+	beta, alpha := 1., -r[0]
+	for k := 1; ; k++ {
+		beta *= 1 - alpha*alpha
+		if beta <= 0 {
+			return false
+		}
+		f.logdet += math.Log(beta)
+		if k == n-1 {
+			break
+		}
+		s := r[k]
+		for j := 0; j != k; j++ {
+			s += r[k-1-j] * y[j]
+		}
+		alpha = -s / beta
+		for j := 0; j != k; j++ {
+			z[j] = y[j] + alpha*y[k-1-j]
+		}
+		copy(y, z[:k])
+		y[k] = alpha
+	}
+
+	// Trench: the inverse of the normalized matrix. The first
+	// row is γ (1, y); the rest of the upper wedge follows by
+	// the recurrence, and the remaining elements by symmetry
+	// and persymmetry.
+	gamma := 1.
+	for j := range r {
+		gamma += r[j] * y[j]
+	}
+	gamma = 1 / gamma
+	// v[k] = γ y[n-2-k], indexed from 1 as in the algorithm
+	v := func(k int) float64 { return gamma * y[n-1-k] }
+	b := mat.NewDense(n, n, nil)
+	b.Set(0, 0, gamma)
+	for j := 1; j != n; j++ {
+		b.Set(0, j, gamma*y[j-1])
+	}
+	for i := 2; i <= (n-1)/2+1; i++ {
+		for j := i; j <= n-i+1; j++ {
+			b.Set(i-1, j-1, b.At(i-2, j-2)+
+				(v(n+1-j)*v(n+1-i)-v(i-1)*v(j-1))/gamma)
+		}
+	}
+	for i := 0; i != n; i++ {
+		for j := i; j != n; j++ {
+			var bij float64
+			switch {
+			case i <= j && j <= n-1-i:
+				// upper wedge
+				bij = b.At(i, j)
+			default:
+				// persymmetry: b(i, j) = b(n-1-j, n-1-i)
+				bij = b.At(n-1-j, n-1-i)
+			}
+			f.inv.SetSym(i, j, bij/t[0])
+		}
+	}
+	return true
+}
+
+func (f *toeplitz) LogDet() float64 {
+	return f.logdet
+}
+
+func (f *toeplitz) SolveTo(dst *mat.Dense, b mat.Matrix) error {
+	dst.Mul(f.inv, b)
+	return nil
+}
+
+func (f *toeplitz) SolveVecTo(dst *mat.VecDense, b mat.Vector) error {
+	dst.MulVec(f.inv, b)
+	return nil
+}
+
+func (f *toeplitz) InverseTo(dst *mat.SymDense) error {
+	dst.CopySym(f.inv)
+	return nil
+}
+
+// regular returns true if the inputs are regularly spaced.
+func regular(x [][]float64) bool {
+	if len(x) < 2 {
+		return true
+	}
+	for k := range x[0] {
+		step := x[1][k] - x[0][k]
+		tol := 1e-9 * (math.Abs(step) + math.Abs(x[0][k]))
+		for i := 2; i != len(x); i++ {
+			if math.Abs(x[i][k]-x[i-1][k]-step) > tol {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// constantNoise returns true if the noise and its gradient are
+// the same at all inputs, as the Toeplitz structure of the
+// covariance matrix requires.
+func (gp *GP) constantNoise() bool {
+	nargs := make([]float64, gp.Noise.NTheta()+gp.NDim)
+	copy(nargs, gp.ThetaNoise)
+	noise := gp.observer(gp.Noise)
+	var n0 float64
+	var grad0 []float64
+	for i := range gp.X {
+		copy(nargs[gp.Noise.NTheta():], gp.X[i])
+		n, grad := noise.Observe(nargs, withGradient)
+		grad = grad[:gp.Noise.NTheta()]
+		if i == 0 {
+			n0, grad0 = n, append([]float64{}, grad...)
+			continue
+		}
+		if n != n0 {
+			return false
+		}
+		for j := range grad {
+			if grad[j] != grad0[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+// Package gpfile reads and writes fitted Gaussian processes.
+// A file holds the kernel expression (see package expr), the
+// hyperparameters, the observations, and the normalization of
+// the outputs, in JSON, and is turned back into a GP ready for
+// predictions.
+package gpfile
+
+import (
+	"bitbucket.org/dtolpin/gogp/expr"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Type File is a fitted GP. The outputs are stored as observed;
+// the GP is fit to the outputs normalized by Mean and Std.
+type File struct {
+	Kernel     string      `json:"kernel"` // expression, see expr.Parse
+	NDim       int         `json:"ndim"`
+	Names      []string    `json:"names,omitempty"` // of hyperparameters, for reading
+	ThetaSimil []float64   `json:"theta_simil"`
+	ThetaNoise []float64   `json:"theta_noise"`
+	X          [][]float64 `json:"x"`
+	Y          []float64   `json:"y"`
+	Mean       float64     `json:"mean"`
+	Std        float64     `json:"std"`
+}
+
+// New creates a file for GP g, with the kernels parsed from
+// expression kernel, fit to outputs normalized by mean and std.
+func New(g *gp.GP, kernel string, mean, std float64) *File {
+	f := &File{
+		Kernel:     kernel,
+		NDim:       g.NDim,
+		Names:      g.Names(),
+		ThetaSimil: append([]float64{}, g.ThetaSimil...),
+		ThetaNoise: append([]float64{}, g.ThetaNoise...),
+		X:          g.X,
+		Y:          make([]float64, len(g.Y)),
+		Mean:       mean,
+		Std:        std,
+	}
+	copy(f.Y, g.Y)
+	f.Denormalize(f.Y, nil)
+	return f
+}
+
+// Read reads a file in JSON.
+func Read(r io.Reader) (*File, error) {
+	f := &File{}
+	if err := json.NewDecoder(r).Decode(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write writes the file in JSON.
+func (f *File) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+// GP returns the GP with the hyperparameters and the
+// observations of the file absorbed.
+func (f *File) GP() (*gp.GP, error) {
+	simil, noise, err := expr.Parse(f.Kernel)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.ThetaSimil) != simil.NTheta() {
+		return nil, fmt.Errorf("kernel %s: %d similarity parameters, "+
+			"want %d", f.Kernel, len(f.ThetaSimil), simil.NTheta())
+	}
+	nnoise := 0
+	if noise != nil {
+		nnoise = noise.NTheta()
+	}
+	if len(f.ThetaNoise) != nnoise {
+		return nil, fmt.Errorf("kernel %s: %d noise parameters, want %d",
+			f.Kernel, len(f.ThetaNoise), nnoise)
+	}
+	if f.NDim != 1 {
+		return nil, fmt.Errorf("%d dimensions, kernel expressions "+
+			"have a single input", f.NDim)
+	}
+	if len(f.X) != len(f.Y) {
+		return nil, fmt.Errorf("%d inputs, %d outputs", len(f.X), len(f.Y))
+	}
+	for i := range f.X {
+		if len(f.X[i]) != f.NDim {
+			return nil, fmt.Errorf("input %d: %d dimensions, want %d",
+				i, len(f.X[i]), f.NDim)
+		}
+	}
+
+	g := &gp.GP{
+		NDim:       f.NDim,
+		Simil:      simil,
+		Noise:      noise,
+		ThetaSimil: append([]float64{}, f.ThetaSimil...),
+		ThetaNoise: append([]float64{}, f.ThetaNoise...),
+	}
+	if err := g.Absorb(f.X, f.Normalize(f.Y)); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Normalize returns the outputs normalized by Mean and Std.
+func (f *File) Normalize(y []float64) []float64 {
+	std := f.std()
+	ny := make([]float64, len(y))
+	for i := range y {
+		ny[i] = (y[i] - f.Mean) / std
+	}
+	return ny
+}
+
+// Denormalize maps predictive means and standard deviations of
+// the normalized outputs to the scale of the observations, in
+// place; sigma may be nil.
+func (f *File) Denormalize(mu, sigma []float64) {
+	std := f.std()
+	for i := range mu {
+		mu[i] = mu[i]*std + f.Mean
+	}
+	for i := range sigma {
+		sigma[i] *= std
+	}
+}
+
+// std returns Std, or 1 if the outputs are not normalized.
+func (f *File) std() float64 {
+	if f.Std == 0 {
+		return 1
+	}
+	return f.Std
+}
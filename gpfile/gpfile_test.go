@@ -0,0 +1,95 @@
+package gpfile
+
+import (
+	"bitbucket.org/dtolpin/gogp/expr"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bytes"
+	"math"
+	"testing"
+)
+
+var (
+	x = [][]float64{{0}, {0.5}, {1.5}, {2}, {3}}
+	y = []float64{10, 12, 11, 9, 8}
+	z = [][]float64{{-1}, {0.25}, {2.5}, {4}}
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, kernel := range []string{
+		"c*Normal(l) + UniformNoise(s)",
+		"c*Periodic(l, p)*Matern52(l2) + UniformNoise(s)",
+		"c*Matern32(l) + ConstantNoise(0.1)",
+		"c*Matern52(l)",
+	} {
+		simil, noise, err := expr.Parse(kernel)
+		if err != nil {
+			t.Fatalf("%s: %v", kernel, err)
+		}
+		f := &File{Mean: 10, Std: 2}
+		g := &gp.GP{
+			NDim:  1,
+			Simil: simil,
+			Noise: noise,
+		}
+		theta := make([]float64, len(g.Names()))
+		for i := range theta {
+			theta[i] = 0.1 * float64(i+1)
+		}
+		g.X, g.Y = x, f.Normalize(y)
+		g.Observe(theta)
+		mu, sigma, err := g.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", kernel, err)
+		}
+		f.Denormalize(mu, sigma)
+
+		var buf bytes.Buffer
+		if err := New(g, kernel, 10, 2).Write(&buf); err != nil {
+			t.Fatalf("%s: write: %v", kernel, err)
+		}
+		f, err = Read(&buf)
+		if err != nil {
+			t.Fatalf("%s: read: %v", kernel, err)
+		}
+		for i := range y {
+			if math.Abs(f.Y[i]-y[i]) > 1e-12 {
+				t.Errorf("%s: wrong outputs: got %v, want %v",
+					kernel, f.Y, y)
+				break
+			}
+		}
+		h, err := f.GP()
+		if err != nil {
+			t.Fatalf("%s: GP: %v", kernel, err)
+		}
+		hmu, hsigma, err := h.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", kernel, err)
+		}
+		f.Denormalize(hmu, hsigma)
+		for i := range z {
+			if math.Abs(mu[i]-hmu[i]) > 1e-9 ||
+				math.Abs(sigma[i]-hsigma[i]) > 1e-9 {
+				t.Errorf("%s: wrong predictions: got %v, %v, want %v, %v",
+					kernel, hmu, hsigma, mu, sigma)
+				break
+			}
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, f := range []*File{
+		{Kernel: "c*Matern(l)", NDim: 1},
+		{Kernel: "c*Matern52(l)", NDim: 1, ThetaSimil: []float64{1}},
+		{Kernel: "c*Matern52(l)", NDim: 1, ThetaSimil: []float64{1, 1},
+			ThetaNoise: []float64{1}},
+		{Kernel: "c*Matern52(l)", NDim: 2, ThetaSimil: []float64{1, 1}},
+		{Kernel: "c*Matern52(l)", NDim: 1, ThetaSimil: []float64{1, 1},
+			X: x, Y: y[1:]},
+	} {
+		if _, err := f.GP(); err == nil {
+			t.Errorf("%+v: no error", f)
+		}
+	}
+}
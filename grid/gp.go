@@ -0,0 +1,622 @@
+// Package grid implements Gaussian process regression on inputs
+// forming a full grid, such as stations × hourly time. The kernel
+// is the product of kernels over the axes of the grid, hence the
+// covariance matrix is the Kronecker product of the covariance
+// matrices of the axes:
+//   K = K_1 ⊗ K_2 ⊗ ... ⊗ K_D,
+// and inference is exact through the eigendecompositions of the
+// axes' matrices (Saatçi, 2012), in O(∑ n_d^3 + N ∑ n_d) for N
+// cells rather than O(N^3). When some of the cells are missing,
+// systems are solved iteratively, by gp.Iterative by default,
+// with products by K still computed in the Kronecker form.
+package grid
+
+import (
+	gogp "bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// Type GP is the grid implementation of GP. The outputs are
+// in row-major order of the cells, the last axis varies fastest.
+type GP struct {
+	// Configuration
+	Kernels []gogp.Kernel // kernels of the axes, over one-dimensional inputs
+	Noise   gogp.Kernel   // noise kernel, homoscedastic
+	Solver  gogp.Solver   // solver for missing cells, gp.Iterative by default
+
+	// Data
+	Thetas     [][]float64 // parameters of the axes' kernels
+	ThetaNoise []float64   // noise kernel parameters
+	Axes       [][]float64 // coordinates along the axes
+	Y          []float64   // outputs, ignored in missing cells
+	Missing    []bool      // missing cells, nil when all cells are observed
+
+	// Derivatives of hyperparameter transforms, for the chain rule
+	dThetas     [][]float64
+	dThetaNoise []float64
+
+	// Cached computations
+	k, q   []*mat.Dense // covariance matrices and their eigenvectors
+	dk     [][]*mat.Dense
+	lambda [][]float64 // eigenvalues
+	noise  float64     // noise variance
+	dnoise []float64
+	alpha  []float64 // K^-1 y, zero in missing cells
+	lml    float64   // log marginal likelihood
+	grad   []float64 // gradient of the log marginal likelihood
+}
+
+func (gp *GP) defaults() {
+	gp.Noise = gogp.DefaultNoise(gp.Noise)
+	if len(gp.Thetas) != len(gp.Kernels) {
+		gp.Thetas = make([][]float64, len(gp.Kernels))
+	}
+	for d, k := range gp.Kernels {
+		gp.Thetas[d] = gogp.DefaultTheta(k, gp.Thetas[d])
+	}
+	gp.ThetaNoise = gogp.DefaultTheta(gp.Noise, gp.ThetaNoise)
+
+	if gp.Solver == nil {
+		gp.Solver = &gogp.Iterative{}
+	}
+}
+
+// Names returns the names of the hyperparameters, in the order
+// of the arguments of Observe, the names of the parameters of
+// each axis' kernel prefixed by the axis.
+func (gp *GP) Names() []string {
+	gp.defaults()
+	var all []string
+	for d, k := range gp.Kernels {
+		all = append(all,
+			kernel.Prefix(fmt.Sprintf("axis%d", d), gogp.KernelNames(k))...)
+	}
+	return append(all, kernel.Prefix("noise", gogp.KernelNames(gp.Noise))...)
+}
+
+// Transforms returns the transforms of the hyperparameters,
+// in the order of the arguments of Observe.
+func (gp *GP) Transforms() []kernel.Transform {
+	gp.defaults()
+	var all []kernel.Transform
+	for _, k := range gp.Kernels {
+		all = append(all, gogp.KernelTransforms(k)...)
+	}
+	return append(all, gogp.KernelTransforms(gp.Noise)...)
+}
+
+// Absorb absorbs observations into the process.
+func (gp *GP) Absorb(axes [][]float64, y []float64, missing []bool) error {
+	// Set the defaults
+	gp.defaults()
+	// Remember the inputs
+	gp.Axes, gp.Y, gp.Missing = axes, y, missing
+	// When Absorb is called directly, the gradient is not computed
+	return gp.absorb(withoutGradient)
+}
+
+// LML returns the log marginal likelihood of the kernel given
+// the absorbed observations; the estimate of the solver when
+// cells are missing.
+func (gp *GP) LML() float64 {
+	return gp.lml
+}
+
+const (
+	withoutGradient = false
+	withGradient    = true
+)
+
+// cells returns the number of cells in the grid.
+func (gp *GP) cells() int {
+	n := 1
+	for _, a := range gp.Axes {
+		n *= len(a)
+	}
+	return n
+}
+
+// observed returns the number of observed cells.
+func (gp *GP) observed() int {
+	n := gp.cells()
+	for _, m := range gp.Missing {
+		if m {
+			n--
+		}
+	}
+	return n
+}
+
+// column returns the coordinates as one-dimensional inputs.
+func column(a []float64) [][]float64 {
+	x := make([][]float64, len(a))
+	for i := range a {
+		x[i] = a[i : i+1]
+	}
+	return x
+}
+
+// absorb computes the covariance matrices of the axes and their
+// eigendecompositions, and then the log marginal likelihood and,
+// optionally, its gradient.
+func (gp *GP) absorb(withGrad bool) error {
+	if len(gp.Kernels) != len(gp.Axes) {
+		return fmt.Errorf("%d kernels for %d axes",
+			len(gp.Kernels), len(gp.Axes))
+	}
+	n := gp.cells()
+	if len(gp.Y) != n {
+		return fmt.Errorf("%d outputs for %d cells", len(gp.Y), n)
+	}
+	if gp.Missing != nil && len(gp.Missing) != n {
+		return fmt.Errorf("%d missing flags for %d cells",
+			len(gp.Missing), n)
+	}
+
+	ntheta := 0
+	for _, k := range gp.Kernels {
+		ntheta += k.NTheta()
+	}
+	ntheta += gp.Noise.NTheta()
+	gp.lml = 0
+	gp.grad = make([]float64, ntheta)
+
+	// Axes
+	D := len(gp.Axes)
+	gp.k = make([]*mat.Dense, D)
+	gp.q = make([]*mat.Dense, D)
+	gp.dk = make([][]*mat.Dense, D)
+	gp.lambda = make([][]float64, D)
+	for d, a := range gp.Axes {
+		x := column(a)
+		k, dk := gogp.Covariances(gp.Kernels[d], gp.Thetas[d], x, x, withGrad)
+		gp.k[d] = mat.NewDense(len(a), len(a), k)
+		for p := range dk {
+			for ij := range dk[p] {
+				dk[p][ij] *= gp.dThetas[d][p]
+			}
+			gp.dk[d] = append(gp.dk[d], mat.NewDense(len(a), len(a), dk[p]))
+		}
+		var eig mat.EigenSym
+		if !eig.Factorize(mat.NewSymDense(len(a), k), true) {
+			return fmt.Errorf("axis %d: eigendecomposition failed", d)
+		}
+		gp.lambda[d] = eig.Values(nil)
+		for i := range gp.lambda[d] {
+			// Rounding errors may make eigenvalues negative
+			gp.lambda[d][i] = math.Max(gp.lambda[d][i], 0)
+		}
+		gp.q[d] = &mat.Dense{}
+		eig.VectorsTo(gp.q[d])
+	}
+
+	// Noise, at the first cell
+	nargs := make([]float64, gp.Noise.NTheta()+D)
+	copy(nargs, gp.ThetaNoise)
+	for d, a := range gp.Axes {
+		nargs[gp.Noise.NTheta()+d] = a[0]
+	}
+	var ngrad []float64
+	gp.noise, ngrad = gogp.Call(gp.Noise, nargs, withGrad)
+	gp.dnoise = nil
+	if withGrad {
+		gp.dnoise = make([]float64, gp.Noise.NTheta())
+		for p := range gp.dnoise {
+			gp.dnoise[p] = ngrad[p] * gp.dThetaNoise[p]
+		}
+	}
+
+	if gp.Missing == nil {
+		gp.absorbFull(withGrad)
+		return nil
+	}
+	return gp.absorbMissing(withGrad)
+}
+
+// eigenvalues returns the eigenvalues of K, the Kronecker product
+// of the eigenvalues of the axes, plus the noise.
+func (gp *GP) eigenvalues() []float64 {
+	return kronVec(gp.lambda, gp.noise)
+}
+
+// absorbFull computes the log marginal likelihood and its gradient
+// exactly when all cells are observed:
+//   K = Q (Λ + σ^2 I) Q^⊤, α = Q (Λ + σ^2 I)^-1 Q^⊤ y
+//   L = −½ ∑ log(λ_i + σ^2) − ½ y^⊤ α − n/2 log(2π)
+func (gp *GP) absorbFull(withGrad bool) {
+	n := len(gp.Y)
+	lambda := gp.eigenvalues()
+	qt := make([]mat.Matrix, len(gp.q))
+	q := make([]mat.Matrix, len(gp.q))
+	for d := range gp.q {
+		qt[d] = gp.q[d].T()
+		q[d] = gp.q[d]
+	}
+	atilde := kronMulVec(qt, gp.Y)
+	gp.lml = -0.5 * float64(n) * math.Log(2*math.Pi)
+	for i := range atilde {
+		gp.lml -= 0.5 * math.Log(lambda[i])
+		gp.lml -= 0.5 * atilde[i] * atilde[i] / lambda[i]
+		atilde[i] /= lambda[i]
+	}
+	gp.alpha = kronMulVec(q, atilde)
+
+	if !withGrad {
+		return
+	}
+
+	// ∂L/∂θ = ½ α^⊤ ∂K α − ½ tr(K^-1 ∂K); for a parameter of
+	// axis d, ∂K = K_1 ⊗ ... ⊗ ∂K_d ⊗ ... ⊗ K_D, and the diagonal
+	// of Q^⊤ ∂K Q is the Kronecker product of the eigenvalues of
+	// the other axes and of the diagonal of Q_d^⊤ ∂K_d Q_d.
+	ipar := 0
+	for d := range gp.Axes {
+		for _, dkd := range gp.dk[d] {
+			ms := make([]mat.Matrix, len(gp.k))
+			diags := make([][]float64, len(gp.k))
+			for e := range gp.k {
+				ms[e] = gp.k[e]
+				diags[e] = gp.lambda[e]
+			}
+			ms[d] = dkd
+			var qdq mat.Dense
+			qdq.Product(gp.q[d].T(), dkd, gp.q[d])
+			diags[d] = make([]float64, len(gp.Axes[d]))
+			for i := range diags[d] {
+				diags[d][i] = qdq.At(i, i)
+			}
+			gp.grad[ipar] = 0.5 * dot(gp.alpha, kronMulVec(ms, gp.alpha))
+			for i, di := range kronVec(diags, 0) {
+				gp.grad[ipar] -= 0.5 * di / lambda[i]
+			}
+			ipar++
+		}
+	}
+	// For the noise, ∂K = ∂σ^2 I
+	tr := 0.
+	for i := range lambda {
+		tr += 1 / lambda[i]
+	}
+	for _, dn := range gp.dnoise {
+		gp.grad[ipar] = 0.5 * dn * (dot(gp.alpha, gp.alpha) - tr)
+		ipar++
+	}
+}
+
+// Type covariance is the covariance matrix of the observed
+// cells as an operator.
+type covariance struct {
+	gp *GP
+	ks []mat.Matrix // matrices of the Kronecker product
+	// noise variance, or zero for the derivatives by
+	// the axes' parameters
+	noise float64
+}
+
+func (c covariance) Dim() int {
+	return c.gp.observed()
+}
+
+func (c covariance) MulTo(dst, v [][]float64) {
+	for i := range v {
+		full := c.gp.scatter(v[i])
+		kv := kronMulVec(c.ks, full)
+		c.gp.gather(dst[i], kv)
+		for j := range dst[i] {
+			dst[i][j] += c.noise * v[i][j]
+		}
+	}
+}
+
+func (c covariance) Diag(dst []float64) {
+	diags := make([][]float64, len(c.ks))
+	for d, k := range c.ks {
+		diags[d] = make([]float64, len(c.gp.Axes[d]))
+		for i := range diags[d] {
+			diags[d][i] = k.At(i, i)
+		}
+	}
+	c.gp.gather(dst, kronVec(diags, c.noise))
+}
+
+func (c covariance) Column(j int, dst []float64) {
+	// The cell of the j-th observation
+	cell := c.gp.cellOf(j)
+	idx := c.gp.index(cell)
+	cols := make([][]float64, len(c.ks))
+	for d, k := range c.ks {
+		cols[d] = mat.Col(nil, idx[d], k)
+	}
+	c.gp.gather(dst, kronVec(cols, 0))
+	dst[j] += c.noise
+}
+
+// index returns the indices along the axes of a cell.
+func (gp *GP) index(cell int) []int {
+	idx := make([]int, len(gp.Axes))
+	for d := len(gp.Axes) - 1; d >= 0; d-- {
+		idx[d] = cell % len(gp.Axes[d])
+		cell /= len(gp.Axes[d])
+	}
+	return idx
+}
+
+// cellOf returns the cell of the j-th observed cell.
+func (gp *GP) cellOf(j int) int {
+	for cell := range gp.Y {
+		if gp.Missing == nil || !gp.Missing[cell] {
+			if j == 0 {
+				return cell
+			}
+			j--
+		}
+	}
+	panic("cellOf")
+}
+
+// scatter places the values of observed cells into the grid,
+// with zeros in missing cells.
+func (gp *GP) scatter(v []float64) []float64 {
+	full := make([]float64, len(gp.Y))
+	j := 0
+	for cell := range full {
+		if gp.Missing == nil || !gp.Missing[cell] {
+			full[cell] = v[j]
+			j++
+		}
+	}
+	return full
+}
+
+// gather collects the values of observed cells from the grid.
+func (gp *GP) gather(dst, full []float64) {
+	j := 0
+	for cell := range full {
+		if gp.Missing == nil || !gp.Missing[cell] {
+			dst[j] = full[cell]
+			j++
+		}
+	}
+}
+
+// absorbMissing computes the log marginal likelihood and its
+// gradient through the solver when some cells are missing; the
+// trace terms of the gradient are estimated from the probes.
+func (gp *GP) absorbMissing(withGrad bool) error {
+	ks := make([]mat.Matrix, len(gp.k))
+	for d := range gp.k {
+		ks[d] = gp.k[d]
+	}
+	K := covariance{gp: gp, ks: ks, noise: gp.noise}
+	if err := gp.Solver.Factorize(K); err != nil {
+		return err
+	}
+	n := gp.observed()
+	y := make([]float64, n)
+	gp.gather(y, gp.Y)
+	alpha := make([]float64, n)
+	if err := gp.Solver.SolveTo(
+		[][]float64{alpha}, [][]float64{y}); err != nil {
+		return err
+	}
+	gp.alpha = gp.scatter(alpha)
+	gp.lml = -0.5*float64(n)*math.Log(2*math.Pi) -
+		0.5*gp.Solver.LogDet() - 0.5*dot(y, alpha)
+
+	if !withGrad {
+		return nil
+	}
+
+	// ∂L/∂θ = ½ α^⊤ ∂K α − ½ tr(K^-1 ∂K),
+	//   tr(K^-1 ∂K) ≈ 1/m ∑_i u_i^⊤ ∂K w_i
+	u, w := gp.Solver.Probes()
+	dKdot := func(dK covariance, a, b [][]float64) float64 {
+		s := 0.
+		dKb := make([][]float64, len(b))
+		for i := range dKb {
+			dKb[i] = make([]float64, n)
+		}
+		dK.MulTo(dKb, b)
+		for i := range a {
+			s += dot(a[i], dKb[i])
+		}
+		return s
+	}
+	ipar := 0
+	for d := range gp.Axes {
+		for _, dkd := range gp.dk[d] {
+			dK := covariance{gp: gp, ks: append([]mat.Matrix{}, ks...)}
+			dK.ks[d] = dkd
+			gp.grad[ipar] = 0.5*dKdot(dK, [][]float64{alpha}, [][]float64{alpha}) -
+				0.5*dKdot(dK, u, w)/float64(len(u))
+			ipar++
+		}
+	}
+	tr := 0.
+	for i := range u {
+		tr += dot(u[i], w[i])
+	}
+	tr /= float64(len(u))
+	for _, dn := range gp.dnoise {
+		gp.grad[ipar] = 0.5 * dn * (dot(alpha, alpha) - tr)
+		ipar++
+	}
+	return nil
+}
+
+// Produce computes predictions at inputs x, each with a
+// coordinate for every axis.
+func (gp *GP) Produce(x [][]float64) (
+	mu, sigma []float64,
+	err error,
+) {
+	gp.defaults()
+	mu = make([]float64, len(x))
+	sigma = make([]float64, len(x))
+
+	// The covariances of a point with the cells are the Kronecker
+	// product of the covariances with the coordinates of the axes.
+	kstar := make([][][]float64, len(x))
+	for i := range x {
+		if len(x[i]) != len(gp.Axes) {
+			return nil, nil, fmt.Errorf("%d coordinates for %d axes",
+				len(x[i]), len(gp.Axes))
+		}
+		kstar[i] = make([][]float64, len(gp.Axes))
+		variance := 1.
+		for d, a := range gp.Axes {
+			xd := [][]float64{x[i][d : d+1]}
+			kstar[i][d], _ = gogp.Covariances(
+				gp.Kernels[d], gp.Thetas[d], xd, column(a), withoutGradient)
+			kdd, _ := gogp.Covariances(
+				gp.Kernels[d], gp.Thetas[d], xd, xd, withoutGradient)
+			variance *= kdd[0]
+		}
+		sigma[i] = variance
+	}
+
+	if len(gp.alpha) == 0 {
+		// No observations
+		for i := range sigma {
+			sigma[i] = math.Sqrt(sigma[i])
+		}
+		return mu, sigma, nil
+	}
+
+	for i := range x {
+		mu[i] = dot(kronVec(kstar[i], 0), gp.alpha)
+	}
+
+	if gp.Missing == nil {
+		// k_*^⊤ K^-1 k_* = ∑ [Q^⊤ k_*]_i^2/(λ_i + σ^2), where Q^⊤ k_*
+		// is the Kronecker product of Q_d^⊤ k_*d.
+		lambda := gp.eigenvalues()
+		for i := range x {
+			qk := make([][]float64, len(gp.Axes))
+			for d := range gp.Axes {
+				v := mat.NewVecDense(len(gp.Axes[d]), nil)
+				v.MulVec(gp.q[d].T(),
+					mat.NewVecDense(len(kstar[i][d]), kstar[i][d]))
+				qk[d] = v.RawVector().Data
+			}
+			for j, qkj := range kronVec(qk, 0) {
+				sigma[i] -= qkj * qkj / lambda[j]
+			}
+		}
+	} else {
+		n := gp.observed()
+		ks := make([][]float64, len(x))
+		v := make([][]float64, len(x))
+		for i := range x {
+			ks[i] = make([]float64, n)
+			gp.gather(ks[i], kronVec(kstar[i], 0))
+			v[i] = make([]float64, n)
+		}
+		if err := gp.Solver.SolveTo(v, ks); err != nil {
+			return nil, nil, err
+		}
+		for i := range x {
+			sigma[i] -= dot(ks[i], v[i])
+		}
+	}
+	for i := range sigma {
+		sigma[i] = math.Sqrt(math.Max(sigma[i], 0))
+	}
+	return mu, sigma, nil
+}
+
+// Observe and Gradient implement Infergo's ElementalModel.
+
+// Observe computes log marginal likelihood of the parameters
+// given the observations. The argument is the concatenation of
+// transformed hyperparameters of the axes' kernels and of the
+// noise kernel; the grid and the outputs must be assigned to
+// fields Axes, Y, and Missing of gp.
+func (gp *GP) Observe(x []float64) float64 {
+	gp.defaults()
+	if len(x) != len(gp.Names()) {
+		panic("len(x)")
+	}
+
+	// Restore parameters from the unconstrained space,
+	// remembering derivatives of the transforms
+	if len(gp.dThetas) != len(gp.Kernels) {
+		gp.dThetas = make([][]float64, len(gp.Kernels))
+	}
+	for d, k := range gp.Kernels {
+		gp.dThetas[d] = gogp.Restore(gogp.KernelTransforms(k),
+			gp.Thetas[d], gp.dThetas[d], model.Shift(&x, k.NTheta()))
+	}
+	gp.dThetaNoise = gogp.Restore(gogp.KernelTransforms(gp.Noise),
+		gp.ThetaNoise, gp.dThetaNoise, model.Shift(&x, gp.Noise.NTheta()))
+
+	if err := gp.absorb(withGradient); err != nil {
+		panic(err)
+	}
+
+	return gp.lml
+}
+
+// Gradient returns the gradient of the log marginal likelihood
+// computed by Observe.
+func (gp *GP) Gradient() []float64 {
+	return gp.grad
+}
+
+// kronMulVec computes the product of the Kronecker product of
+// square matrices ms by vector v, one axis at a time.
+func kronMulVec(ms []mat.Matrix, v []float64) []float64 {
+	x := append([]float64{}, v...)
+	y := make([]float64, len(v))
+	post := len(v)
+	for _, m := range ms {
+		n, _ := m.Dims()
+		post /= n
+		// x is indexed by (pre, j, post), y by (pre, i, post)
+		for pre := 0; pre != len(v)/(n*post); pre++ {
+			base := pre * n * post
+			for i := 0; i != n; i++ {
+				for r := 0; r != post; r++ {
+					s := 0.
+					for j := 0; j != n; j++ {
+						s += m.At(i, j) * x[base+j*post+r]
+					}
+					y[base+i*post+r] = s
+				}
+			}
+		}
+		x, y = y, x
+	}
+	return x
+}
+
+// kronVec computes the Kronecker product of vectors vs plus c.
+func kronVec(vs [][]float64, c float64) []float64 {
+	p := []float64{1}
+	for _, v := range vs {
+		q := make([]float64, 0, len(p)*len(v))
+		for _, pi := range p {
+			for _, vj := range v {
+				q = append(q, pi*vj)
+			}
+		}
+		p = q
+	}
+	for i := range p {
+		p[i] += c
+	}
+	return p
+}
+
+// dot computes the dot product of vectors a and b.
+func dot(a, b []float64) float64 {
+	s := 0.
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
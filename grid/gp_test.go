@@ -0,0 +1,230 @@
+package grid
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"math"
+	"sync"
+	"testing"
+)
+
+// Type product is the dense counterpart of the grid kernel, the
+// product of the normal and the Matern(nu=3/2) kernels over the
+// two coordinates.
+type product struct{}
+
+func (product) Observe(x []float64) float64 {
+	l0, l1 := x[0], x[1]
+	d0 := (x[2] - x[4]) / l0
+	d1 := math.Sqrt(3) * math.Abs(x[3]-x[5]) / l1
+	return math.Exp(-d0*d0/2) * (1 + d1) * math.Exp(-d1)
+}
+
+func (product) Gradient() []float64 { return nil }
+
+func (product) NTheta() int { return 2 }
+
+// Type elemental hides the Batch interface of the kernel.
+type elemental struct {
+	gp.Kernel
+}
+
+// Difference and precision for numerical derivative
+const (
+	dx  = 1e-6
+	eps = 1e-4
+)
+
+func TestGP(t *testing.T) {
+	axes := [][]float64{{0, 0.5, 1.5}, {0.1, 0.4, 0.5, 1.3}}
+	y := []float64{
+		0.5, 1, 0.8, -0.2,
+		0.1, -1, -0.7, 0.3,
+		0.2, 0.6, -0.4, -0.1,
+	}
+	z := [][]float64{{0.2, 0.3}, {1.5, 1.3}, {3, -1}}
+	theta := []float64{math.Log(0.8), math.Log(0.6), math.Log(0.1)}
+	for _, c := range []struct {
+		name    string
+		kernels []gp.Kernel
+		missing []bool
+		solver  gp.Solver
+		eps     float64 // tolerance of LML and gradient
+	}{
+		{
+			name:    "full",
+			kernels: []gp.Kernel{kernel.Normal, kernel.Matern32},
+			eps:     1e-6,
+		},
+		{
+			name: "elemental",
+			kernels: []gp.Kernel{
+				elemental{kernel.Normal},
+				elemental{kernel.Matern32},
+			},
+			eps: 1e-6,
+		},
+		{
+			name:    "missing",
+			kernels: []gp.Kernel{kernel.Normal, kernel.Matern32},
+			missing: []bool{
+				false, true, false, false,
+				false, false, false, false,
+				false, false, false, true,
+			},
+			solver: &gp.Iterative{NProbes: 1000, Rank: 12},
+			eps:    0.05,
+		},
+	} {
+		// The dense process on the observed cells
+		var X [][]float64
+		var Y []float64
+		for i, a0 := range axes[0] {
+			for j, a1 := range axes[1] {
+				cell := i*len(axes[1]) + j
+				if c.missing == nil || !c.missing[cell] {
+					X = append(X, []float64{a0, a1})
+					Y = append(Y, y[cell])
+				}
+			}
+		}
+		dense := &gp.GP{
+			NDim:  2,
+			Simil: product{},
+			Noise: kernel.UniformNoise,
+			X:     X,
+			Y:     Y,
+		}
+		denseLML := func(theta []float64) float64 {
+			dense.ThetaSimil = []float64{math.Exp(theta[0]), math.Exp(theta[1])}
+			dense.ThetaNoise = []float64{math.Exp(theta[2])}
+			if err := dense.Absorb(X, Y); err != nil {
+				t.Fatalf("%s: dense absorb: %v", c.name, err)
+			}
+			return dense.LML()
+		}
+
+		g := &GP{
+			Kernels: c.kernels,
+			Noise:   kernel.UniformNoise,
+			Solver:  c.solver,
+			Axes:    axes,
+			Y:       y,
+			Missing: c.missing,
+		}
+		lml := g.Observe(theta)
+		wlml := denseLML(theta)
+		if math.Abs(lml-wlml) > c.eps*math.Max(1, math.Abs(wlml)) {
+			t.Errorf("%s: wrong LML: got %f, want %f", c.name, lml, wlml)
+		}
+
+		mu, sigma, err := g.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", c.name, err)
+		}
+		wmu, wsigma, err := dense.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: dense produce: %v", c.name, err)
+		}
+		for i := range z {
+			if math.Abs(mu[i]-wmu[i]) > 1e-6 {
+				t.Errorf("%s: wrong mu: got %v, want %v", c.name, mu, wmu)
+				break
+			}
+			if math.Abs(sigma[i]-wsigma[i]) > 1e-6 {
+				t.Errorf("%s: wrong sigma: got %v, want %v",
+					c.name, sigma, wsigma)
+				break
+			}
+		}
+
+		grad := g.Gradient()
+		for i := range theta {
+			theta0 := theta[i]
+			theta[i] += dx
+			dldx := (denseLML(theta) - wlml) / dx
+			theta[i] = theta0
+			if math.Abs(grad[i]-dldx) > math.Max(eps, c.eps*math.Abs(dldx)) {
+				t.Errorf("%s: wrong gradient: got dl/dx%d=%f, want %f",
+					c.name, i, grad[i], dldx)
+			}
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	g := &GP{
+		Kernels: []gp.Kernel{kernel.Normal, kernel.Periodic},
+		Noise:   kernel.UniformNoise,
+	}
+	want := []string{"axis0.l", "axis1.l", "axis1.p", "noise.s"}
+	got := g.Names()
+	if len(got) != len(want) {
+		t.Fatalf("wrong names: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wrong names: got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	// The grid GPs, with kernels differentiated through the
+	// tape, and the dense ones share the tape.
+	axes := [][]float64{{0, 0.5, 1.5}, {0.1, 0.4, 0.5, 1.3}}
+	y := []float64{
+		0.5, 1, 0.8, -0.2,
+		0.1, -1, -0.7, 0.3,
+		0.2, 0.6, -0.4, -0.1,
+	}
+	var X [][]float64
+	for _, a0 := range axes[0] {
+		for _, a1 := range axes[1] {
+			X = append(X, []float64{a0, a1})
+		}
+	}
+	theta := []float64{math.Log(0.8), math.Log(0.6), math.Log(0.1)}
+	lml := func(dense bool) float64 {
+		if dense {
+			g := &gp.GP{
+				NDim:       2,
+				Simil:      product{},
+				Noise:      kernel.UniformNoise,
+				ThetaSimil: []float64{0.8, 0.6},
+				ThetaNoise: []float64{0.1},
+			}
+			if err := g.Absorb(X, y); err != nil {
+				t.Error(err)
+			}
+			return g.LML()
+		}
+		g := &GP{
+			Kernels: []gp.Kernel{
+				elemental{kernel.Normal},
+				elemental{kernel.Matern32},
+			},
+			Noise: kernel.UniformNoise,
+			Axes:  axes,
+			Y:     y,
+		}
+		return g.Observe(theta)
+	}
+	want := lml(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i != 6; i++ {
+		wg.Add(1)
+		go func(dense bool) {
+			defer wg.Done()
+			for j := 0; j != 5; j++ {
+				if got := lml(dense); math.Abs(got-want) > 1e-6 {
+					t.Errorf("dense=%v: wrong LML: got %f, want %f",
+						dense, got, want)
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
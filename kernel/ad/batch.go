@@ -0,0 +1,210 @@
+package kernel
+
+import (
+	"math"
+)
+
+func covariances(
+	ntheta int,
+	xa, xb [][]float64,
+	withGrad bool,
+	cov func(r float64, dk []float64) float64,
+) (k []float64, dk [][]float64) {
+	k, dk, dkij := allocate(ntheta, len(xa)*len(xb), withGrad)
+	for i := range xa {
+		for j := range xb {
+			ij := i*len(xb) + j
+			k[ij] = cov(xa[i][0]-xb[j][0], dkij)
+			for p := range dkij {
+				dk[p][ij] = dkij[p]
+			}
+		}
+	}
+	return k, dk
+}
+
+func diffCovariances(
+	ntheta int,
+	r []float64,
+	withGrad bool,
+	cov func(r float64, dk []float64) float64,
+) (k []float64, dk [][]float64) {
+	k, dk, dkij := allocate(ntheta, len(r), withGrad)
+	for ij := range r {
+		k[ij] = cov(r[ij], dkij)
+		for p := range dkij {
+			dk[p][ij] = dkij[p]
+		}
+	}
+	return k, dk
+}
+
+func diffGradient(
+	ntheta int,
+	r float64,
+	cov func(r float64, dk []float64) float64,
+) (k float64, dk []float64) {
+	dk = make([]float64, ntheta+1)
+	return cov(r, dk), dk
+}
+
+func allocate(ntheta, n int, withGrad bool) (
+	k []float64,
+	dk [][]float64,
+	dkij []float64,
+) {
+	k = make([]float64, n)
+	if withGrad {
+		dk = make([][]float64, ntheta)
+		for p := range dk {
+			dk[p] = make([]float64, n)
+		}
+		dkij = make([]float64, ntheta)
+	}
+	return k, dk, dkij
+}
+
+func (k normal) Covariances(
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return covariances(k.NTheta(), xa, xb, withGrad, normalCov(theta))
+}
+
+func (k normal) DiffCovariances(
+	theta []float64,
+	r [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return diffCovariances(k.NTheta(), r[0], withGrad, normalCov(theta))
+}
+
+func (k normal) DiffGradient(theta []float64, r float64) (float64, []float64) {
+	return diffGradient(k.NTheta(), r, normalCov(theta))
+}
+
+func normalCov(theta []float64) func(r float64, dk []float64) float64 {
+	l := theta[0]
+	return func(r float64, dk []float64) float64 {
+		d := r / l
+		k := math.Exp(-d * d / 2)
+		if dk != nil {
+			dk[0] = k * d * d / l
+		}
+		if len(dk) > 1 {
+			dk[1] = -k * d / l
+		}
+		return k
+	}
+}
+
+func (k periodic) Covariances(
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return covariances(k.NTheta(), xa, xb, withGrad, periodicCov(theta))
+}
+
+func (k periodic) DiffCovariances(
+	theta []float64,
+	r [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return diffCovariances(k.NTheta(), r[0], withGrad, periodicCov(theta))
+}
+
+func (k periodic) DiffGradient(theta []float64, r float64) (float64, []float64) {
+	return diffGradient(k.NTheta(), r, periodicCov(theta))
+}
+
+func periodicCov(theta []float64) func(r float64, dk []float64) float64 {
+	l, p := theta[0], theta[1]
+	return func(r float64, dk []float64) float64 {
+		sign := math.Copysign(1, r)
+		r = math.Pi * math.Abs(r) / p
+		d := math.Sin(r) / l
+		k := math.Exp(-2 * d * d)
+		if dk != nil {
+			dk[0] = 4 * k * d * d / l
+			dk[1] = 4 * k * d * math.Cos(r) * r / (p * l)
+		}
+		if len(dk) > 2 {
+			dk[2] = -4 * k * d * math.Cos(r) * math.Pi / (p * l) * sign
+		}
+		return k
+	}
+}
+
+func (k matern32) Covariances(
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return covariances(k.NTheta(), xa, xb, withGrad, matern32Cov(theta))
+}
+
+func (k matern32) DiffCovariances(
+	theta []float64,
+	r [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return diffCovariances(k.NTheta(), r[0], withGrad, matern32Cov(theta))
+}
+
+func (k matern32) DiffGradient(theta []float64, r float64) (float64, []float64) {
+	return diffGradient(k.NTheta(), r, matern32Cov(theta))
+}
+
+func matern32Cov(theta []float64) func(r float64, dk []float64) float64 {
+	l := theta[0]
+	return func(r float64, dk []float64) float64 {
+		d := math.Abs(r) / l
+		e := math.Exp(-sqrt3 * d)
+		if dk != nil {
+			dk[0] = 3 * d * d * e / l
+		}
+		if len(dk) > 1 {
+			dk[1] = -3 * d * e / l * math.Copysign(1, r)
+		}
+		return (1 + sqrt3*d) * e
+	}
+}
+
+func (k matern52) Covariances(
+	theta []float64,
+	xa, xb [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return covariances(k.NTheta(), xa, xb, withGrad, matern52Cov(theta))
+}
+
+func (k matern52) DiffCovariances(
+	theta []float64,
+	r [][]float64,
+	withGrad bool,
+) (cov []float64, dcov [][]float64) {
+	return diffCovariances(k.NTheta(), r[0], withGrad, matern52Cov(theta))
+}
+
+func (k matern52) DiffGradient(theta []float64, r float64) (float64, []float64) {
+	return diffGradient(k.NTheta(), r, matern52Cov(theta))
+}
+
+func matern52Cov(theta []float64) func(r float64, dk []float64) float64 {
+	l := theta[0]
+	return func(r float64, dk []float64) float64 {
+		d := math.Abs(r) / l
+		e := math.Exp(-sqrt5 * d)
+
+		k := (1 + sqrt5*d + 5/3*d*d) * e
+		if dk != nil {
+			dk[0] = (sqrt5*k - (sqrt5+2*(5/3)*d)*e) * d / l
+		}
+		if len(dk) > 1 {
+			dk[1] = ((sqrt5+2*(5/3)*d)*e - sqrt5*k) / l * math.Copysign(1, r)
+		}
+		return k
+	}
+}
@@ -5,6 +5,14 @@ import (
 	"bitbucket.org/dtolpin/infergo/ad"
 )
 
+func Prefix(prefix string, names []string) []string {
+	prefixed := make([]string, len(names))
+	for i := range names {
+		prefixed[i] = prefix + "." + names[i]
+	}
+	return prefixed
+}
+
 type normal struct{}
 
 var Normal normal
@@ -24,6 +32,14 @@ func (normal) NTheta() int {
 	return 1
 }
 
+func (normal) Names() []string {
+	return []string{"l"}
+}
+
+func (normal) String() string {
+	return "Normal"
+}
+
 func (normal) Cov(l, xa, xb float64) float64 {
 	if ad.Called() {
 		ad.Enter(&l, &xa, &xb)
@@ -54,6 +70,14 @@ func (periodic) NTheta() int {
 	return 2
 }
 
+func (periodic) Names() []string {
+	return []string{"l", "p"}
+}
+
+func (periodic) String() string {
+	return "Periodic"
+}
+
 func (periodic) Cov(l, p, xa, xb float64) float64 {
 	if ad.Called() {
 		ad.Enter(&l, &p, &xa, &xb)
@@ -89,6 +113,14 @@ func (matern32) NTheta() int {
 	return 1
 }
 
+func (matern32) Names() []string {
+	return []string{"l"}
+}
+
+func (matern32) String() string {
+	return "Matern32"
+}
+
 func (matern32) Cov(l, xa, xb float64) float64 {
 	if ad.Called() {
 		ad.Enter(&l, &xa, &xb)
@@ -119,6 +151,14 @@ func (matern52) NTheta() int {
 	return 1
 }
 
+func (matern52) Names() []string {
+	return []string{"l"}
+}
+
+func (matern52) String() string {
+	return "Matern52"
+}
+
 func (matern52) Cov(l, xa, xb float64) float64 {
 	if ad.Called() {
 		ad.Enter(&l, &xa, &xb)
@@ -1,6 +1,9 @@
 package kernel
 
-import "bitbucket.org/dtolpin/infergo/ad"
+import (
+	"fmt"
+	"bitbucket.org/dtolpin/infergo/ad"
+)
 
 type ConstantNoise float64
 
@@ -30,6 +33,14 @@ func (ConstantNoise) NTheta() int {
 	return 0
 }
 
+func (ConstantNoise) Names() []string {
+	return []string{}
+}
+
+func (nk ConstantNoise) String() string {
+	return fmt.Sprintf("ConstantNoise(%g)", float64(nk))
+}
+
 type uniformNoise struct{}
 
 var UniformNoise uniformNoise
@@ -57,3 +68,11 @@ func (nk uniformNoise) Var(std float64) float64 {
 func (uniformNoise) NTheta() int {
 	return 1
 }
+
+func (uniformNoise) Names() []string {
+	return []string{"s"}
+}
+
+func (uniformNoise) String() string {
+	return "UniformNoise"
+}
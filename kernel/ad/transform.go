@@ -0,0 +1,83 @@
+package kernel
+
+import (
+	"math"
+)
+
+type Transform interface {
+	Forward(x float64) float64
+
+	Backward(y float64) float64
+
+	Deriv(x float64) float64
+}
+
+type logT struct{}
+
+var Log logT
+
+func (logT) Forward(x float64) float64 {
+	return math.Exp(x)
+}
+
+func (logT) Backward(y float64) float64 {
+	return math.Log(y)
+}
+
+func (logT) Deriv(x float64) float64 {
+	return math.Exp(x)
+}
+
+type identityT struct{}
+
+var Identity identityT
+
+func (identityT) Forward(x float64) float64 {
+	return x
+}
+
+func (identityT) Backward(y float64) float64 {
+	return y
+}
+
+func (identityT) Deriv(x float64) float64 {
+	return 1
+}
+
+type softplusT struct{}
+
+var Softplus softplusT
+
+func (softplusT) Forward(x float64) float64 {
+	if x > 0 {
+
+		return x + math.Log1p(math.Exp(-x))
+	}
+	return math.Log1p(math.Exp(x))
+}
+
+func (softplusT) Backward(y float64) float64 {
+	return y + math.Log(-math.Expm1(-y))
+}
+
+func (softplusT) Deriv(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+type Logit struct {
+	Lo, Hi float64
+}
+
+func (t Logit) Forward(x float64) float64 {
+	return t.Lo + (t.Hi-t.Lo)/(1+math.Exp(-x))
+}
+
+func (t Logit) Backward(y float64) float64 {
+	p := (y - t.Lo) / (t.Hi - t.Lo)
+	return math.Log(p / (1 - p))
+}
+
+func (t Logit) Deriv(x float64) float64 {
+	s := 1 / (1 + math.Exp(-x))
+	return (t.Hi - t.Lo) * s * (1 - s)
+}
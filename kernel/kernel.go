@@ -5,6 +5,21 @@ import (
 	"math"
 )
 
+// Kernels report the names of their parameters through method
+// Names, and their readable descriptions through method String.
+// A composite kernel prefixes the names of the parameters of
+// its children, see Prefix.
+
+// Prefix prefixes the names of the parameters of a child
+// kernel, for use in composite kernels.
+func Prefix(prefix string, names []string) []string {
+	prefixed := make([]string, len(names))
+	for i := range names {
+		prefixed[i] = prefix + "." + names[i]
+	}
+	return prefixed
+}
+
 // Type normal is the normal kernel type. A normal kernel
 // has a single parameter, the length scale.
 type normal struct{}
@@ -20,6 +35,14 @@ func (normal) NTheta() int {
 	return 1
 }
 
+func (normal) Names() []string {
+	return []string{"l"}
+}
+
+func (normal) String() string {
+	return "Normal"
+}
+
 func (normal) Cov(l, xa, xb float64) float64 {
 	d := (xa - xb) / l
 	return math.Exp(-d * d / 2)
@@ -41,6 +64,14 @@ func (periodic) NTheta() int {
 	return 2
 }
 
+func (periodic) Names() []string {
+	return []string{"l", "p"}
+}
+
+func (periodic) String() string {
+	return "Periodic"
+}
+
 func (periodic) Cov(l, p, xa, xb float64) float64 {
 	d := math.Sin(math.Pi*math.Abs(xa-xb)/p) / l
 	return math.Exp(-2 * d * d)
@@ -67,6 +98,14 @@ func (matern32) NTheta() int {
 	return 1
 }
 
+func (matern32) Names() []string {
+	return []string{"l"}
+}
+
+func (matern32) String() string {
+	return "Matern32"
+}
+
 func (matern32) Cov(l, xa, xb float64) float64 {
 	d := math.Abs(xa-xb) / l
 	return (1 + sqrt3*d) * math.Exp(-sqrt3*d)
@@ -86,6 +125,14 @@ func (matern52) NTheta() int {
 	return 1
 }
 
+func (matern52) Names() []string {
+	return []string{"l"}
+}
+
+func (matern52) String() string {
+	return "Matern52"
+}
+
 func (matern52) Cov(l, xa, xb float64) float64 {
 	d := math.Abs(xa-xb) / l
 	return (1 + sqrt5*d + 5/3*d*d) * math.Exp(-sqrt5*d)
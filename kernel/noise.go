@@ -1,5 +1,9 @@
 package kernel
 
+import (
+	"fmt"
+)
+
 // Noise kernels
 //
 // A noise kernel is used to add noise to diagonal elements
@@ -33,6 +37,14 @@ func (ConstantNoise) NTheta() int {
 	return 0
 }
 
+func (ConstantNoise) Names() []string {
+	return []string{}
+}
+
+func (nk ConstantNoise) String() string {
+	return fmt.Sprintf("ConstantNoise(%g)", float64(nk))
+}
+
 // UniformNoise is a noise kernel for learning the same noise
 // for all points. UniformNoise has a single parameter --- the
 // standard error.
@@ -51,3 +63,11 @@ func (nk uniformNoise) Var(std float64) float64 {
 func (uniformNoise) NTheta() int {
 	return 1
 }
+
+func (uniformNoise) Names() []string {
+	return []string{"s"}
+}
+
+func (uniformNoise) String() string {
+	return "UniformNoise"
+}
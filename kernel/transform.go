@@ -0,0 +1,107 @@
+package kernel
+
+import (
+	"math"
+)
+
+// Hyperparameter transforms
+//
+// Hyperparameters are inferred in an unconstrained space and
+// mapped into the domains of kernel parameters by transforms.
+// A kernel declares the transforms of its parameters by
+// implementing the method Transforms; parameters of kernels
+// which do not declare transforms are log-transformed, that
+// is, assumed to be positive.
+
+// Type Transform is the interface of a hyperparameter
+// transform.
+type Transform interface {
+	// Forward maps an unconstrained value into the domain
+	// of the parameter.
+	Forward(x float64) float64
+	// Backward maps a parameter value into the unconstrained
+	// space; Backward is the inverse of Forward.
+	Backward(y float64) float64
+	// Deriv is the derivative of Forward at x.
+	Deriv(x float64) float64
+}
+
+// Log is the log transform, for positive parameters. The
+// parameter is the exponent of the unconstrained value.
+type logT struct{}
+
+var Log logT
+
+func (logT) Forward(x float64) float64 {
+	return math.Exp(x)
+}
+
+func (logT) Backward(y float64) float64 {
+	return math.Log(y)
+}
+
+func (logT) Deriv(x float64) float64 {
+	return math.Exp(x)
+}
+
+// Identity is the identity transform, for parameters which
+// may take any real value.
+type identityT struct{}
+
+var Identity identityT
+
+func (identityT) Forward(x float64) float64 {
+	return x
+}
+
+func (identityT) Backward(y float64) float64 {
+	return y
+}
+
+func (identityT) Deriv(x float64) float64 {
+	return 1
+}
+
+// Softplus is the softplus transform, for positive parameters.
+// Unlike Log, Softplus grows linearly for large unconstrained
+// values.
+type softplusT struct{}
+
+var Softplus softplusT
+
+func (softplusT) Forward(x float64) float64 {
+	if x > 0 {
+		// avoid overflow for large x
+		return x + math.Log1p(math.Exp(-x))
+	}
+	return math.Log1p(math.Exp(x))
+}
+
+func (softplusT) Backward(y float64) float64 {
+	return y + math.Log(-math.Expm1(-y))
+}
+
+func (softplusT) Deriv(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// Logit is the logit transform, for parameters bounded in
+// the interval (Lo, Hi). Logit{Lo: 0, Hi: 1} bounds a parameter
+// in the unit interval.
+type Logit struct {
+	Lo, Hi float64
+}
+
+func (t Logit) Forward(x float64) float64 {
+	return t.Lo + (t.Hi-t.Lo)/(1+math.Exp(-x))
+}
+
+func (t Logit) Backward(y float64) float64 {
+	p := (y - t.Lo) / (t.Hi - t.Lo)
+	return math.Log(p / (1 - p))
+}
+
+func (t Logit) Deriv(x float64) float64 {
+	s := 1 / (1 + math.Exp(-x))
+	return (t.Hi - t.Lo) * s * (1 - s)
+}
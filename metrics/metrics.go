@@ -0,0 +1,184 @@
+// Package metrics scores probabilistic forecasts with Gaussian
+// predictive distributions, such as those of GP.Produce: the
+// continuous ranked probability score, the negative log
+// predictive density, the standardized log loss, the coverage of
+// central intervals, and the probability integral transform.
+// Scores accumulates the scores of many forecasts into a summary.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CRPS returns the continuous ranked probability score of
+// forecast N(mu, sigma^2) at observation y; lower is better. The
+// score is in the units of y, and is the absolute error for a
+// point forecast.
+func CRPS(y, mu, sigma float64) float64 {
+	if sigma == 0 {
+		return math.Abs(y - mu)
+	}
+	z := (y - mu) / sigma
+	return sigma * (z*(2*cdf(z)-1) + 2*pdf(z) - 1/math.Sqrt(math.Pi))
+}
+
+// NLPD returns the negative log predictive density of forecast
+// N(mu, sigma^2) at observation y.
+func NLPD(y, mu, sigma float64) float64 {
+	z := (y - mu) / sigma
+	return 0.5*z*z + math.Log(sigma) + 0.5*math.Log(2*math.Pi)
+}
+
+// SLL returns the standardized log loss of forecast N(mu,
+// sigma^2) at observation y, the negative log predictive density
+// less that of the trivial forecast N(mean, std^2), where mean
+// and std are the mean and the standard deviation of the
+// training outputs. Negative values are better than trivial;
+// the mean over forecasts is MSLL (Rasmussen & Williams, 2006).
+func SLL(y, mu, sigma, mean, std float64) float64 {
+	return NLPD(y, mu, sigma) - NLPD(y, mean, std)
+}
+
+// Covered reports whether observation y is in the central
+// interval of forecast N(mu, sigma^2) with probability level.
+func Covered(y, mu, sigma, level float64) bool {
+	return math.Abs(y-mu) <= sigma*quantile(0.5+0.5*level)
+}
+
+// PIT returns the probability integral transform of observation
+// y under forecast N(mu, sigma^2), the forecast probability of a
+// value below y. For calibrated forecasts, PIT values are
+// uniform on [0, 1].
+func PIT(y, mu, sigma float64) float64 {
+	return cdf((y - mu) / sigma)
+}
+
+// pdf is the density of the standard normal.
+func pdf(z float64) float64 {
+	return math.Exp(-0.5*z*z) / math.Sqrt(2*math.Pi)
+}
+
+// cdf is the cumulative distribution of the standard normal.
+func cdf(z float64) float64 {
+	return 0.5 * math.Erfc(-z/math.Sqrt2)
+}
+
+// quantile is the quantile function of the standard normal.
+func quantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// Defaults of Scores
+var (
+	Levels = []float64{0.5, 0.8, 0.95} // of central intervals
+	Bins   = 10                        // of the PIT histogram
+)
+
+// Type Scores accumulates the scores of forecasts.
+type Scores struct {
+	levels  []float64
+	n       int
+	crps    float64
+	nlpd    float64
+	nsll    int // forecasts with a trivial forecast
+	sll     float64
+	covered []int
+	pit     []int
+}
+
+// NewScores returns an accumulator of scores, with coverage at
+// the levels and a PIT histogram of bins; nil levels and zero
+// bins stand for Levels and Bins.
+func NewScores(levels []float64, bins int) *Scores {
+	if levels == nil {
+		levels = Levels
+	}
+	if bins <= 0 {
+		bins = Bins
+	}
+	return &Scores{
+		levels:  append([]float64{}, levels...),
+		covered: make([]int, len(levels)),
+		pit:     make([]int, bins),
+	}
+}
+
+// Add adds forecast N(mu, sigma^2) of observation y; mean and
+// std are the mean and the standard deviation of the training
+// outputs, for the standardized log loss, which is not computed
+// unless std is positive. Forecasts which are not finite are
+// ignored.
+func (s *Scores) Add(y, mu, sigma, mean, std float64) {
+	if !finite(y) || !finite(mu) || !finite(sigma) || sigma <= 0 {
+		return
+	}
+	s.n++
+	s.crps += CRPS(y, mu, sigma)
+	s.nlpd += NLPD(y, mu, sigma)
+	if std > 0 && finite(mean) && finite(std) {
+		s.nsll++
+		s.sll += SLL(y, mu, sigma, mean, std)
+	}
+	for i, level := range s.levels {
+		if Covered(y, mu, sigma, level) {
+			s.covered[i]++
+		}
+	}
+	bin := int(PIT(y, mu, sigma) * float64(len(s.pit)))
+	s.pit[min(bin, len(s.pit)-1)]++
+}
+
+func finite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
+
+// Type Summary is the summary of scores, the means over the
+// forecasts.
+type Summary struct {
+	N        int // number of forecasts
+	CRPS     float64
+	NLPD     float64
+	MSLL     float64 // NaN if not computed
+	Levels   []float64
+	Coverage []float64 // fraction, by level
+	PIT      []int     // histogram on [0, 1]
+}
+
+// Summary returns the summary of the scores. The means are NaN
+// if there are no forecasts.
+func (s *Scores) Summary() Summary {
+	n := float64(s.n)
+	sum := Summary{
+		N:      s.n,
+		CRPS:   s.crps / n,
+		NLPD:   s.nlpd / n,
+		MSLL:   s.sll / float64(s.nsll),
+		Levels: append([]float64{}, s.levels...),
+		PIT:    append([]int{}, s.pit...),
+	}
+	for _, c := range s.covered {
+		sum.Coverage = append(sum.Coverage, float64(c)/n)
+	}
+	return sum
+}
+
+// String formats the summary for reading, a score per line.
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "forecasts: %d\n", s.N)
+	fmt.Fprintf(&b, "CRPS: %.6g\n", s.CRPS)
+	fmt.Fprintf(&b, "NLPD: %.6g\n", s.NLPD)
+	fmt.Fprintf(&b, "MSLL: %.6g\n", s.MSLL)
+	for i, level := range s.Levels {
+		fmt.Fprintf(&b, "coverage at %g%%: %.3g\n",
+			100*level, s.Coverage[i])
+	}
+	pit := make([]string, len(s.PIT))
+	for i, c := range s.PIT {
+		pit[i] = fmt.Sprint(c)
+	}
+	fmt.Fprintf(&b, "PIT: %s\n", strings.Join(pit, " "))
+	return b.String()
+}
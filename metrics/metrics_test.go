@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCRPS(t *testing.T) {
+	// The CRPS is the integral of (F(x) - [x >= y])^2 dx.
+	for _, c := range []struct {
+		y, mu, sigma float64
+	}{
+		{0, 0, 1},
+		{1, 0, 1},
+		{-2, 0.5, 0.3},
+		{3, 2, 4},
+	} {
+		// Midpoint rule, on each side of the step at y
+		const n = 100000
+		lo, hi := c.mu-20*c.sigma-math.Abs(c.y), c.mu+20*c.sigma+math.Abs(c.y)
+		want := 0.
+		for _, side := range [][2]float64{{lo, c.y}, {c.y, hi}} {
+			dx := (side[1] - side[0]) / n
+			for i := 0; i != n; i++ {
+				x := side[0] + (float64(i)+0.5)*dx
+				f := cdf((x - c.mu) / c.sigma)
+				if x >= c.y {
+					f--
+				}
+				want += f * f * dx
+			}
+		}
+		if got := CRPS(c.y, c.mu, c.sigma); math.Abs(got-want) > 1e-6 {
+			t.Errorf("wrong CRPS(%v, %v, %v): got %.8g, want %.8g",
+				c.y, c.mu, c.sigma, got, want)
+		}
+	}
+	if got := CRPS(1, 3, 0); got != 2 {
+		t.Errorf("wrong CRPS of a point forecast: got %v, want 2", got)
+	}
+}
+
+func TestScores(t *testing.T) {
+	for _, c := range []struct {
+		name         string
+		y, mu, sigma float64
+		nlpd, pit    float64
+		covered      []bool // at 0.5, 0.95
+	}{
+		{"center", 1, 1, 2, math.Log(2) + 0.5*math.Log(2*math.Pi), 0.5,
+			[]bool{true, true}},
+		{"one sigma", 2, 0, 2, 0.5 + math.Log(2) + 0.5*math.Log(2*math.Pi),
+			0.8413447460685429, []bool{false, true}},
+		{"beyond", -3, 0, 1, 4.5 + 0.5*math.Log(2*math.Pi),
+			0.0013498980316301, []bool{false, false}},
+	} {
+		if got := NLPD(c.y, c.mu, c.sigma); math.Abs(got-c.nlpd) > 1e-12 {
+			t.Errorf("%s: wrong NLPD: got %v, want %v", c.name, got, c.nlpd)
+		}
+		if got := PIT(c.y, c.mu, c.sigma); math.Abs(got-c.pit) > 1e-12 {
+			t.Errorf("%s: wrong PIT: got %v, want %v", c.name, got, c.pit)
+		}
+		for i, level := range []float64{0.5, 0.95} {
+			if Covered(c.y, c.mu, c.sigma, level) != c.covered[i] {
+				t.Errorf("%s: wrong coverage at %v", c.name, level)
+			}
+		}
+	}
+	if got := SLL(1, 1, 1, 0, 1); math.Abs(got+0.5) > 1e-12 {
+		t.Errorf("wrong SLL: got %v, want -0.5", got)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	// Observations at the quantiles of calibrated forecasts
+	// are covered at the levels, and uniform under PIT.
+	const n = 1000
+	s := NewScores([]float64{0.5, 0.9}, 4)
+	for i := 0; i != n; i++ {
+		y := 2 + 3*quantile((float64(i)+0.5)/n)
+		s.Add(y, 2, 3, 2, 3)
+	}
+	s.Add(math.NaN(), 0, 1, 0, 1)
+	s.Add(0, 0, 0, 0, 1)
+	sum := s.Summary()
+	if sum.N != n {
+		t.Errorf("wrong number of forecasts: got %d, want %d", sum.N, n)
+	}
+	if math.Abs(sum.MSLL) > 1e-12 {
+		t.Errorf("wrong MSLL of the trivial forecast: %v", sum.MSLL)
+	}
+	for i, level := range sum.Levels {
+		if math.Abs(sum.Coverage[i]-level) > 1.5/n {
+			t.Errorf("wrong coverage at %v: %v", level, sum.Coverage[i])
+		}
+	}
+	if !reflect.DeepEqual(sum.PIT, []int{n / 4, n / 4, n / 4, n / 4}) {
+		t.Errorf("wrong PIT histogram: %v", sum.PIT)
+	}
+	// Expected CRPS of a calibrated N(mu, sigma^2) forecast is
+	// sigma/sqrt(pi).
+	if want := 3 / math.Sqrt(math.Pi); math.Abs(sum.CRPS-want) > 1e-3 {
+		t.Errorf("wrong CRPS: got %v, want %v", sum.CRPS, want)
+	}
+
+	// Without a trivial forecast, MSLL is not computed.
+	s = NewScores(nil, 0)
+	s.Add(1, 0, 1, 0, 0)
+	sum = s.Summary()
+	if !math.IsNaN(sum.MSLL) || len(sum.Levels) != len(Levels) ||
+		len(sum.PIT) != Bins {
+		t.Errorf("wrong summary: %+v", sum)
+	}
+}
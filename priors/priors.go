@@ -0,0 +1,177 @@
+// Package priors provides a declarative specification of priors
+// on GP hyperparameters. A priors model is differentiable and
+// can be used as the Priors field of gp.Model.
+package priors
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Priors are put on log-transformed hyperparameters, which are
+// the arguments of GP.Observe for kernels with the default
+// transforms. Normal is a distribution on the log value itself;
+// Gamma, HalfCauchy, and InvGamma are distributions on the
+// value of the hyperparameter, with the Jacobian of the log
+// transform accounted for. Priors cannot be put on
+// hyperparameters with other transforms (see kernel.Transform),
+// and New and Named reject them.
+
+// Type Prior is the interface of a prior on a log-transformed
+// hyperparameter.
+type Prior interface {
+	// Logp computes the log density of the log value x
+	// and the derivative of the log density by x.
+	Logp(x float64) (lp, dlp float64)
+}
+
+const (
+	log2   = 0.69314718055994530942
+	logpi  = 1.14472988584940017414
+	log2pi = log2 + logpi
+)
+
+// Normal is the normal distribution on the log value, that is,
+// the log-normal distribution on the value.
+type Normal struct {
+	Mu, Sigma float64
+}
+
+func (p Normal) Logp(x float64) (lp, dlp float64) {
+	d := (x - p.Mu) / p.Sigma
+	lp = -0.5*d*d - math.Log(p.Sigma) - 0.5*log2pi
+	dlp = -d / p.Sigma
+	return lp, dlp
+}
+
+// Gamma is the gamma distribution on the value, with shape
+// Alpha and rate Beta.
+type Gamma struct {
+	Alpha, Beta float64
+}
+
+func (p Gamma) Logp(x float64) (lp, dlp float64) {
+	lgamma, _ := math.Lgamma(p.Alpha)
+	y := math.Exp(x)
+	lp = p.Alpha*math.Log(p.Beta) - lgamma + p.Alpha*x - p.Beta*y
+	dlp = p.Alpha - p.Beta*y
+	return lp, dlp
+}
+
+// HalfCauchy is the half-Cauchy distribution on the value,
+// with scale Gamma.
+type HalfCauchy struct {
+	Gamma float64
+}
+
+func (p HalfCauchy) Logp(x float64) (lp, dlp float64) {
+	z := math.Exp(x) / p.Gamma
+	z2 := z * z
+	lp = log2 - logpi - math.Log(p.Gamma) - math.Log1p(z2) + x
+	dlp = 1 - 2*z2/(1+z2)
+	return lp, dlp
+}
+
+// InvGamma is the inverse-gamma distribution on the value,
+// with shape Alpha and scale Beta.
+type InvGamma struct {
+	Alpha, Beta float64
+}
+
+func (p InvGamma) Logp(x float64) (lp, dlp float64) {
+	lgamma, _ := math.Lgamma(p.Alpha)
+	y := math.Exp(-x)
+	lp = p.Alpha*math.Log(p.Beta) - lgamma - p.Alpha*x - p.Beta*y
+	dlp = -p.Alpha + p.Beta*y
+	return lp, dlp
+}
+
+// Type Model is the model of priors on hyperparameters,
+// returned by New or Named. Hyperparameters without priors have
+// improper uniform priors on the log value. Model implements
+// Infergo's ElementalModel.
+type Model struct {
+	priors map[int]Prior // by index of hyperparameter
+
+	grad []float64 // gradient
+}
+
+// New returns the model of priors on hyperparameters of the GP
+// given by index, in the order of arguments of GP.Observe. The
+// hyperparameters must be log-transformed.
+func New(g *gp.GP, priors map[int]Prior) (*Model, error) {
+	m := &Model{priors: make(map[int]Prior)}
+	ts := g.Transforms()
+	for i, prior := range priors {
+		if i < 0 || i >= len(ts) {
+			return nil, fmt.Errorf("prior on hyperparameter %d, "+
+				"there are %d hyperparameters", i, len(ts))
+		}
+		if ts[i] != kernel.Log {
+			return nil, fmt.Errorf("hyperparameter %q is not "+
+				"log-transformed, priors are on log values",
+				g.Names()[i])
+		}
+		m.priors[i] = prior
+	}
+	return m, nil
+}
+
+// Priors returns the priors by index of hyperparameter.
+func (m *Model) Priors() map[int]Prior {
+	priors := make(map[int]Prior, len(m.priors))
+	for i, prior := range m.priors {
+		priors[i] = prior
+	}
+	return priors
+}
+
+func (m *Model) Observe(x []float64) float64 {
+	// Priors are summed in the order of indices, for
+	// reproducibility.
+	indices := make([]int, 0, len(m.priors))
+	for i := range m.priors {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	if len(m.grad) != len(x) {
+		m.grad = make([]float64, len(x))
+	} else {
+		for i := range m.grad {
+			m.grad[i] = 0
+		}
+	}
+
+	ll := 0.
+	for _, i := range indices {
+		if i >= len(x) {
+			panic(fmt.Sprintf("prior on x[%d], len(x)=%d", i, len(x)))
+		}
+		lp, dlp := m.priors[i].Logp(x[i])
+		ll += lp
+		m.grad[i] = dlp
+	}
+	return ll
+}
+
+func (m *Model) Gradient() []float64 {
+	return m.grad
+}
+
+// Named returns the model of priors on hyperparameters of the
+// GP given by name (see GP.Names), as New.
+func Named(g *gp.GP, priors map[string]Prior) (*Model, error) {
+	indexed := make(map[int]Prior)
+	for name, prior := range priors {
+		i := g.Index(name)
+		if i == -1 {
+			return nil, fmt.Errorf("unknown hyperparameter %q", name)
+		}
+		indexed[i] = prior
+	}
+	return New(g, indexed)
+}
@@ -0,0 +1,162 @@
+package priors
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"math"
+	"testing"
+)
+
+// Difference and precision for numerical derivative
+const (
+	dx  = 1e-8
+	eps = 1e-4
+)
+
+func TestLogp(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		prior Prior
+		x     float64
+		lp    float64
+	}{
+		{"normal", Normal{Mu: 0, Sigma: 1}, 0, -0.918939},
+		{"normal, shifted", Normal{Mu: 1, Sigma: 2}, -1, -2.112086},
+		// exp(x)=1, Gamma(2, 1): log(1*exp(-1)) + 0
+		{"gamma", Gamma{Alpha: 2, Beta: 1}, 0, -1},
+		// exp(x)=1, HalfCauchy(1): log(2/(2π))
+		{"half-cauchy", HalfCauchy{Gamma: 1}, 0, -logpi},
+		// exp(x)=1, InvGamma(2, 1): log(exp(-1))
+		{"inverse gamma", InvGamma{Alpha: 2, Beta: 1}, 0, -1},
+	} {
+		lp, dlp := c.prior.Logp(c.x)
+		if math.Abs(lp-c.lp) > 1e-6 {
+			t.Errorf("%s: wrong log density: got %f, want %f",
+				c.name, lp, c.lp)
+		}
+		lpx, _ := c.prior.Logp(c.x + dx)
+		dlpdx := (lpx - lp) / dx
+		if math.Abs(dlp-dlpdx) > eps {
+			t.Errorf("%s: derivative mismatch: got %.4f, want %.4f",
+				c.name, dlp, dlpdx)
+		}
+	}
+}
+
+func TestModel(t *testing.T) {
+	m, err := New(&gp.GP{
+		NDim:  1,
+		Simil: kernel.Periodic,
+		Noise: kernel.UniformNoise,
+	}, map[int]Prior{
+		0: Normal{Mu: -1, Sigma: 1},
+		2: HalfCauchy{Gamma: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := []float64{0.5, 1, -1}
+	ll := m.Observe(x)
+	grad := model.Gradient(m)
+	lp0, _ := Normal{Mu: -1, Sigma: 1}.Logp(0.5)
+	lp2, _ := HalfCauchy{Gamma: 0.5}.Logp(-1)
+	if math.Abs(ll-(lp0+lp2)) > 1e-6 {
+		t.Errorf("wrong log-likelihood: got %f, want %f", ll, lp0+lp2)
+	}
+	if len(grad) != len(x) {
+		t.Fatalf("wrong gradient size: got %d, want %d",
+			len(grad), len(x))
+	}
+	if grad[1] != 0 {
+		t.Errorf("non-zero gradient without prior: got %f", grad[1])
+	}
+
+	// The priors are used with a GP
+	g := &gp.Model{
+		GP: &gp.GP{
+			NDim:  1,
+			Simil: kernel.Normal,
+			Noise: kernel.UniformNoise,
+			X:     [][]float64{{0}, {1}, {2}},
+			Y:     []float64{1, 0, -1},
+		},
+	}
+	g.Priors, err = New(g.GP, map[int]Prior{
+		0: Gamma{Alpha: 2, Beta: 1},
+		1: InvGamma{Alpha: 2, Beta: 0.1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x = []float64{0.5, -1}
+	ll = g.Observe(x)
+	grad = model.Gradient(g)
+	for j := range x {
+		x0 := x[j]
+		x[j] += dx
+		llj := g.Observe(x)
+		model.DropGradient(g)
+		dldx := (llj - ll) / dx
+		x[j] = x0
+		if math.Abs(grad[j]-dldx) > eps {
+			t.Errorf("dl/dx%d mismatch: got %.4f, want %.4f",
+				j, grad[j], dldx)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	g := &gp.GP{
+		NDim:  1,
+		Simil: kernel.Normal,
+		Noise: kernel.UniformNoise,
+	}
+	m, err := Named(g, map[string]Prior{
+		"noise.s": HalfCauchy{Gamma: 0.1},
+	})
+	if err != nil {
+		t.Fatalf("named: %v", err)
+	}
+	if ps := m.Priors(); ps[1] == nil || len(ps) != 1 {
+		t.Errorf("wrong priors: got %v, want prior on x[1]", ps)
+	}
+	_, err = Named(g, map[string]Prior{
+		"simil.p": Normal{Mu: 0, Sigma: 1},
+	})
+	if err == nil {
+		t.Errorf("unknown name: no error")
+	}
+
+	// The densities assume the log transform.
+	g.Simil = bounded{kernel.Normal}
+	_, err = Named(g, map[string]Prior{
+		"simil.l": Gamma{Alpha: 2, Beta: 1},
+	})
+	if err == nil {
+		t.Errorf("logit-transformed parameter: no error")
+	}
+	if _, err = Named(g, map[string]Prior{
+		"noise.s": HalfCauchy{Gamma: 0.1},
+	}); err != nil {
+		t.Errorf("log-transformed parameter: %v", err)
+	}
+
+	// Priors given by index are checked as well.
+	if _, err = New(g, map[int]Prior{0: Gamma{Alpha: 2, Beta: 1}}); err == nil {
+		t.Errorf("logit-transformed parameter by index: no error")
+	}
+	if _, err = New(g, map[int]Prior{2: Normal{Sigma: 1}}); err == nil {
+		t.Errorf("index out of range: no error")
+	}
+}
+
+// Type bounded is a kernel with the parameter bounded by the
+// logit transform.
+type bounded struct {
+	gp.Kernel
+}
+
+func (bounded) Transforms() []kernel.Transform {
+	return []kernel.Transform{kernel.Logit{Lo: 0, Hi: 10}}
+}
@@ -0,0 +1,160 @@
+// Package query specifies the inputs at which a GP is queried for
+// predictions: points after the data up to a horizon, with a
+// step; a grid over several dimensions; or query points from a
+// CSV file. The predictions at the points, with their joint
+// uncertainty, are returned by GP.ProduceCov.
+package query
+
+import (
+	"bitbucket.org/dtolpin/gogp/data"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// eps is the relative tolerance of counting steps, so that a
+// bound which is a multiple of the step is included despite
+// rounding.
+const eps = 1e-9
+
+// Horizon returns the points after last, with the first input
+// advanced by step, 2*step, ..., up to and including horizon;
+// the other inputs are those of last.
+func Horizon(last []float64, horizon, step float64) ([][]float64, error) {
+	if len(last) == 0 {
+		return nil, errors.New("no inputs")
+	}
+	if step <= 0 || horizon < step {
+		return nil, fmt.Errorf("horizon %g, step %g: "+
+			"step must be positive and at most horizon", horizon, step)
+	}
+	n := int(math.Floor(horizon/step + eps))
+	z := make([][]float64, n)
+	for i := range z {
+		z[i] = append([]float64{}, last...)
+		z[i][0] += float64(i+1) * step
+	}
+	return z, nil
+}
+
+// Type Axis is an axis of a grid, from From to To inclusive,
+// with step Step.
+type Axis struct {
+	From, To, Step float64
+}
+
+// Grid returns the points of the grid over the axes, the last
+// axis varying fastest.
+func Grid(axes ...Axis) ([][]float64, error) {
+	if len(axes) == 0 {
+		return nil, errors.New("no axes")
+	}
+	n := 1
+	counts := make([]int, len(axes))
+	for i, a := range axes {
+		if a.Step <= 0 || a.To < a.From {
+			return nil, fmt.Errorf("axis %d, %g:%g:%g: step must be "+
+				"positive and From at most To", i+1, a.From, a.To, a.Step)
+		}
+		counts[i] = int(math.Floor((a.To-a.From)/a.Step+eps)) + 1
+		n *= counts[i]
+	}
+	z := make([][]float64, n)
+	for k := range z {
+		z[k] = make([]float64, len(axes))
+		j := k
+		for i := len(axes) - 1; i >= 0; i-- {
+			z[k][i] = axes[i].From + float64(j%counts[i])*axes[i].Step
+			j /= counts[i]
+		}
+	}
+	return z, nil
+}
+
+// Load loads query points from CSV or TSV data, all columns of
+// which are inputs (see data.Load). Timestamps must have the
+// origin and the unit of the training data, in opts.
+func Load(r io.Reader, opts data.Options) ([][]float64, error) {
+	opts.NoOutput = true
+	t, err := data.Load(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return t.X, nil
+}
+
+// Parse returns the query points of a specification, one of
+//   horizon=H[,step=S]        see Horizon, step 1 by default
+//   grid=FROM:TO:STEP[,...]   see Grid, an axis per input
+//   file=PATH                 see Load
+// where last are the last inputs of the data, and opts are the
+// options of loading the file. The points must have as many
+// inputs as last.
+func Parse(spec string, last []float64, opts data.Options) (
+	z [][]float64,
+	err error,
+) {
+	kind, value, _ := strings.Cut(spec, "=")
+	switch kind {
+	case "horizon":
+		fields := strings.Split(value, ",")
+		var horizon float64
+		horizon, err = strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: invalid horizon", spec)
+		}
+		step := 1.
+		switch {
+		case len(fields) == 2 && strings.HasPrefix(fields[1], "step="):
+			step, err = strconv.ParseFloat(
+				strings.TrimPrefix(fields[1], "step="), 64)
+			if err != nil {
+				return nil, fmt.Errorf("query %q: invalid step", spec)
+			}
+		case len(fields) != 1:
+			return nil, fmt.Errorf("query %q: invalid horizon", spec)
+		}
+		z, err = Horizon(last, horizon, step)
+	case "grid":
+		var axes []Axis
+		for _, field := range strings.Split(value, ",") {
+			bounds := strings.Split(field, ":")
+			if len(bounds) != 3 {
+				return nil, fmt.Errorf("query %q: invalid axis %q",
+					spec, field)
+			}
+			var a [3]float64
+			for i := range a {
+				if a[i], err = strconv.ParseFloat(bounds[i], 64); err != nil {
+					return nil, fmt.Errorf("query %q: invalid axis %q",
+						spec, field)
+				}
+			}
+			axes = append(axes, Axis{From: a[0], To: a[1], Step: a[2]})
+		}
+		z, err = Grid(axes...)
+	case "file":
+		var file *os.File
+		file, err = os.Open(value)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		z, err = Load(file, opts)
+	default:
+		return nil, fmt.Errorf("query %q: unknown kind %q, "+
+			"want horizon, grid, or file", spec, kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %v", spec, err)
+	}
+	if len(z[0]) != len(last) {
+		return nil, fmt.Errorf("query %q: %d inputs, want %d",
+			spec, len(z[0]), len(last))
+	}
+	return z, nil
+}
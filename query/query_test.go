@@ -0,0 +1,84 @@
+package query
+
+import (
+	"bitbucket.org/dtolpin/gogp/data"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// equal compares points up to rounding.
+func equal(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > 1e-12 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "query.csv")
+	if err := os.WriteFile(path, []byte("t,s\n2020-01-03,1\n2020-01-04,NA\n"+
+		"2020-01-05T12:00:00Z,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := data.Options{
+		Header: true,
+		Origin: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, c := range []struct {
+		spec string
+		last []float64
+		z    [][]float64
+	}{
+		{"horizon=3", []float64{10}, [][]float64{{11}, {12}, {13}}},
+		{"horizon=1,step=0.25", []float64{0.5},
+			[][]float64{{0.75}, {1}, {1.25}, {1.5}}},
+		{"horizon=0.3,step=0.1", []float64{0, 7},
+			[][]float64{{0.1, 7}, {0.2, 7}, {0.3, 7}}},
+		{"grid=0:1:0.5", []float64{3}, [][]float64{{0}, {0.5}, {1}}},
+		{"grid=0:1:1,5:6.5:0.5", []float64{0, 0},
+			[][]float64{{0, 5}, {0, 5.5}, {0, 6}, {0, 6.5},
+				{1, 5}, {1, 5.5}, {1, 6}, {1, 6.5}}},
+		{"file=" + path, []float64{1, 0}, [][]float64{{2, 1}, {4.5, 2}}},
+	} {
+		z, err := Parse(c.spec, c.last, opts)
+		if err != nil {
+			t.Errorf("%s: %v", c.spec, err)
+			continue
+		}
+		if !equal(z, c.z) {
+			t.Errorf("%s: wrong points: got %v, want %v", c.spec, z, c.z)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"later=3",
+		"horizon=x",
+		"horizon=3,stride=1",
+		"horizon=1,step=2",
+		"horizon=1,step=0",
+		"grid=0:1",
+		"grid=1:0:0.1",
+		"grid=0:1:0.5,0:1:0.5",
+		"file=" + filepath.Join(dir, "missing.csv"),
+	} {
+		if _, err := Parse(spec, []float64{0}, data.Options{}); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
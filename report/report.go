@@ -0,0 +1,153 @@
+// Package report writes self-contained reports of fits and
+// forecasts, as SVG figures or an HTML page with the figures
+// inline. A figure plots observed points, lines, such as the
+// posterior mean or the hyperparameters over time, and bands,
+// such as credible intervals, over a common x axis. The output
+// does not refer to any external resources.
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+)
+
+// Type Kind is the kind of a series.
+type Kind int
+
+const (
+	Line   Kind = iota // connected points
+	Points             // scattered points
+	Band               // an area between two lines
+)
+
+// Type Series is a named series of a figure. Points with a value
+// which is not finite are not drawn, and break lines and bands.
+type Series struct {
+	Name  string
+	Kind  Kind
+	X, Y  []float64 // for a band, Y is the lower bound
+	Hi    []float64 // the upper bound of a band
+	Color string    // a CSS color, from the palette when empty
+}
+
+// Type Figure is a plot of series over a common x axis.
+type Figure struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Series []*Series
+	colors int // colors of the palette taken
+}
+
+// Palette are the colors of the series.
+var Palette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// Size of figures, in pixels
+var (
+	Width  = 800
+	Height = 320
+)
+
+// color returns the next color of the palette.
+func (f *Figure) color() string {
+	c := Palette[f.colors%len(Palette)]
+	f.colors++
+	return c
+}
+
+// Line adds a line.
+func (f *Figure) Line(name string, x, y []float64) *Series {
+	s := &Series{Name: name, Kind: Line, X: x, Y: y, Color: f.color()}
+	f.Series = append(f.Series, s)
+	return s
+}
+
+// Points adds scattered points.
+func (f *Figure) Points(name string, x, y []float64) *Series {
+	s := &Series{Name: name, Kind: Points, X: x, Y: y, Color: f.color()}
+	f.Series = append(f.Series, s)
+	return s
+}
+
+// Band adds a band between lo and hi.
+func (f *Figure) Band(name string, x, lo, hi []float64) *Series {
+	s := &Series{Name: name, Kind: Band, X: x, Y: lo, Hi: hi,
+		Color: f.color()}
+	f.Series = append(f.Series, s)
+	return s
+}
+
+// Forecast adds Gaussian forecasts: the credible band at the
+// level, and the mean, of the same color.
+func (f *Figure) Forecast(
+	name string,
+	x, mu, sigma []float64,
+	level float64,
+) {
+	z := math.Sqrt2 * math.Erfinv(level)
+	lo := make([]float64, len(mu))
+	hi := make([]float64, len(mu))
+	for i := range mu {
+		lo[i], hi[i] = mu[i]-z*sigma[i], mu[i]+z*sigma[i]
+	}
+	band := f.Band(fmt.Sprintf("%s, %g%%", name, 100*level), x, lo, hi)
+	f.Series = append(f.Series,
+		&Series{Name: name, Kind: Line, X: x, Y: mu, Color: band.Color})
+}
+
+// Type Report is a page of figures, with a preformatted summary,
+// such as the kernel and the scores of the forecasts.
+type Report struct {
+	Title   string
+	Summary string
+	Figures []*Figure
+}
+
+// Figure adds a figure to the report.
+func (r *Report) Figure(title, xlabel, ylabel string) *Figure {
+	f := &Figure{Title: title, XLabel: xlabel, YLabel: ylabel}
+	r.Figures = append(r.Figures, f)
+	return f
+}
+
+// HTML writes the report as a standalone HTML page.
+func (r *Report) HTML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	title := html.EscapeString(r.Title)
+	fmt.Fprintf(bw, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f6f6f6; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+`, title, title)
+	if r.Summary != "" {
+		fmt.Fprintf(bw, "<pre>%s</pre>\n", html.EscapeString(r.Summary))
+	}
+	for _, f := range r.Figures {
+		fmt.Fprintln(bw, "<div>")
+		f.svg(bw, false)
+		fmt.Fprintln(bw, "</div>")
+	}
+	fmt.Fprintln(bw, "</body>\n</html>")
+	return bw.Flush()
+}
+
+// SVG writes the figure as a standalone SVG image.
+func (f *Figure) SVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	f.svg(bw, true)
+	return bw.Flush()
+}
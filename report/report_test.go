@@ -0,0 +1,110 @@
+package report
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTicks(t *testing.T) {
+	for _, c := range []struct {
+		lo, hi float64
+		n      int
+		ticks  []float64
+	}{
+		{0, 1, 5, []float64{0, 0.2, 0.4, 0.6, 0.8, 1}},
+		{-3.7, 12, 5, []float64{0, 5, 10}},
+		{0.1, 0.95, 9, []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}},
+		{1e6, 3.1e6, 5, []float64{1e6, 1.5e6, 2e6, 2.5e6, 3e6}},
+	} {
+		if got := ticks(c.lo, c.hi, c.n); !reflect.DeepEqual(got, c.ticks) {
+			t.Errorf("wrong ticks in [%v, %v]: got %v, want %v",
+				c.lo, c.hi, got, c.ticks)
+		}
+	}
+}
+
+// elements counts the elements of an SVG image by name, and
+// fails if the image is not well-formed.
+func elements(t *testing.T, svg string) map[string]int {
+	counts := make(map[string]int)
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("malformed SVG: %v\n%s", err, svg)
+		}
+		if e, ok := tok.(xml.StartElement); ok {
+			counts[e.Name.Local]++
+		}
+	}
+	return counts
+}
+
+func TestSVG(t *testing.T) {
+	nan := math.NaN()
+	f := &Figure{Title: "Fit <1>", XLabel: "x", YLabel: "y"}
+	f.Points("observed", []float64{0, 1, 2, 3}, []float64{1, nan, 2, 1.5})
+	f.Forecast("forecast",
+		[]float64{0, 1, 2, 3, 4}, []float64{1, 1.2, nan, 1.4, 1.3},
+		[]float64{0.1, 0.2, 0.3, 0.2, 0.1}, 0.9)
+	var b strings.Builder
+	if err := f.SVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	svg := b.String()
+	counts := elements(t, svg)
+	// The mean and the band are broken by the missing value.
+	if counts["polyline"] != 2 || counts["polygon"] != 2 {
+		t.Errorf("wrong segments: %d lines, %d bands",
+			counts["polyline"], counts["polygon"])
+	}
+	// 3 observed points and a legend entry
+	if counts["circle"] != 4 {
+		t.Errorf("wrong number of points: %d", counts["circle"])
+	}
+	for _, s := range []string{"Fit &lt;1&gt;", "forecast, 90%", "observed"} {
+		if !strings.Contains(svg, s) {
+			t.Errorf("no %q in the image", s)
+		}
+	}
+
+	// An empty figure is drawn as well.
+	b.Reset()
+	if err := (&Figure{}).SVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	elements(t, b.String())
+}
+
+func TestHTML(t *testing.T) {
+	r := &Report{Title: "Report & more", Summary: "CRPS: 0.1\n"}
+	r.Figure("Fit", "x", "y").Line("mean", []float64{0, 1}, []float64{1, 2})
+	r.Figure("LML", "x", "lml").Line("lml", []float64{0, 1}, []float64{-3, -2})
+	var b strings.Builder
+	if err := r.HTML(&b); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	if n := strings.Count(page, "<svg "); n != 2 {
+		t.Errorf("wrong number of figures: %d", n)
+	}
+	for _, s := range []string{"<title>Report &amp; more</title>",
+		"<pre>CRPS: 0.1\n</pre>"} {
+		if !strings.Contains(page, s) {
+			t.Errorf("no %q in the page", s)
+		}
+	}
+	// The page is self-contained.
+	for _, s := range []string{"<script", "<link", "src=", "href=", "url("} {
+		if strings.Contains(page, s) {
+			t.Errorf("external resource %q in the page", s)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Margins of the plot area, in pixels; the legend is on the right.
+const (
+	left   = 64
+	right  = 170
+	top    = 32
+	bottom = 44
+)
+
+// Type frame maps data coordinates to the plot area.
+type frame struct {
+	xmin, xmax, ymin, ymax float64
+	width, height          float64 // of the plot area
+}
+
+func (fr *frame) x(x float64) float64 {
+	return left + (x-fr.xmin)/(fr.xmax-fr.xmin)*fr.width
+}
+
+func (fr *frame) y(y float64) float64 {
+	return top + (fr.ymax-y)/(fr.ymax-fr.ymin)*fr.height
+}
+
+// finite reports whether all values are finite.
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// bounds returns the frame of the figure, over the finite values
+// of the series, padded.
+func (f *Figure) bounds() *frame {
+	fr := &frame{
+		xmin: math.Inf(1), xmax: math.Inf(-1),
+		ymin: math.Inf(1), ymax: math.Inf(-1),
+		width:  float64(Width - left - right),
+		height: float64(Height - top - bottom),
+	}
+	for _, s := range f.Series {
+		for i := range s.X {
+			ys := []float64{s.Y[i]}
+			if s.Kind == Band {
+				ys = append(ys, s.Hi[i])
+			}
+			if !finite(s.X[i]) || !finite(ys...) {
+				continue
+			}
+			fr.xmin, fr.xmax = math.Min(fr.xmin, s.X[i]), math.Max(fr.xmax, s.X[i])
+			for _, y := range ys {
+				fr.ymin, fr.ymax = math.Min(fr.ymin, y), math.Max(fr.ymax, y)
+			}
+		}
+	}
+	pad := func(lo, hi float64) (float64, float64) {
+		switch {
+		case lo > hi:
+			return 0, 1
+		case lo == hi:
+			return lo - 0.5, hi + 0.5
+		default:
+			d := 0.04 * (hi - lo)
+			return lo - d, hi + d
+		}
+	}
+	fr.xmin, fr.xmax = pad(fr.xmin, fr.xmax)
+	fr.ymin, fr.ymax = pad(fr.ymin, fr.ymax)
+	return fr
+}
+
+// ticks returns about n round values between lo and hi.
+func ticks(lo, hi float64, n int) []float64 {
+	raw := (hi - lo) / float64(n)
+	e := math.Floor(math.Log10(raw))
+	mag := math.Pow(10, e)
+	m := 10.
+	for _, mm := range []float64{1, 2, 5} {
+		if raw <= mm*mag {
+			m = mm
+			break
+		}
+	}
+	// Ticks are computed from integers, dividing by powers of 10
+	// rather than multiplying by their inverses, to be exact.
+	tick := func(k float64) float64 {
+		if e < 0 {
+			return k * m / math.Pow(10, -e)
+		}
+		return k * m * mag
+	}
+	step := tick(1)
+	var ts []float64
+	for k := math.Ceil(lo / step); tick(k) <= hi; k++ {
+		ts = append(ts, tick(k))
+	}
+	return ts
+}
+
+// label formats a tick label.
+func label(v float64) string {
+	if v == 0 {
+		v = 0 // rather than -0
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// segments returns the maximal runs of indices at which the
+// series is finite.
+func (s *Series) segments() [][]int {
+	var segs [][]int
+	var seg []int
+	for i := range s.X {
+		ok := finite(s.X[i], s.Y[i])
+		if s.Kind == Band {
+			ok = ok && finite(s.Hi[i])
+		}
+		if ok {
+			seg = append(seg, i)
+		} else if seg != nil {
+			segs = append(segs, seg)
+			seg = nil
+		}
+	}
+	if seg != nil {
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+// svg writes the figure as an SVG element; a standalone image
+// has the XML declaration.
+func (f *Figure) svg(w *bufio.Writer, standalone bool) {
+	if standalone {
+		fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	}
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" `+
+		`width="%d" height="%d" viewBox="0 0 %d %d" `+
+		`font-family="sans-serif" font-size="11">`+"\n",
+		Width, Height, Width, Height)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="white"/>`+"\n",
+		Width, Height)
+	fr := f.bounds()
+
+	// Title and axis labels
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="14" `+
+		`text-anchor="middle">%s</text>`+"\n",
+		left+int(fr.width)/2, top-12, html.EscapeString(f.Title))
+	fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+		left+int(fr.width)/2, Height-8, html.EscapeString(f.XLabel))
+	fmt.Fprintf(w, `<text x="14" y="%d" text-anchor="middle" `+
+		`transform="rotate(-90 14 %d)">%s</text>`+"\n",
+		top+int(fr.height)/2, top+int(fr.height)/2,
+		html.EscapeString(f.YLabel))
+
+	// Grid and ticks
+	for _, t := range ticks(fr.xmin, fr.xmax, 8) {
+		x := fr.x(t)
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" `+
+			`stroke="#e6e6e6"/>`+"\n", x, top, x, top+fr.height)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" `+
+			`text-anchor="middle">%s</text>`+"\n",
+			x, top+fr.height+16, label(t))
+	}
+	for _, t := range ticks(fr.ymin, fr.ymax, 5) {
+		y := fr.y(t)
+		fmt.Fprintf(w, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" `+
+			`stroke="#e6e6e6"/>`+"\n", left, y, left+fr.width, y)
+		fmt.Fprintf(w, `<text x="%d" y="%.1f" `+
+			`text-anchor="end">%s</text>`+"\n",
+			left-6, y+4, label(t))
+	}
+	fmt.Fprintf(w, `<rect x="%d" y="%d" width="%.1f" height="%.1f" `+
+		`fill="none" stroke="#888"/>`+"\n",
+		left, top, fr.width, fr.height)
+
+	// Series, bands under lines under points
+	for _, kind := range []Kind{Band, Line, Points} {
+		for _, s := range f.Series {
+			if s.Kind == kind {
+				s.svg(w, fr)
+			}
+		}
+	}
+
+	// Legend
+	for i, s := range f.Series {
+		x := left + int(fr.width) + 12
+		y := top + 8 + 18*i
+		color := html.EscapeString(s.Color)
+		switch s.Kind {
+		case Band:
+			fmt.Fprintf(w, `<rect x="%d" y="%d" width="16" height="10" `+
+				`fill="%s" fill-opacity="0.25"/>`+"\n", x, y-5, color)
+		case Line:
+			fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" `+
+				`stroke="%s" stroke-width="2"/>`+"\n", x, y, x+16, y, color)
+		case Points:
+			fmt.Fprintf(w, `<circle cx="%d" cy="%d" r="3" fill="%s"/>`+"\n",
+				x+8, y, color)
+		}
+		fmt.Fprintf(w, `<text x="%d" y="%d">%s</text>`+"\n",
+			x+22, y+4, html.EscapeString(s.Name))
+	}
+	fmt.Fprintln(w, "</svg>")
+}
+
+// svg writes the series in the frame.
+func (s *Series) svg(w *bufio.Writer, fr *frame) {
+	color := html.EscapeString(s.Color)
+	for _, seg := range s.segments() {
+		switch s.Kind {
+		case Band:
+			var pts []string
+			for _, i := range seg {
+				pts = append(pts, fmt.Sprintf("%.1f,%.1f",
+					fr.x(s.X[i]), fr.y(s.Hi[i])))
+			}
+			for j := len(seg) - 1; j >= 0; j-- {
+				i := seg[j]
+				pts = append(pts, fmt.Sprintf("%.1f,%.1f",
+					fr.x(s.X[i]), fr.y(s.Y[i])))
+			}
+			fmt.Fprintf(w, `<polygon points="%s" fill="%s" `+
+				`fill-opacity="0.25" stroke="none"/>`+"\n",
+				strings.Join(pts, " "), color)
+		case Line:
+			var pts []string
+			for _, i := range seg {
+				pts = append(pts, fmt.Sprintf("%.1f,%.1f",
+					fr.x(s.X[i]), fr.y(s.Y[i])))
+			}
+			fmt.Fprintf(w, `<polyline points="%s" fill="none" `+
+				`stroke="%s" stroke-width="1.5"/>`+"\n",
+				strings.Join(pts, " "), color)
+		case Points:
+			for _, i := range seg {
+				fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="2.5" `+
+					`fill="%s"/>`+"\n", fr.x(s.X[i]), fr.y(s.Y[i]), color)
+			}
+		}
+	}
+}
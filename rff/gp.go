@@ -0,0 +1,354 @@
+// Package rff implements approximate Gaussian process regression
+// with random Fourier features (Rahimi and Recht, 2007). A
+// stationary kernel is replaced by the inner product of D random
+// features,
+//   φ(x) = √(c/M) [cos(ω_j·x), sin(ω_j·x)], j = 1..M, D = 2M,
+// with frequencies ω_j drawn from the spectral density of the
+// kernel, and regression is Bayesian linear regression on the
+// features, in O(n D^2). The GP exposes the same API as gp.GP
+// and can be used in its place for exploratory fits on large
+// data; functions drawn from the posterior are cheap to sample.
+package rff
+
+import (
+	gogp "bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"math/rand"
+)
+
+// Type GP is the random Fourier feature implementation of GP.
+// Given the weights w of the features, f(x) = φ(x)^⊤ w, and the
+// prior of the weights is N(0, I).
+type GP struct {
+	// Configuration
+	NDim     int         // number of dimensions
+	Simil    Kernel      // similarity kernel
+	Noise    gogp.Kernel // noise kernel
+	Features int         // number of frequencies M, 100 by default
+	Rand     *rand.Rand  // source of frequencies, seeded with 1 by default
+
+	// Data
+	ThetaSimil, ThetaNoise []float64   // kernel parameters
+	X                      [][]float64 // inputs
+	Y                      []float64   // outputs
+
+	// Derivatives of hyperparameter transforms, for the chain rule
+	dThetaSimil, dThetaNoise []float64
+
+	// Frequencies for the unit length scale, drawn once
+	omega [][]float64
+
+	// Cached computations
+	A    mat.Cholesky  // Cholesky decomposition of I + Φ^⊤ N^-1 Φ
+	Mean *mat.VecDense // posterior mean of the weights
+	lml  float64       // log marginal likelihood
+	grad []float64     // gradient of the log marginal likelihood
+}
+
+// defaultFeatures is the default number of frequencies.
+const defaultFeatures = 100
+
+func (gp *GP) defaults() {
+	gp.Noise = gogp.DefaultNoise(gp.Noise)
+	gp.ThetaSimil = gogp.DefaultTheta(gp.Simil, gp.ThetaSimil)
+	gp.ThetaNoise = gogp.DefaultTheta(gp.Noise, gp.ThetaNoise)
+
+	m := gp.Features
+	if m <= 0 {
+		m = defaultFeatures
+	}
+	if len(gp.omega) != m || len(gp.omega[0]) != gp.NDim {
+		if gp.Rand == nil {
+			gp.Rand = rand.New(rand.NewSource(1))
+		}
+		gp.omega = make([][]float64, m)
+		for j := range gp.omega {
+			gp.omega[j] = gp.Simil.Frequency(gp.Rand, gp.NDim)
+		}
+	}
+}
+
+// Names returns the names of the hyperparameters, in the order
+// of the arguments of Observe, as GP.Names in package gp.
+func (gp *GP) Names() []string {
+	gp.defaults()
+	var all []string
+	for _, name := range gp.Simil.Names() {
+		all = append(all, "simil."+name)
+	}
+	for _, name := range gogp.KernelNames(gp.Noise) {
+		all = append(all, "noise."+name)
+	}
+	return all
+}
+
+// Transforms returns the transforms of the hyperparameters,
+// in the order of the arguments of Observe.
+func (gp *GP) Transforms() []kernel.Transform {
+	gp.defaults()
+	return append(gogp.KernelTransforms(gp.Simil), gogp.KernelTransforms(gp.Noise)...)
+}
+
+// Absorb absorbs observations into the process.
+func (gp *GP) Absorb(x [][]float64, y []float64) (err error) {
+	// Set the defaults
+	gp.defaults()
+	// Remember the inputs
+	gp.X, gp.Y = x, y
+	// When Absorb is called directly, the gradient is not computed
+	return gp.absorb(withoutGradient)
+}
+
+// LML returns the log marginal likelihood of the kernel given
+// the absorbed observations.
+func (gp *GP) LML() float64 {
+	return gp.lml
+}
+
+const (
+	withoutGradient = false
+	withGradient    = true
+)
+
+// features computes the features of inputs x and, when withGrad
+// is true, their derivatives by the length scale.
+func (gp *GP) features(x [][]float64, withGrad bool) (phi, dphi *mat.Dense) {
+	c, l := gp.ThetaSimil[0], gp.ThetaSimil[1]
+	m := len(gp.omega)
+	scale := math.Sqrt(c / float64(m))
+	phi = mat.NewDense(len(x), 2*m, nil)
+	if withGrad {
+		dphi = mat.NewDense(len(x), 2*m, nil)
+	}
+	for i := range x {
+		for j, omega := range gp.omega {
+			u := 0.
+			for k := range omega {
+				u += omega[k] * x[i][k]
+			}
+			u /= l
+			cos, sin := math.Cos(u), math.Sin(u)
+			phi.Set(i, j, scale*cos)
+			phi.Set(i, m+j, scale*sin)
+			if withGrad {
+				// ∂u/∂l = -u/l
+				dphi.Set(i, j, scale*sin*u/l)
+				dphi.Set(i, m+j, -scale*cos*u/l)
+			}
+		}
+	}
+	return phi, dphi
+}
+
+// absorb performs Bayesian linear regression on the features.
+// With K = Φ Φ^⊤ + N, A = I + Φ^⊤ N^-1 Φ, b = Φ^⊤ N^-1 y:
+//   L = −½ log|N| − ½ log|A| − ½ y^⊤ N^-1 y + ½ b^⊤ A^-1 b − n/2 log(2π),
+// and the gradient is ½ tr(W ∂K), W = α α^⊤ − K^-1, computed
+// in O(n D) for each parameter from
+//   Φ^⊤ W = (Φ^⊤ α) α^⊤ − A^-1 Φ^⊤ N^-1.
+func (gp *GP) absorb(withGrad bool) error {
+	ntheta := gp.Simil.NTheta() + gp.Noise.NTheta()
+	gp.lml = 0
+	gp.grad = make([]float64, ntheta)
+	n := len(gp.X)
+	d := 2 * len(gp.omega)
+
+	phi, dphi := gp.features(gp.X, withGrad)
+
+	// Noise
+	noise := make([]float64, n)
+	dnoise := make([][]float64, gp.Noise.NTheta())
+	for p := range dnoise {
+		dnoise[p] = make([]float64, n)
+	}
+	nargs := make([]float64, gp.Noise.NTheta()+gp.NDim)
+	copy(nargs, gp.ThetaNoise)
+	for i := range gp.X {
+		copy(nargs[gp.Noise.NTheta():], gp.X[i])
+		var ngrad []float64
+		noise[i], ngrad = gogp.Call(gp.Noise, nargs, withGrad)
+		if withGrad {
+			for p := range dnoise {
+				dnoise[p][i] = ngrad[p] * gp.dThetaNoise[p]
+			}
+		}
+	}
+
+	// Φ^⊤ N^-1
+	phitn := mat.NewDense(d, n, nil)
+	for i := 0; i != n; i++ {
+		for j := 0; j != d; j++ {
+			phitn.Set(j, i, phi.At(i, j)/noise[i])
+		}
+	}
+	a := mat.NewSymDense(d, nil)
+	var ad mat.Dense
+	ad.Mul(phitn, phi)
+	for j := 0; j != d; j++ {
+		for k := j; k != d; k++ {
+			a.SetSym(j, k, ad.At(j, k))
+		}
+		a.SetSym(j, j, a.At(j, j)+1)
+	}
+	if !gp.A.Factorize(a) {
+		return fmt.Errorf("Factorize(%v)", mat.Formatted(a))
+	}
+	b := mat.NewVecDense(d, nil)
+	b.MulVec(phitn, mat.NewVecDense(n, gp.Y))
+	gp.Mean = mat.NewVecDense(d, nil)
+	if err := gp.A.SolveVecTo(gp.Mean, b); err != nil {
+		return err
+	}
+
+	gp.lml = -0.5*float64(n)*math.Log(2*math.Pi) -
+		0.5*gp.A.LogDet() + 0.5*mat.Dot(b, gp.Mean)
+	for i := range gp.Y {
+		gp.lml -= 0.5 * (math.Log(noise[i]) + gp.Y[i]*gp.Y[i]/noise[i])
+	}
+
+	if !withGrad {
+		return nil
+	}
+
+	// α = N^-1 (y − Φ A^-1 b)
+	alpha := mat.NewVecDense(n, nil)
+	alpha.MulVec(phi, gp.Mean)
+	for i := range gp.Y {
+		alpha.SetVec(i, (gp.Y[i]-alpha.AtVec(i))/noise[i])
+	}
+	// Φ^⊤ W
+	var phitw mat.Dense
+	if err := gp.A.SolveTo(&phitw, phitn); err != nil {
+		return err
+	}
+	phita := mat.NewVecDense(d, nil)
+	phita.MulVec(phi.T(), alpha)
+	phitw.Scale(-1, &phitw)
+	phitw.RankOne(&phitw, 1, phita, alpha)
+
+	// ½ tr(W (∂Φ Φ^⊤ + Φ ∂Φ^⊤)) = tr(Φ^⊤ W ∂Φ)
+	trmul := func(dphi mat.Matrix) float64 {
+		s := 0.
+		for j := 0; j != d; j++ {
+			for i := 0; i != n; i++ {
+				s += phitw.At(j, i) * dphi.At(i, j)
+			}
+		}
+		return s
+	}
+	// ∂Φ/∂c = Φ/(2c)
+	gp.grad[0] = trmul(phi) / (2 * gp.ThetaSimil[0]) * gp.dThetaSimil[0]
+	gp.grad[1] = trmul(dphi) * gp.dThetaSimil[1]
+
+	// For the noise, ½ ∑ W_ii ∂N_ii, where
+	//   [K^-1]_ii = 1/N_i − [Φ A^-1 Φ^⊤]_ii/N_i^2
+	// and [Φ A^-1 Φ^⊤ N^-1]_ii = [Φ (A^-1 Φ^⊤ N^-1)]_ii.
+	for i := 0; i != n; i++ {
+		q := 0.
+		for j := 0; j != d; j++ {
+			q += phi.At(i, j) * (phita.AtVec(j)*alpha.AtVec(i) - phitw.At(j, i))
+		}
+		kinv := 1/noise[i] - q/noise[i]
+		w := alpha.AtVec(i)*alpha.AtVec(i) - kinv
+		for p := range dnoise {
+			gp.grad[gp.Simil.NTheta()+p] += 0.5 * w * dnoise[p][i]
+		}
+	}
+	return nil
+}
+
+// Produce computes predictions from the posterior of the
+// weights, N(A^-1 b, A^-1).
+func (gp *GP) Produce(x [][]float64) (
+	mu, sigma []float64,
+	err error,
+) {
+	gp.defaults()
+	phi, _ := gp.features(x, withoutGradient)
+	mu = make([]float64, len(x))
+	sigma = make([]float64, len(x))
+	if gp.Mean == nil {
+		// No observations, prior predictions
+		for i := range x {
+			sigma[i] = math.Sqrt(mat.Dot(phi.RowView(i), phi.RowView(i)))
+		}
+		return mu, sigma, nil
+	}
+	v := mat.NewVecDense(2*len(gp.omega), nil)
+	for i := range x {
+		mu[i] = mat.Dot(phi.RowView(i), gp.Mean)
+		if err := gp.A.SolveVecTo(v, phi.RowView(i)); err != nil {
+			return nil, nil, err
+		}
+		sigma[i] = math.Sqrt(mat.Dot(phi.RowView(i), v))
+	}
+	return mu, sigma, nil
+}
+
+// Sample draws a function from the posterior, or from the prior
+// if there are no observations. The function can be evaluated at
+// any input in O(D).
+func (gp *GP) Sample(rng *rand.Rand) func(x []float64) float64 {
+	gp.defaults()
+	d := 2 * len(gp.omega)
+	z := mat.NewVecDense(d, nil)
+	for j := 0; j != d; j++ {
+		z.SetVec(j, rng.NormFloat64())
+	}
+	w := mat.NewVecDense(d, nil)
+	if gp.Mean == nil {
+		w.CopyVec(z)
+	} else {
+		// w = A^-1 b + L^-⊤ z, where A = L L^⊤
+		var l mat.TriDense
+		gp.A.LTo(&l)
+		if err := w.SolveVec(l.T(), z); err != nil {
+			panic(err)
+		}
+		w.AddVec(w, gp.Mean)
+	}
+	theta := append([]float64{}, gp.ThetaSimil...)
+	return func(x []float64) float64 {
+		saved := gp.ThetaSimil
+		gp.ThetaSimil = theta
+		phi, _ := gp.features([][]float64{x}, withoutGradient)
+		gp.ThetaSimil = saved
+		return mat.Dot(phi.RowView(0), w)
+	}
+}
+
+// Observe and Gradient implement Infergo's ElementalModel.
+
+// Observe computes log marginal likelihood of the parameters
+// given the observations. The argument is the concatenation of
+// transformed hyperparameters; inputs must be assigned to fields
+// X, Y of gp.
+func (gp *GP) Observe(x []float64) float64 {
+	gp.defaults()
+	if len(x) != gp.Simil.NTheta()+gp.Noise.NTheta() {
+		panic("len(x)")
+	}
+
+	// Restore parameters from the unconstrained space,
+	// remembering derivatives of the transforms
+	gp.dThetaSimil = gogp.Restore(gogp.KernelTransforms(gp.Simil),
+		gp.ThetaSimil, gp.dThetaSimil, model.Shift(&x, gp.Simil.NTheta()))
+	gp.dThetaNoise = gogp.Restore(gogp.KernelTransforms(gp.Noise),
+		gp.ThetaNoise, gp.dThetaNoise, model.Shift(&x, gp.Noise.NTheta()))
+
+	if err := gp.absorb(withGradient); err != nil {
+		panic(err)
+	}
+
+	return gp.lml
+}
+
+// Gradient returns the gradient of the log marginal likelihood
+// computed by Observe.
+func (gp *GP) Gradient() []float64 {
+	return gp.grad
+}
@@ -0,0 +1,264 @@
+package rff
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// Type scaled is the dense counterpart of a stationary kernel,
+// the product of the kernel and the output scale.
+type scaled struct {
+	cov func(d float64) float64
+}
+
+func (s scaled) Observe(x []float64) float64 {
+	d := 0.
+	for i := 2; i != 2+(len(x)-2)/2; i++ {
+		dx := x[i] - x[i+(len(x)-2)/2]
+		d += dx * dx
+	}
+	return x[0] * s.cov(math.Sqrt(d)/x[1])
+}
+
+func (scaled) Gradient() []float64 { return nil }
+
+func (scaled) NTheta() int { return 2 }
+
+func normalCov(d float64) float64 {
+	return math.Exp(-d * d / 2)
+}
+
+func matern32(d float64) float64 {
+	d *= math.Sqrt(3)
+	return (1 + d) * math.Exp(-d)
+}
+
+func matern52(d float64) float64 {
+	d *= math.Sqrt(5)
+	return (1 + d + d*d/3) * math.Exp(-d)
+}
+
+// Difference and precision for numerical derivative
+const (
+	dx  = 1e-6
+	eps = 1e-4
+)
+
+func TestGP(t *testing.T) {
+	x1 := [][]float64{{0.1}, {0.4}, {0.5}, {1.3}, {0.9}, {2.0}, {2.1}}
+	x2 := [][]float64{
+		{0.1, 0.2}, {0.4, 0.1}, {0.5, 0.9}, {1.3, 0.3},
+		{0.9, 1.2}, {2.0, 0.5}, {2.1, 1.9},
+	}
+	y := []float64{0.5, 1, 0.8, -0.2, 0.1, -1, -0.7}
+	z1 := [][]float64{{-0.5}, {0.5}, {0.7}, {1.5}, {3}}
+	z2 := [][]float64{{-0.5, 0}, {0.5, 0.5}, {0.7, 1}, {1.5, 1}, {3, 2}}
+	for _, c := range []struct {
+		name       string
+		simil      Kernel
+		dense      gp.Kernel
+		x, z       [][]float64
+		thetaSimil []float64
+	}{
+		{
+			name:       "normal",
+			simil:      Normal,
+			dense:      scaled{normalCov},
+			x:          x1,
+			z:          z1,
+			thetaSimil: []float64{1.5, 0.7},
+		},
+		{
+			name:       "matern32",
+			simil:      Matern32,
+			dense:      scaled{matern32},
+			x:          x1,
+			z:          z1,
+			thetaSimil: []float64{1.5, 0.7},
+		},
+		{
+			name:       "matern52",
+			simil:      Matern52,
+			dense:      scaled{matern52},
+			x:          x1,
+			z:          z1,
+			thetaSimil: []float64{0.8, 1.2},
+		},
+		{
+			name:       "normal2d",
+			simil:      Normal,
+			dense:      scaled{normalCov},
+			x:          x2,
+			z:          z2,
+			thetaSimil: []float64{1.2, 0.9},
+		},
+	} {
+		thetaNoise := []float64{0.3}
+		ndim := len(c.x[0])
+		rf := &GP{
+			NDim:       ndim,
+			Simil:      c.simil,
+			Noise:      kernel.UniformNoise,
+			Features:   500,
+			ThetaSimil: append([]float64{}, c.thetaSimil...),
+			ThetaNoise: append([]float64{}, thetaNoise...),
+		}
+		dense := &gp.GP{
+			NDim:       ndim,
+			Simil:      c.dense,
+			Noise:      kernel.UniformNoise,
+			ThetaSimil: append([]float64{}, c.thetaSimil...),
+			ThetaNoise: append([]float64{}, thetaNoise...),
+		}
+		if err := rf.Absorb(c.x, y); err != nil {
+			t.Fatalf("%s: absorb: %v", c.name, err)
+		}
+		if err := dense.Absorb(c.x, y); err != nil {
+			t.Fatalf("%s: dense absorb: %v", c.name, err)
+		}
+		// The approximation is stochastic, the tolerance is loose.
+		if math.Abs(rf.LML()-dense.LML()) > 0.1 {
+			t.Errorf("%s: wrong LML: got %f, want %f",
+				c.name, rf.LML(), dense.LML())
+		}
+
+		mu, sigma, err := rf.Produce(c.z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", c.name, err)
+		}
+		wmu, wsigma, err := dense.Produce(c.z)
+		if err != nil {
+			t.Fatalf("%s: dense produce: %v", c.name, err)
+		}
+		for i := range c.z {
+			if math.Abs(mu[i]-wmu[i]) > 0.1 {
+				t.Errorf("%s: wrong mu: got %v, want %v", c.name, mu, wmu)
+				break
+			}
+			if math.Abs(sigma[i]-wsigma[i]) > 0.1 {
+				t.Errorf("%s: wrong sigma: got %v, want %v",
+					c.name, sigma, wsigma)
+				break
+			}
+		}
+
+		// The gradient is exact for the approximate LML.
+		rf.Features = 50
+		theta := make([]float64, 0, len(c.thetaSimil)+len(thetaNoise))
+		for _, th := range append(c.thetaSimil, thetaNoise...) {
+			theta = append(theta, math.Log(th))
+		}
+		ll := rf.Observe(theta)
+		grad := rf.Gradient()
+		if len(grad) != len(theta) {
+			t.Fatalf("%s: wrong gradient size: got %d, want %d",
+				c.name, len(grad), len(theta))
+		}
+		for j := range theta {
+			theta0 := theta[j]
+			theta[j] += dx
+			llj := rf.Observe(theta)
+			dldx := (llj - ll) / dx
+			theta[j] = theta0
+			if math.Abs(grad[j]-dldx) > eps {
+				t.Errorf("%s: dl/dx%d mismatch: got %.4f, want %.4f",
+					c.name, j, grad[j], dldx)
+			}
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	x := [][]float64{{0.1}, {0.4}, {0.5}, {1.3}, {0.9}, {2.0}, {2.1}}
+	y := []float64{0.5, 1, 0.8, -0.2, 0.1, -1, -0.7}
+	rf := &GP{
+		NDim:       1,
+		Simil:      Matern52,
+		Noise:      kernel.UniformNoise,
+		ThetaSimil: []float64{1, 0.8},
+		ThetaNoise: []float64{0.01},
+	}
+	if err := rf.Absorb(x, y); err != nil {
+		t.Fatalf("absorb: %v", err)
+	}
+	mu, sigma, err := rf.Produce(x)
+	if err != nil {
+		t.Fatalf("produce: %v", err)
+	}
+	// Sample moments agree with the predictions.
+	rng := rand.New(rand.NewSource(1))
+	const nsamples = 2000
+	sum := make([]float64, len(x))
+	sum2 := make([]float64, len(x))
+	for s := 0; s != nsamples; s++ {
+		f := rf.Sample(rng)
+		for i := range x {
+			fx := f(x[i])
+			sum[i] += fx
+			sum2[i] += fx * fx
+		}
+	}
+	for i := range x {
+		mean := sum[i] / nsamples
+		std := math.Sqrt(sum2[i]/nsamples - mean*mean)
+		if math.Abs(mean-mu[i]) > 4*sigma[i]/math.Sqrt(nsamples) {
+			t.Errorf("wrong sample mean at %v: got %.4f, want %.4f",
+				x[i], mean, mu[i])
+		}
+		if math.Abs(std-sigma[i]) > 0.1*sigma[i] {
+			t.Errorf("wrong sample std at %v: got %.4f, want %.4f",
+				x[i], std, sigma[i])
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	// The random feature GPs and the dense ones share the tape
+	// of the noise kernel.
+	x := [][]float64{{0.1}, {0.4}, {0.5}, {1.3}, {0.9}, {2.0}, {2.1}}
+	y := []float64{0.5, 1, 0.8, -0.2, 0.1, -1, -0.7}
+	thetaSimil, thetaNoise := []float64{1.5, 0.7}, []float64{0.3}
+	lml := func(dense bool) float64 {
+		if dense {
+			g := &gp.GP{
+				NDim:       1,
+				Simil:      scaled{matern32},
+				Noise:      kernel.UniformNoise,
+				ThetaSimil: thetaSimil,
+				ThetaNoise: thetaNoise,
+			}
+			if err := g.Absorb(x, y); err != nil {
+				t.Error(err)
+			}
+			return g.LML()
+		}
+		rf := &GP{NDim: 1, Simil: Matern32, Noise: kernel.UniformNoise,
+			X: x, Y: y}
+		var theta []float64
+		for _, th := range append(thetaSimil, thetaNoise...) {
+			theta = append(theta, math.Log(th))
+		}
+		return rf.Observe(theta)
+	}
+	// The random features only approximate the dense process.
+	want := map[bool]float64{false: lml(false), true: lml(true)}
+
+	var wg sync.WaitGroup
+	for i := 0; i != 6; i++ {
+		wg.Add(1)
+		go func(dense bool) {
+			defer wg.Done()
+			for j := 0; j != 5; j++ {
+				if got := lml(dense); got != want[dense] {
+					t.Errorf("dense=%v: wrong LML: got %f, want %f",
+						dense, got, want[dense])
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
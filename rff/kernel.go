@@ -0,0 +1,76 @@
+package rff
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Type Kernel is a stationary kernel given by its spectral
+// density, with two parameters, the output scale c and the
+// length scale l. The frequencies are drawn from the spectral
+// density for the unit length scale, and divided by l.
+type Kernel interface {
+	NTheta() int
+	Names() []string
+	// Frequency draws a frequency for the unit length scale.
+	Frequency(rng *rand.Rand, ndim int) []float64
+}
+
+// Type normal is the normal kernel type, corresponding to
+// c*kernel.Normal. The spectral density is normal.
+type normal struct{}
+
+// Singleton for the normal kernel
+var Normal normal
+
+func (normal) NTheta() int { return 2 }
+
+func (normal) Names() []string { return []string{"c", "l"} }
+
+func (normal) String() string { return "Normal" }
+
+func (normal) Frequency(rng *rand.Rand, ndim int) []float64 {
+	omega := make([]float64, ndim)
+	for i := range omega {
+		omega[i] = rng.NormFloat64()
+	}
+	return omega
+}
+
+// Type matern is the Matern kernel type, with nu = dof/2:
+//   c (1 + √3 d) exp(-√3 d) for nu=3/2,
+//   c (1 + √5 d + 5/3 d^2) exp(-√5 d) for nu=5/2,
+// where d = |xa - xb|/l. The spectral density is Student's t
+// with dof degrees of freedom.
+type matern struct {
+	dof int
+}
+
+// Singletons for Matern kernels
+var (
+	Matern32 = matern{3}
+	Matern52 = matern{5}
+)
+
+func (matern) NTheta() int { return 2 }
+
+func (matern) Names() []string { return []string{"c", "l"} }
+
+func (k matern) String() string {
+	return map[int]string{3: "Matern32", 5: "Matern52"}[k.dof]
+}
+
+func (k matern) Frequency(rng *rand.Rand, ndim int) []float64 {
+	// t = z/√(g/dof), g ~ χ²(dof)
+	g := 0.
+	for i := 0; i != k.dof; i++ {
+		z := rng.NormFloat64()
+		g += z * z
+	}
+	s := 1 / math.Sqrt(g/float64(k.dof))
+	omega := Normal.Frequency(rng, ndim)
+	for i := range omega {
+		omega[i] *= s
+	}
+	return omega
+}
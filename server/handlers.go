@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Type Error is the body of an error response.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// reply writes v in JSON with the status. v is encoded before
+// the status is written, since values which are not finite
+// cannot be encoded.
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(Error{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// fail writes the error with the status.
+func fail(w http.ResponseWriter, status int, err error) {
+	reply(w, status, Error{Error: err.Error()})
+}
+
+// decode decodes the body of the request into v; unknown fields
+// are errors.
+func decode(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("malformed request: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) handleModel(w http.ResponseWriter, r *http.Request) {
+	reply(w, http.StatusOK, s.Metadata())
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s.current().file.Write(w)
+}
+
+func (s *Server) handlePredict(w http.ResponseWriter, r *http.Request) {
+	var req PredictRequest
+	if err := decode(r, &req); err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	resp, err := s.Predict(&req)
+	if err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	reply(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleAbsorb(w http.ResponseWriter, r *http.Request) {
+	var req AbsorbRequest
+	if err := decode(r, &req); err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	md, err := s.Absorb(&req)
+	if err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	reply(w, http.StatusOK, md)
+}
+
+func (s *Server) handleRefit(w http.ResponseWriter, r *http.Request) {
+	md, err := s.Refit()
+	switch err {
+	case nil:
+		reply(w, http.StatusAccepted, md)
+	case ErrRefitting, ErrNoObservations:
+		fail(w, http.StatusConflict, err)
+	default:
+		fail(w, http.StatusInternalServerError, err)
+	}
+}
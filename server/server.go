@@ -0,0 +1,355 @@
+// Package server serves a fitted GP (see package gpfile) over
+// HTTP, in JSON. The endpoints are:
+//   GET  /model    the metadata of the model, see Metadata
+//   GET  /file     the model as a file, to be saved
+//   POST /predict  the predictive means and variances, and the
+//                  covariance on request, see PredictRequest
+//   POST /absorb   absorbs new observations, see AbsorbRequest
+//   POST /refit    starts refitting the hyperparameters to all
+//                  observations in the background
+// Predictions are served concurrently with absorptions and refits:
+// the model is replaced by a new one when an absorption or a
+// refit is complete, and a prediction uses the model current
+// when the prediction started.
+package server
+
+import (
+	"bitbucket.org/dtolpin/gogp/backtest"
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/gpfile"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// Type Options are the options of a server.
+type Options struct {
+	// Fit refits the hyperparameters, backtest.LBFGS by default;
+	// the fit starts from the current hyperparameters.
+	Fit backtest.Fit
+	// Seed of the random sources of the fits; the random source
+	// of a fit is seeded by Seed plus the number of refits
+	// before.
+	Seed int64
+	// Parallel and Workers are assigned to the GPs, see gp.GP.
+	Parallel bool
+	Workers  int
+}
+
+// Type Server is an HTTP handler serving a GP.
+type Server struct {
+	opts Options
+	mux  *http.ServeMux
+	// update serializes the replacements of the model
+	update sync.Mutex
+	// mutex guards the fields below
+	mutex     sync.RWMutex
+	model     *model
+	refitting bool   // a refit is in progress
+	refits    int    // number of completed refits
+	refitErr  string // of the last refit
+	wg        sync.WaitGroup
+}
+
+// Type model is a version of the model. The GP is not modified
+// once the model is created, but calls of the kernels are not
+// thread-safe, hence predictions by the same model are
+// serialized.
+type model struct {
+	file    *gpfile.File
+	gp      *gp.GP
+	version int
+	mutex   sync.Mutex
+}
+
+// New returns a server of the GP in file f with the options.
+func New(f *gpfile.File, opts Options) (*Server, error) {
+	s := &Server{opts: opts}
+	g, err := s.newGP(f)
+	if err != nil {
+		return nil, err
+	}
+	s.model = &model{file: f, gp: g}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /model", s.handleModel)
+	s.mux.HandleFunc("GET /file", s.handleFile)
+	s.mux.HandleFunc("POST /predict", s.handlePredict)
+	s.mux.HandleFunc("POST /absorb", s.handleAbsorb)
+	s.mux.HandleFunc("POST /refit", s.handleRefit)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Wait waits for the refit in progress, if any, to complete.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// newGP returns the GP of file f, with the options of the server.
+func (s *Server) newGP(f *gpfile.File) (*gp.GP, error) {
+	g, err := f.GP()
+	if err != nil {
+		return nil, err
+	}
+	g.Parallel, g.Workers = s.opts.Parallel, s.opts.Workers
+	return g, nil
+}
+
+// current returns the current model.
+func (s *Server) current() *model {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.model
+}
+
+// replace makes the GP of file f the current model; the caller
+// must hold the update lock.
+func (s *Server) replace(f *gpfile.File, g *gp.GP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.model = &model{file: f, gp: g, version: s.model.version + 1}
+}
+
+// Type Metadata describes the model.
+type Metadata struct {
+	Kernel     string             `json:"kernel"`
+	NDim       int                `json:"ndim"`
+	Names      []string           `json:"names"`  // of the hyperparameters
+	Params     map[string]float64 `json:"params"` // by name
+	N          int                `json:"n"`      // number of observations
+	Mean       float64            `json:"mean"`   // of the normalization
+	Std        float64            `json:"std"`    // of the outputs
+	LML        float64            `json:"lml"`    // of the normalized outputs
+	Version    int                `json:"version"`
+	Refitting  bool               `json:"refitting"`
+	Refits     int                `json:"refits"`
+	RefitError string             `json:"refit_error,omitempty"`
+}
+
+// Metadata returns the metadata of the current model.
+func (s *Server) Metadata() *Metadata {
+	s.mutex.RLock()
+	m := s.model
+	md := &Metadata{
+		Version:    m.version,
+		Refitting:  s.refitting,
+		Refits:     s.refits,
+		RefitError: s.refitErr,
+	}
+	s.mutex.RUnlock()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	md.Kernel = m.file.Kernel
+	md.NDim = m.file.NDim
+	md.Names = m.gp.Names()
+	md.Params = m.gp.Params()
+	md.N = len(m.file.X)
+	md.Mean, md.Std = m.file.Mean, m.file.Std
+	md.LML = m.gp.LML()
+	return md
+}
+
+// Type PredictRequest are the inputs to predict at; when Cov is
+// true, the covariance of the predictions is returned as well.
+type PredictRequest struct {
+	X   [][]float64 `json:"x"`
+	Cov bool        `json:"cov,omitempty"`
+}
+
+// Type PredictResponse are the predictions, in the scale of the
+// observations. The variances and the covariance are of the
+// latent function values, without the noise.
+type PredictResponse struct {
+	Mean     []float64   `json:"mean"`
+	Variance []float64   `json:"variance"`
+	Cov      [][]float64 `json:"cov,omitempty"`
+	Version  int         `json:"version"` // of the model
+}
+
+// Predict returns the predictions of the current model.
+func (s *Server) Predict(req *PredictRequest) (*PredictResponse, error) {
+	m := s.current()
+	if err := checkInputs(req.X, m.file.NDim); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	resp := &PredictResponse{Version: m.version}
+	std := m.file.Std // zero if the outputs are not normalized
+	if std == 0 {
+		std = 1
+	}
+	if req.Cov {
+		mu, cov, err := m.gp.ProduceCov(req.X)
+		if err != nil {
+			return nil, err
+		}
+		resp.Mean = mu
+		resp.Variance = make([]float64, len(req.X))
+		resp.Cov = make([][]float64, len(req.X))
+		for i := range req.X {
+			resp.Cov[i] = make([]float64, len(req.X))
+			for j := range req.X {
+				resp.Cov[i][j] = cov.At(i, j) * std * std
+			}
+			resp.Variance[i] = resp.Cov[i][i]
+		}
+	} else {
+		mu, sigma, err := m.gp.Produce(req.X)
+		if err != nil {
+			return nil, err
+		}
+		resp.Mean = mu
+		resp.Variance = make([]float64, len(req.X))
+		for i := range sigma {
+			resp.Variance[i] = sigma[i] * sigma[i] * std * std
+		}
+	}
+	m.file.Denormalize(resp.Mean, nil)
+	return resp, nil
+}
+
+// Type AbsorbRequest are new observations.
+type AbsorbRequest struct {
+	X [][]float64 `json:"x"`
+	Y []float64   `json:"y"`
+}
+
+// Absorb adds the observations to the model, keeping the
+// hyperparameters and the normalization, and returns the
+// metadata of the new model.
+func (s *Server) Absorb(req *AbsorbRequest) (*Metadata, error) {
+	if err := s.absorb(req); err != nil {
+		return nil, err
+	}
+	return s.Metadata(), nil
+}
+
+func (s *Server) absorb(req *AbsorbRequest) error {
+	s.update.Lock()
+	defer s.update.Unlock()
+	m := s.current()
+	if err := checkInputs(req.X, m.file.NDim); err != nil {
+		return err
+	}
+	if len(req.X) != len(req.Y) {
+		return fmt.Errorf("%d inputs, %d outputs", len(req.X), len(req.Y))
+	}
+	f := *m.file
+	f.X = append(append([][]float64{}, f.X...), req.X...)
+	f.Y = append(append([]float64{}, f.Y...), req.Y...)
+	g, err := s.newGP(&f)
+	if err != nil {
+		return err
+	}
+	s.replace(&f, g)
+	return nil
+}
+
+// Errors of refits
+var (
+	ErrRefitting      = errors.New("a refit is in progress")
+	ErrNoObservations = errors.New("no observations to refit to")
+)
+
+// Refit starts refitting the hyperparameters to the observations
+// of the current model in the background, and returns the
+// metadata. Observations absorbed during the refit are kept in
+// the refitted model.
+func (s *Server) Refit() (*Metadata, error) {
+	s.mutex.Lock()
+	if s.refitting {
+		s.mutex.Unlock()
+		return nil, ErrRefitting
+	}
+	m := s.model
+	if len(m.file.X) == 0 {
+		s.mutex.Unlock()
+		return nil, ErrNoObservations
+	}
+	s.refitting = true
+	seed := s.opts.Seed + int64(s.refits)
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	go s.refit(m.file, seed)
+	return s.Metadata(), nil
+}
+
+// refit refits the hyperparameters to the observations in file f
+// and replaces the model.
+func (s *Server) refit(f *gpfile.File, seed int64) {
+	defer s.wg.Done()
+	err := func() error {
+		// The GP is fit on its own, and the predictions are
+		// served by the current model meanwhile.
+		g, err := s.newGP(f)
+		if err != nil {
+			return err
+		}
+		ts := g.Transforms()
+		theta := append(append([]float64{}, g.ThetaSimil...), g.ThetaNoise...)
+		for i := range theta {
+			theta[i] = ts[i].Backward(theta[i])
+		}
+		fit := s.opts.Fit
+		if fit == nil {
+			fit = backtest.LBFGS
+		}
+		// An error of the fit is reported, but the fitted
+		// hyperparameters are used unless they are not finite.
+		_, fitErr := fit(g, theta, rand.New(rand.NewSource(seed)))
+		for _, v := range append(append([]float64{}, g.ThetaSimil...),
+			g.ThetaNoise...) {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("hyperparameters not finite: %v", fitErr)
+			}
+		}
+
+		s.update.Lock()
+		defer s.update.Unlock()
+		nf := *s.current().file
+		nf.ThetaSimil = append([]float64{}, g.ThetaSimil...)
+		nf.ThetaNoise = append([]float64{}, g.ThetaNoise...)
+		ng, err := s.newGP(&nf)
+		if err != nil {
+			return err
+		}
+		s.replace(&nf, ng)
+		return fitErr
+	}()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refitting = false
+	s.refits++
+	s.refitErr = ""
+	if err != nil {
+		s.refitErr = err.Error()
+	}
+}
+
+// checkInputs checks that there are inputs, and that the inputs
+// have ndim dimensions.
+func checkInputs(x [][]float64, ndim int) error {
+	if len(x) == 0 {
+		return errors.New("no inputs")
+	}
+	for i := range x {
+		if len(x[i]) != ndim {
+			return fmt.Errorf("input %d: %d dimensions, want %d",
+				i, len(x[i]), ndim)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/gpfile"
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	x = [][]float64{{0}, {0.5}, {1.5}, {2}, {3}}
+	y = []float64{10, 12, 11, 9, 8}
+	z = [][]float64{{-1}, {0.25}, {2.5}, {4}}
+)
+
+const kernel = "c*Matern52(l) + UniformNoise(s)"
+
+// file returns the file of a GP fit to the observations with
+// fixed hyperparameters.
+func file() *gpfile.File {
+	return &gpfile.File{
+		Kernel:     kernel,
+		NDim:       1,
+		ThetaSimil: []float64{1.2, 0.8},
+		ThetaNoise: []float64{0.05},
+		X:          x,
+		Y:          y,
+		Mean:       10,
+		Std:        2,
+	}
+}
+
+// call calls the service and decodes the response into v, and
+// returns the status, or 0 on failure; call is also called by
+// concurrent clients, hence failures are errors rather than
+// fatal.
+func call(
+	t *testing.T,
+	ts *httptest.Server,
+	method, path string,
+	req, v interface{},
+) int {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			t.Error(err)
+			return 0
+		}
+	}
+	r, err := http.NewRequest(method, ts.URL+path, &body)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	resp, err := ts.Client().Do(r)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Errorf("%s %s: %v", method, path, err)
+			return 0
+		}
+	}
+	return resp.StatusCode
+}
+
+func near(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9*(1+math.Abs(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// predictions returns the predictions at z of the GP of file f.
+func predictions(t *testing.T, f *gpfile.File) (mu, variance []float64) {
+	g, err := f.GP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu, sigma, err := g.Produce(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Denormalize(mu, sigma)
+	variance = make([]float64, len(sigma))
+	for i := range sigma {
+		variance[i] = sigma[i] * sigma[i]
+	}
+	return mu, variance
+}
+
+func TestPredict(t *testing.T) {
+	s, err := New(file(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	mu, variance := predictions(t, file())
+	var resp PredictResponse
+	if status := call(t, ts, "POST", "/predict",
+		PredictRequest{X: z}, &resp); status != http.StatusOK {
+		t.Fatalf("wrong status: %d", status)
+	}
+	if !near(resp.Mean, mu) || !near(resp.Variance, variance) {
+		t.Errorf("wrong predictions: got %v, %v, want %v, %v",
+			resp.Mean, resp.Variance, mu, variance)
+	}
+	if resp.Cov != nil {
+		t.Errorf("covariance not requested: %v", resp.Cov)
+	}
+
+	resp = PredictResponse{}
+	call(t, ts, "POST", "/predict", PredictRequest{X: z, Cov: true}, &resp)
+	if !near(resp.Mean, mu) || !near(resp.Variance, variance) {
+		t.Errorf("wrong predictions with covariance: got %v, %v, "+
+			"want %v, %v", resp.Mean, resp.Variance, mu, variance)
+	}
+	if len(resp.Cov) != len(z) {
+		t.Fatalf("wrong covariance: %v", resp.Cov)
+	}
+	for i := range z {
+		for j := range z {
+			if math.Abs(resp.Cov[i][j]-resp.Cov[j][i]) > 1e-9 {
+				t.Errorf("covariance not symmetric: %v", resp.Cov)
+			}
+		}
+	}
+
+	var md Metadata
+	if status := call(t, ts, "GET", "/model", nil, &md); status != http.StatusOK {
+		t.Fatalf("wrong status: %d", status)
+	}
+	if md.Kernel != kernel || md.N != len(x) || md.Version != 0 ||
+		len(md.Names) != 3 || md.Params["simil.c"] != 1.2 {
+		t.Errorf("wrong metadata: %+v", md)
+	}
+}
+
+func TestAbsorb(t *testing.T) {
+	s, err := New(file(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req := AbsorbRequest{X: [][]float64{{3.5}, {4.5}}, Y: []float64{7, 9}}
+	var md Metadata
+	if status := call(t, ts, "POST", "/absorb", req, &md); status != http.StatusOK {
+		t.Fatalf("wrong status: %d", status)
+	}
+	if md.N != len(x)+2 || md.Version != 1 {
+		t.Errorf("wrong metadata: %+v", md)
+	}
+
+	f := file()
+	f.X = append(append([][]float64{}, x...), req.X...)
+	f.Y = append(append([]float64{}, y...), req.Y...)
+	mu, variance := predictions(t, f)
+	var resp PredictResponse
+	call(t, ts, "POST", "/predict", PredictRequest{X: z}, &resp)
+	if !near(resp.Mean, mu) || !near(resp.Variance, variance) {
+		t.Errorf("wrong predictions: got %v, %v, want %v, %v",
+			resp.Mean, resp.Variance, mu, variance)
+	}
+
+	// The file holds all observations, and is read back.
+	r, err := ts.Client().Get(ts.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	f, err = gpfile.Read(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.X) != len(x)+2 || f.Y[len(y)+1] != 9 {
+		t.Errorf("wrong file: %v, %v", f.X, f.Y)
+	}
+}
+
+func TestRefit(t *testing.T) {
+	// The fit waits for the signal and sets the hyperparameters
+	// to fixed values.
+	start := make(chan struct{})
+	theta := []float64{0.5, 0.1, -3}
+	s, err := New(file(), Options{
+		Fit: func(g *gp.GP, _ []float64, _ *rand.Rand) ([]float64, error) {
+			<-start
+			g.Observe(theta)
+			return theta, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var md Metadata
+	if status := call(t, ts, "POST", "/refit", nil, &md); status != http.StatusAccepted {
+		t.Fatalf("wrong status: %d", status)
+	}
+	if !md.Refitting {
+		t.Errorf("not refitting: %+v", md)
+	}
+	if status := call(t, ts, "POST", "/refit", nil,
+		&Error{}); status != http.StatusConflict {
+		t.Errorf("wrong status of a second refit: %d", status)
+	}
+
+	// Predictions are served, and observations absorbed, during
+	// the refit.
+	mu, _ := predictions(t, file())
+	var wg sync.WaitGroup
+	for i := 0; i != 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp PredictResponse
+			call(t, ts, "POST", "/predict", PredictRequest{X: z}, &resp)
+			if resp.Version == 0 && !near(resp.Mean, mu) {
+				t.Errorf("wrong predictions during refit: got %v, want %v",
+					resp.Mean, mu)
+			}
+		}()
+	}
+	req := AbsorbRequest{X: [][]float64{{3.5}}, Y: []float64{7}}
+	call(t, ts, "POST", "/absorb", req, &md)
+	wg.Wait()
+	close(start)
+	s.Wait()
+
+	call(t, ts, "GET", "/model", nil, &md)
+	if md.Refitting || md.Refits != 1 || md.RefitError != "" ||
+		md.N != len(x)+1 || md.Version != 2 {
+		t.Errorf("wrong metadata after refit: %+v", md)
+	}
+	if c := math.Exp(theta[0]); math.Abs(md.Params["simil.c"]-c) > 1e-12 {
+		t.Errorf("wrong hyperparameters: got %v, want simil.c=%v",
+			md.Params, c)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	s, err := New(file(), Options{Parallel: true, Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The options apply to the first model and to the models
+	// replacing it.
+	if g := s.current().gp; !g.Parallel || g.Workers != 3 {
+		t.Errorf("wrong options of the first model: parallel=%v, workers=%d",
+			g.Parallel, g.Workers)
+	}
+	if _, err := s.Absorb(&AbsorbRequest{
+		X: [][]float64{{3.5}}, Y: []float64{7}}); err != nil {
+		t.Fatal(err)
+	}
+	if g := s.current().gp; !g.Parallel || g.Workers != 3 {
+		t.Errorf("wrong options after absorption: parallel=%v, workers=%d",
+			g.Parallel, g.Workers)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, err := New(file(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for _, c := range []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"malformed", "POST", "/predict", `{"x": [1]}`,
+			http.StatusBadRequest},
+		{"unknown field", "POST", "/predict", `{"z": [[1]]}`,
+			http.StatusBadRequest},
+		{"dimensions", "POST", "/predict", `{"x": [[1, 2]]}`,
+			http.StatusBadRequest},
+		{"outputs", "POST", "/absorb", `{"x": [[1]], "y": [1, 2]}`,
+			http.StatusBadRequest},
+		{"no inputs", "POST", "/predict", `{"x": []}`,
+			http.StatusBadRequest},
+		{"no inputs with covariance", "POST", "/predict",
+			`{"x": [], "cov": true}`, http.StatusBadRequest},
+		{"no observations", "POST", "/absorb", `{"x": [], "y": []}`,
+			http.StatusBadRequest},
+		{"method", "GET", "/predict", "", http.StatusMethodNotAllowed},
+		{"path", "GET", "/forecast", "", http.StatusNotFound},
+	} {
+		r, err := http.NewRequest(c.method, ts.URL+c.path,
+			strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ts.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: wrong status: got %d, want %d",
+				c.name, resp.StatusCode, c.status)
+		}
+	}
+
+	// Nothing to refit to
+	s, err = New(&gpfile.File{Kernel: kernel, NDim: 1,
+		ThetaSimil: []float64{1, 1}, ThetaNoise: []float64{0.1}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refit(); err != ErrNoObservations {
+		t.Errorf("wrong error: got %v, want %v", err, ErrNoObservations)
+	}
+}
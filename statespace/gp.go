@@ -0,0 +1,518 @@
+// Package statespace implements Gaussian process regression
+// on a single input, such as time, in O(n) by Kalman
+// filtering and Rauch-Tung-Striebel smoothing, for kernels
+// with state-space representations. The GP exposes the same
+// API as gp.GP and can be used in its place.
+package statespace
+
+import (
+	gogp "bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"bitbucket.org/dtolpin/infergo/model"
+	"fmt"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"sort"
+)
+
+// Type GP is the state-space implementation of GP. Inputs
+// are one-dimensional and need not be sorted.
+type GP struct {
+	// Configuration
+	Simil Kernel      // similarity kernel
+	Noise gogp.Kernel // noise kernel
+
+	// Data
+	ThetaSimil, ThetaNoise []float64   // kernel parameters
+	X                      [][]float64 // inputs
+	Y                      []float64   // outputs
+
+	// Derivatives of hyperparameter transforms, for the chain rule
+	dThetaSimil, dThetaNoise []float64
+
+	// Cached computations
+	lml  float64   // log marginal likelihood
+	grad []float64 // gradient of the log marginal likelihood
+}
+
+func (gp *GP) defaults() {
+	gp.Noise = gogp.DefaultNoise(gp.Noise)
+	gp.ThetaSimil = gogp.DefaultTheta(gp.Simil, gp.ThetaSimil)
+	gp.ThetaNoise = gogp.DefaultTheta(gp.Noise, gp.ThetaNoise)
+}
+
+// Names returns the names of the hyperparameters, in the order
+// of the arguments of Observe, as GP.Names in package gp.
+func (gp *GP) Names() []string {
+	gp.defaults()
+	var all []string
+	for _, name := range gp.Simil.Names() {
+		all = append(all, "simil."+name)
+	}
+	for _, name := range gogp.KernelNames(gp.Noise) {
+		all = append(all, "noise."+name)
+	}
+	return all
+}
+
+// Transforms returns the transforms of the hyperparameters,
+// in the order of the arguments of Observe.
+func (gp *GP) Transforms() []kernel.Transform {
+	gp.defaults()
+	return append(gogp.KernelTransforms(gp.Simil), gogp.KernelTransforms(gp.Noise)...)
+}
+
+// Absorb absorbs observations into the process.
+func (gp *GP) Absorb(x [][]float64, y []float64) (err error) {
+	// Set the defaults
+	gp.defaults()
+	// Remember the inputs
+	gp.X, gp.Y = x, y
+	// When Absorb is called directly, the gradient is not computed
+	return gp.absorb(withoutGradient)
+}
+
+// LML returns the log marginal likelihood of the kernel given
+// the absorbed observations.
+func (gp *GP) LML() float64 {
+	return gp.lml
+}
+
+const (
+	withoutGradient = false
+	withGradient    = true
+)
+
+// Type step is the discretized state-space model for a time
+// step, along with the derivatives by the parameters.
+type step struct {
+	A, Q   *mat.Dense
+	dA, dQ []*mat.Dense
+}
+
+// Type sde is the state-space model of the similarity kernel,
+// with the discretizations memoized by the time step.
+type sde struct {
+	F, Pinf *mat.Dense
+	H       *mat.VecDense
+	dF      []*mat.Dense
+	dPinf   []*mat.Dense
+	steps   map[float64]*step
+}
+
+// sde returns the state-space model of the similarity kernel.
+// The parameters of the kernel must be positive and the model
+// finite, otherwise the matrix exponentials of the
+// discretization never return; an error is returned instead.
+func (gp *GP) sde() (*sde, error) {
+	for i, theta := range gp.ThetaSimil {
+		if !(theta > 0) {
+			return nil, fmt.Errorf("parameter %d of %v is %v, must be positive",
+				i, gp.Simil, theta)
+		}
+	}
+	F, Pinf, H, dF, dPinf := gp.Simil.SDE(gp.ThetaSimil)
+	for _, a := range append([]*mat.Dense{F, Pinf}, append(dF, dPinf...)...) {
+		if !finite(a.RawMatrix().Data) {
+			return nil, fmt.Errorf("model of %v not finite for parameters %v",
+				gp.Simil, gp.ThetaSimil)
+		}
+	}
+	return &sde{
+		F:     F,
+		Pinf:  Pinf,
+		H:     mat.NewVecDense(len(H), H),
+		dF:    dF,
+		dPinf: dPinf,
+		steps: make(map[float64]*step),
+	}, nil
+}
+
+// finite returns true if all values are finite.
+func finite(values []float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// step discretizes the model for time step dt:
+//   A = exp(F dt), Q = Pinf - A Pinf A^⊤.
+// The derivatives of A are the upper right blocks of the
+// exponents of the block matrices [F dF; 0 F] dt.
+func (m *sde) step(dt float64, withGrad bool) *step {
+	if s, ok := m.steps[dt]; ok && (!withGrad || s.dA != nil) {
+		return s
+	}
+
+	d, _ := m.F.Dims()
+	s := &step{
+		A: mat.NewDense(d, d, nil),
+		Q: mat.NewDense(d, d, nil),
+	}
+	var Fdt mat.Dense
+	Fdt.Scale(dt, m.F)
+	s.A.Exp(&Fdt)
+	s.Q.Product(s.A, m.Pinf, s.A.T())
+	s.Q.Sub(m.Pinf, s.Q)
+
+	if withGrad {
+		B := mat.NewDense(2*d, 2*d, nil)
+		var eB, r mat.Dense
+		for i := range m.dF {
+			B.Slice(0, d, 0, d).(*mat.Dense).Copy(&Fdt)
+			B.Slice(d, 2*d, d, 2*d).(*mat.Dense).Copy(&Fdt)
+			B.Slice(0, d, d, 2*d).(*mat.Dense).Scale(dt, m.dF[i])
+			eB.Exp(B)
+			dA := mat.DenseCopyOf(eB.Slice(0, d, d, 2*d))
+			// dQ = dPinf - dA Pinf A^⊤ - A dPinf A^⊤ - A Pinf dA^⊤
+			dQ := mat.DenseCopyOf(m.dPinf[i])
+			r.Product(dA, m.Pinf, s.A.T())
+			dQ.Sub(dQ, &r)
+			dQ.Sub(dQ, r.T())
+			r.Product(s.A, m.dPinf[i], s.A.T())
+			dQ.Sub(dQ, &r)
+			s.dA = append(s.dA, dA)
+			s.dQ = append(s.dQ, dQ)
+		}
+	}
+
+	m.steps[dt] = s
+	return s
+}
+
+// order returns the indices of inputs sorted by the input.
+func order(x [][]float64) []int {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return x[idx[i]][0] < x[idx[j]][0]
+	})
+	return idx
+}
+
+// noise computes the noise variance at input x and, if
+// requested, the gradient of the variance by the parameters.
+func (gp *GP) noise(x []float64, withGrad bool) (float64, []float64) {
+	nargs := make([]float64, gp.Noise.NTheta()+len(x))
+	copy(nargs, gp.ThetaNoise)
+	copy(nargs[gp.Noise.NTheta():], x)
+	r, ngrad := gogp.Call(gp.Noise, nargs, withGrad)
+	if !withGrad {
+		return r, nil
+	}
+	dr := make([]float64, gp.Noise.NTheta())
+	for i := range dr {
+		dr[i] = ngrad[i] * gp.dThetaNoise[i]
+	}
+	return r, dr
+}
+
+// absorb runs the Kalman filter through the observations,
+// computing the log marginal likelihood and, optionally, its
+// gradient by the sensitivity equations.
+func (gp *GP) absorb(withGrad bool) error {
+	ntheta := gp.Simil.NTheta() + gp.Noise.NTheta()
+	gp.lml = 0
+	gp.grad = make([]float64, ntheta)
+	if len(gp.X) == 0 {
+		return nil
+	}
+
+	m, err := gp.sde()
+	if err != nil {
+		return err
+	}
+	d := m.H.Len()
+
+	// State mean and covariance, and their derivatives
+	s := mat.NewVecDense(d, nil)
+	P := mat.DenseCopyOf(m.Pinf)
+	var ds []*mat.VecDense
+	var dP []*mat.Dense
+	if withGrad {
+		for k := 0; k != ntheta; k++ {
+			ds = append(ds, mat.NewVecDense(d, nil))
+			if k < len(m.dPinf) {
+				dP = append(dP, mat.DenseCopyOf(m.dPinf[k]))
+			} else {
+				dP = append(dP, mat.NewDense(d, d, nil))
+			}
+		}
+	}
+
+	// registers
+	var r mat.Dense
+	var rv mat.VecDense
+	PHt := mat.NewVecDense(d, nil)
+	dPHt := mat.NewVecDense(d, nil)
+	dK := mat.NewVecDense(d, nil)
+
+	idx := order(gp.X)
+	for ii, i := range idx {
+		// Predict
+		if ii > 0 {
+			st := m.step(gp.X[i][0]-gp.X[idx[ii-1]][0], withGrad)
+			if withGrad {
+				for k := range ds {
+					// ds = dA s + A ds
+					rv.MulVec(st.A, ds[k])
+					ds[k].CopyVec(&rv)
+					// dP = dA P A^⊤ + A P dA^⊤ + A dP A^⊤ + dQ
+					r.Product(st.A, dP[k], st.A.T())
+					dP[k].Copy(&r)
+					if k < len(st.dA) {
+						rv.MulVec(st.dA[k], s)
+						ds[k].AddVec(ds[k], &rv)
+						r.Product(st.dA[k], P, st.A.T())
+						dP[k].Add(dP[k], &r)
+						dP[k].Add(dP[k], r.T())
+						dP[k].Add(dP[k], st.dQ[k])
+					}
+				}
+			}
+			rv.MulVec(st.A, s)
+			s.CopyVec(&rv)
+			r.Product(st.A, P, st.A.T())
+			P.Add(&r, st.Q)
+		}
+
+		// Update
+		R, dR := gp.noise(gp.X[i], withGrad)
+		PHt.MulVec(P, m.H)
+		v := gp.Y[i] - mat.Dot(m.H, s)
+		S := mat.Dot(m.H, PHt) + R
+		if S <= 0 {
+			return fmt.Errorf("non-positive innovation variance %g at %v",
+				S, gp.X[i])
+		}
+		gp.lml -= 0.5 * (math.Log(2*math.Pi*S) + v*v/S)
+
+		if withGrad {
+			for k := range ds {
+				dv := -mat.Dot(m.H, ds[k])
+				dPHt.MulVec(dP[k], m.H)
+				dS := mat.Dot(m.H, dPHt)
+				if k >= gp.Simil.NTheta() {
+					dS += dR[k-gp.Simil.NTheta()]
+				}
+				gp.grad[k] -= 0.5 * (dS/S + 2*v*dv/S - v*v*dS/(S*S))
+
+				// dK = (dP H^⊤ - K dS)/S, K = P H^⊤/S
+				dK.AddScaledVec(dPHt, -dS/S, PHt)
+				dK.ScaleVec(1/S, dK)
+				// ds += dK v + K dv
+				ds[k].AddScaledVec(ds[k], v, dK)
+				ds[k].AddScaledVec(ds[k], dv/S, PHt)
+				// dP -= (dP H^⊤ (P H^⊤)^⊤ + P H^⊤ (dP H^⊤)^⊤)/S
+				//       - P H^⊤ (P H^⊤)^⊤ dS/S^2
+				r.Outer(1/S, dPHt, PHt)
+				dP[k].Sub(dP[k], &r)
+				dP[k].Sub(dP[k], r.T())
+				r.Outer(dS/(S*S), PHt, PHt)
+				dP[k].Add(dP[k], &r)
+			}
+		}
+
+		s.AddScaledVec(s, v/S, PHt)
+		r.Outer(1/S, PHt, PHt)
+		P.Sub(P, &r)
+	}
+
+	if withGrad {
+		// Chain rule for the similarity kernel parameters; the
+		// derivatives by the noise parameters already include
+		// the transforms.
+		for k := 0; k != gp.Simil.NTheta(); k++ {
+			gp.grad[k] *= gp.dThetaSimil[k]
+		}
+	}
+
+	return nil
+}
+
+// Produce computes predictions by running the Kalman filter
+// and the Rauch-Tung-Striebel smoother through the observations
+// and the inputs. Depends on ThetaSimil, ThetaNoise, X, Y.
+func (gp *GP) Produce(x [][]float64) (
+	mu, sigma []float64,
+	err error,
+) {
+	// Set the defaults
+	gp.defaults()
+	if len(x) == 0 {
+		return nil, nil, nil
+	}
+
+	// Observations and inputs are merged; observations come
+	// first at equal inputs.
+	all := append(append([][]float64{}, gp.X...), x...)
+	idx := order(all)
+
+	m, err := gp.sde()
+	if err != nil {
+		return nil, nil, err
+	}
+	d := m.H.Len()
+	n := len(idx)
+
+	// Predicted and filtered means and covariances, time steps
+	sp := make([]*mat.VecDense, n)
+	Pp := make([]*mat.Dense, n)
+	sf := make([]*mat.VecDense, n)
+	Pf := make([]*mat.Dense, n)
+	steps := make([]*step, n)
+
+	// Filter
+	s := mat.NewVecDense(d, nil)
+	P := mat.DenseCopyOf(m.Pinf)
+	PHt := mat.NewVecDense(d, nil)
+	var r mat.Dense
+	for ii, i := range idx {
+		if ii > 0 {
+			st := m.step(all[i][0]-all[idx[ii-1]][0], withoutGradient)
+			steps[ii] = st
+			sp[ii] = mat.NewVecDense(d, nil)
+			sp[ii].MulVec(st.A, s)
+			Pp[ii] = mat.NewDense(d, d, nil)
+			Pp[ii].Product(st.A, P, st.A.T())
+			Pp[ii].Add(Pp[ii], st.Q)
+		} else {
+			sp[ii] = s
+			Pp[ii] = P
+		}
+		s = mat.VecDenseCopyOf(sp[ii])
+		P = mat.DenseCopyOf(Pp[ii])
+		if i < len(gp.X) {
+			R, _ := gp.noise(gp.X[i], withoutGradient)
+			PHt.MulVec(P, m.H)
+			v := gp.Y[i] - mat.Dot(m.H, s)
+			S := mat.Dot(m.H, PHt) + R
+			s.AddScaledVec(s, v/S, PHt)
+			r.Outer(1/S, PHt, PHt)
+			P.Sub(P, &r)
+		}
+		sf[ii] = s
+		Pf[ii] = P
+	}
+
+	// Smoother
+	mu = make([]float64, len(x))
+	sigma = make([]float64, len(x))
+	ss := sf[n-1]
+	Ps := Pf[n-1]
+	var G, GT, ds mat.Dense
+	var dss mat.VecDense
+	for ii := n - 1; ; ii-- {
+		if i := idx[ii]; i >= len(gp.X) {
+			mu[i-len(gp.X)] = mat.Dot(m.H, ss)
+			PsHt := mat.NewVecDense(d, nil)
+			PsHt.MulVec(Ps, m.H)
+			sigma[i-len(gp.X)] = math.Sqrt(math.Max(mat.Dot(m.H, PsHt), 0))
+		}
+		if ii == 0 {
+			break
+		}
+		if all[idx[ii]][0] == all[idx[ii-1]][0] {
+			// Equal inputs, the smoothed state is the same
+			continue
+		}
+		// G = Pf A^⊤ Pp^-1, computed as G^⊤ = Pp^-1 A Pf
+		st := steps[ii]
+		r.Mul(st.A, Pf[ii-1])
+		if err := psolve(&GT, Pp[ii], &r); err != nil {
+			return nil, nil, err
+		}
+		G.CloneFrom(GT.T())
+		// ss = sf + G (ss - sp)
+		dss.SubVec(ss, sp[ii])
+		sn := mat.NewVecDense(d, nil)
+		sn.MulVec(&G, &dss)
+		sn.AddVec(sn, sf[ii-1])
+		ss = sn
+		// Ps = Pf + G (Ps - Pp) G^⊤
+		ds.Sub(Ps, Pp[ii])
+		Pn := mat.NewDense(d, d, nil)
+		Pn.Product(&G, &ds, &GT)
+		Pn.Add(Pn, Pf[ii-1])
+		Ps = Pn
+	}
+
+	return mu, sigma, nil
+}
+
+// psolve solves a x = b for a symmetric positive semidefinite
+// matrix a by the pseudo-inverse of a. Covariances of the
+// state may be ill-conditioned when some components of the
+// state have tiny variances, as high harmonics of the periodic
+// kernel; the components are then ignored.
+func psolve(x *mat.Dense, a *mat.Dense, b mat.Matrix) error {
+	d, _ := a.Dims()
+	sym := mat.NewSymDense(d, nil)
+	for i := 0; i != d; i++ {
+		for j := i; j != d; j++ {
+			sym.SetSym(i, j, 0.5*(a.At(i, j)+a.At(j, i)))
+		}
+	}
+	var eig mat.EigenSym
+	if !eig.Factorize(sym, true) {
+		return fmt.Errorf("EigenSym(%v)", mat.Formatted(a))
+	}
+	values := eig.Values(nil)
+	var vectors mat.Dense
+	eig.VectorsTo(&vectors)
+
+	// x = V diag(1/λ) V^⊤ b, for λ above the tolerance
+	tol := 1e-12 * floats.Max(values)
+	var vtb mat.Dense
+	vtb.Mul(vectors.T(), b)
+	for i, l := range values {
+		row := vtb.RawRowView(i)
+		for j := range row {
+			if l > tol {
+				row[j] /= l
+			} else {
+				row[j] = 0
+			}
+		}
+	}
+	x.Mul(&vectors, &vtb)
+	return nil
+}
+
+// Observe and Gradient implement Infergo's ElementalModel.
+
+// Observe computes log marginal likelihood of the parameters
+// given the observations. The argument is the concatenation of
+// transformed hyperparameters, as in gp.GP; the inputs must be
+// assigned to fields X, Y of gp.
+func (gp *GP) Observe(x []float64) float64 {
+	gp.defaults()
+	if len(x) != gp.Simil.NTheta()+gp.Noise.NTheta() {
+		panic("len(x)")
+	}
+
+	// Restore parameters from the unconstrained space,
+	// remembering derivatives of the transforms
+	gp.dThetaSimil = gogp.Restore(gogp.KernelTransforms(gp.Simil),
+		gp.ThetaSimil, gp.dThetaSimil, model.Shift(&x, gp.Simil.NTheta()))
+	gp.dThetaNoise = gogp.Restore(gogp.KernelTransforms(gp.Noise),
+		gp.ThetaNoise, gp.dThetaNoise, model.Shift(&x, gp.Noise.NTheta()))
+
+	if err := gp.absorb(withGradient); err != nil {
+		panic(err)
+	}
+
+	return gp.lml
+}
+
+// Gradient returns the gradient of the log marginal likelihood
+// computed by Observe.
+func (gp *GP) Gradient() []float64 {
+	return gp.grad
+}
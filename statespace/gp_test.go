@@ -0,0 +1,243 @@
+package statespace
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"math"
+	"sync"
+	"testing"
+)
+
+// Type scaled is the dense counterpart of a state-space Matern
+// kernel, the product of the kernel and the output scale.
+type scaled struct {
+	cov func(d float64) float64
+}
+
+func (s scaled) Observe(x []float64) float64 {
+	return x[0] * s.cov(math.Abs(x[2]-x[3])/x[1])
+}
+
+func matern32(d float64) float64 {
+	d *= math.Sqrt(3)
+	return (1 + d) * math.Exp(-d)
+}
+
+func matern52(d float64) float64 {
+	d *= math.Sqrt(5)
+	return (1 + d + d*d/3) * math.Exp(-d)
+}
+
+func (scaled) Gradient() []float64 { return nil }
+
+func (scaled) NTheta() int { return 2 }
+
+// Type sum is the dense counterpart of Sum of two Matern kernels.
+type sum [2]scaled
+
+func (s sum) Observe(x []float64) float64 {
+	return s[0].Observe([]float64{x[0], x[1], x[4], x[5]}) +
+		s[1].Observe([]float64{x[2], x[3], x[4], x[5]})
+}
+
+func (sum) Gradient() []float64 { return nil }
+
+func (sum) NTheta() int { return 4 }
+
+// Type quasiPeriodic is the dense counterpart of QuasiPeriodic.
+type quasiPeriodic struct{}
+
+func (quasiPeriodic) Observe(x []float64) float64 {
+	c, lp, p, lm, xa, xb := x[0], x[1], x[2], x[3], x[4], x[5]
+	d := math.Sin(math.Pi*math.Abs(xa-xb)/p) / lp
+	r := math.Sqrt(3) * math.Abs(xa-xb) / lm
+	return c * math.Exp(-2*d*d) * (1 + r) * math.Exp(-r)
+}
+
+func (quasiPeriodic) Gradient() []float64 { return nil }
+
+func (quasiPeriodic) NTheta() int { return 4 }
+
+// Difference and precision for numerical derivative
+const (
+	dx  = 1e-6
+	eps = 1e-4
+)
+
+func TestGP(t *testing.T) {
+	x := [][]float64{{0.1}, {0.4}, {0.5}, {1.3}, {0.9}, {2.0}, {2.1}}
+	y := []float64{0.5, 1, 0.8, -0.2, 0.1, -1, -0.7}
+	z := [][]float64{{-0.5}, {0.5}, {0.7}, {1.5}, {3}}
+	for _, c := range []struct {
+		name              string
+		simil             Kernel
+		dense             gp.Kernel
+		thetaSimil, theta []float64
+	}{
+		{
+			name:       "matern32",
+			simil:      Matern32{},
+			dense:      scaled{matern32},
+			thetaSimil: []float64{1.5, 0.7},
+		},
+		{
+			name:       "matern52",
+			simil:      Matern52{},
+			dense:      scaled{matern52},
+			thetaSimil: []float64{0.8, 1.2},
+		},
+		{
+			name:       "sum",
+			simil:      Sum{Matern32{}, Matern52{}},
+			dense:      sum{{matern32}, {matern52}},
+			thetaSimil: []float64{1.5, 0.7, 0.3, 2},
+		},
+		{
+			name:       "quasiperiodic",
+			simil:      QuasiPeriodic{Harmonics: 12},
+			dense:      quasiPeriodic{},
+			thetaSimil: []float64{1.2, 1.5, 0.9, 2.0},
+		},
+	} {
+		thetaNoise := []float64{0.3}
+		ss := &GP{
+			Simil:      c.simil,
+			Noise:      kernel.UniformNoise,
+			ThetaSimil: append([]float64{}, c.thetaSimil...),
+			ThetaNoise: append([]float64{}, thetaNoise...),
+		}
+		dense := &gp.GP{
+			NDim:       1,
+			Simil:      c.dense,
+			Noise:      kernel.UniformNoise,
+			ThetaSimil: append([]float64{}, c.thetaSimil...),
+			ThetaNoise: append([]float64{}, thetaNoise...),
+		}
+		if err := ss.Absorb(x, y); err != nil {
+			t.Fatalf("%s: absorb: %v", c.name, err)
+		}
+		if err := dense.Absorb(x, y); err != nil {
+			t.Fatalf("%s: dense absorb: %v", c.name, err)
+		}
+		if math.Abs(ss.LML()-dense.LML()) > 1e-6 {
+			t.Errorf("%s: wrong LML: got %f, want %f",
+				c.name, ss.LML(), dense.LML())
+		}
+
+		mu, sigma, err := ss.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: produce: %v", c.name, err)
+		}
+		wmu, wsigma, err := dense.Produce(z)
+		if err != nil {
+			t.Fatalf("%s: dense produce: %v", c.name, err)
+		}
+		for i := range z {
+			if math.Abs(mu[i]-wmu[i]) > 1e-6 {
+				t.Errorf("%s: wrong mu: got %v, want %v", c.name, mu, wmu)
+				break
+			}
+			if math.Abs(sigma[i]-wsigma[i]) > 1e-6 {
+				t.Errorf("%s: wrong sigma: got %v, want %v",
+					c.name, sigma, wsigma)
+				break
+			}
+		}
+
+		// Gradient
+		theta := make([]float64, 0, len(c.thetaSimil)+len(thetaNoise))
+		for _, th := range append(c.thetaSimil, thetaNoise...) {
+			theta = append(theta, math.Log(th))
+		}
+		ll := ss.Observe(theta)
+		grad := ss.Gradient()
+		if math.Abs(ll-dense.LML()) > 1e-6 {
+			t.Errorf("%s: wrong log-likelihood: got %f, want %f",
+				c.name, ll, dense.LML())
+		}
+		if len(grad) != len(theta) {
+			t.Fatalf("%s: wrong gradient size: got %d, want %d",
+				c.name, len(grad), len(theta))
+		}
+		for j := range theta {
+			theta0 := theta[j]
+			theta[j] += dx
+			llj := ss.Observe(theta)
+			dldx := (llj - ll) / dx
+			theta[j] = theta0
+			if math.Abs(grad[j]-dldx) > eps {
+				t.Errorf("%s: dl/dx%d mismatch: got %.4f, want %.4f",
+					c.name, j, grad[j], dldx)
+			}
+		}
+	}
+}
+
+func TestDegenerate(t *testing.T) {
+	x := [][]float64{{0.1}, {0.4}, {0.5}}
+	y := []float64{0.5, 1, 0.8}
+	// The length scale is zero by default.
+	ss := &GP{Simil: Matern32{}, Noise: kernel.UniformNoise}
+	if err := ss.Absorb(x, y); err == nil {
+		t.Errorf("no error for zero parameters")
+	}
+	ss = &GP{Simil: Matern52{}, Noise: kernel.UniformNoise,
+		ThetaSimil: []float64{1, 1e-200}, ThetaNoise: []float64{0.1}}
+	if err := ss.Absorb(x, y); err == nil {
+		t.Errorf("no error for an infinite model")
+	}
+	// The log length scale underflows in Observe.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("no panic for an underflowing length scale")
+			}
+		}()
+		ss.Observe([]float64{0, -800, 0})
+	}()
+}
+
+func TestConcurrent(t *testing.T) {
+	// The state-space GPs and the dense ones share the tape of
+	// the noise kernel.
+	x := [][]float64{{0.1}, {0.4}, {0.5}, {1.3}, {0.9}, {2.0}, {2.1}}
+	y := []float64{0.5, 1, 0.8, -0.2, 0.1, -1, -0.7}
+	thetaSimil, thetaNoise := []float64{1.5, 0.7}, []float64{0.3}
+	lml := func(dense bool) float64 {
+		if dense {
+			g := &gp.GP{
+				NDim:       1,
+				Simil:      scaled{matern32},
+				Noise:      kernel.UniformNoise,
+				ThetaSimil: thetaSimil,
+				ThetaNoise: thetaNoise,
+			}
+			if err := g.Absorb(x, y); err != nil {
+				t.Error(err)
+			}
+			return g.LML()
+		}
+		ss := &GP{Simil: Matern32{}, Noise: kernel.UniformNoise, X: x, Y: y}
+		var theta []float64
+		for _, th := range append(thetaSimil, thetaNoise...) {
+			theta = append(theta, math.Log(th))
+		}
+		return ss.Observe(theta)
+	}
+	want := lml(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i != 6; i++ {
+		wg.Add(1)
+		go func(dense bool) {
+			defer wg.Done()
+			for j := 0; j != 5; j++ {
+				if got := lml(dense); math.Abs(got-want) > 1e-6 {
+					t.Errorf("dense=%v: wrong LML: got %f, want %f",
+						dense, got, want)
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
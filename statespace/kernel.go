@@ -0,0 +1,358 @@
+package statespace
+
+import (
+	"bitbucket.org/dtolpin/gogp/kernel/ad"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// Type Kernel is a stationary kernel of a single input with
+// an exact (or approximate) representation as a linear
+// stochastic differential equation
+//   ds/dt = F s + L w,  f(t) = H s(t),
+// with stationary state covariance Pinf.
+type Kernel interface {
+	NTheta() int
+	Names() []string
+	// Dim returns the dimension of the state.
+	Dim() int
+	// SDE computes F, H and Pinf for parameters theta, as well
+	// as the derivatives of F and Pinf by each of the parameters.
+	SDE(theta []float64) (
+		F, Pinf *mat.Dense, H []float64,
+		dF, dPinf []*mat.Dense,
+	)
+}
+
+// Type Matern32 is the Matern(nu=3/2) kernel with two parameters,
+// the output scale c and the length scale l, corresponding to
+// c*kernel.Matern32.
+type Matern32 struct{}
+
+func (Matern32) NTheta() int { return 2 }
+
+func (Matern32) Names() []string {
+	return append([]string{"c"}, kernel.Matern32.Names()...)
+}
+
+func (Matern32) String() string { return "Matern32" }
+
+func (Matern32) Dim() int { return 2 }
+
+func (Matern32) SDE(theta []float64) (
+	F, Pinf *mat.Dense, H []float64,
+	dF, dPinf []*mat.Dense,
+) {
+	c, l := theta[0], theta[1]
+	lambda := math.Sqrt(3) / l
+	dlambda := -lambda / l
+
+	F = mat.NewDense(2, 2, []float64{
+		0, 1,
+		-lambda * lambda, -2 * lambda,
+	})
+	Pinf = mat.NewDense(2, 2, []float64{
+		c, 0,
+		0, lambda * lambda * c,
+	})
+	H = []float64{1, 0}
+
+	dF = []*mat.Dense{
+		mat.NewDense(2, 2, nil),
+		mat.NewDense(2, 2, []float64{
+			0, 0,
+			-2 * lambda * dlambda, -2 * dlambda,
+		}),
+	}
+	dPinf = []*mat.Dense{
+		mat.NewDense(2, 2, []float64{
+			1, 0,
+			0, lambda * lambda,
+		}),
+		mat.NewDense(2, 2, []float64{
+			0, 0,
+			0, 2 * lambda * dlambda * c,
+		}),
+	}
+	return F, Pinf, H, dF, dPinf
+}
+
+// Type Matern52 is the Matern(nu=5/2) kernel with two parameters,
+// the output scale c and the length scale l:
+//   c (1 + √5 d + 5/3 d^2) exp(-√5 d), d = |xa - xb|/l.
+type Matern52 struct{}
+
+func (Matern52) NTheta() int { return 2 }
+
+func (Matern52) Names() []string {
+	return append([]string{"c"}, kernel.Matern52.Names()...)
+}
+
+func (Matern52) String() string { return "Matern52" }
+
+func (Matern52) Dim() int { return 3 }
+
+func (Matern52) SDE(theta []float64) (
+	F, Pinf *mat.Dense, H []float64,
+	dF, dPinf []*mat.Dense,
+) {
+	c, l := theta[0], theta[1]
+	lambda := math.Sqrt(5) / l
+	dlambda := -lambda / l
+	lambda2 := lambda * lambda
+	kappa := lambda2 * c / 3
+	dkappa := 2 * lambda * dlambda * c / 3
+
+	F = mat.NewDense(3, 3, []float64{
+		0, 1, 0,
+		0, 0, 1,
+		-lambda2 * lambda, -3 * lambda2, -3 * lambda,
+	})
+	Pinf = mat.NewDense(3, 3, []float64{
+		c, 0, -kappa,
+		0, kappa, 0,
+		-kappa, 0, lambda2 * lambda2 * c,
+	})
+	H = []float64{1, 0, 0}
+
+	dF = []*mat.Dense{
+		mat.NewDense(3, 3, nil),
+		mat.NewDense(3, 3, []float64{
+			0, 0, 0,
+			0, 0, 0,
+			-3 * lambda2 * dlambda, -6 * lambda * dlambda, -3 * dlambda,
+		}),
+	}
+	dPinf = []*mat.Dense{
+		mat.NewDense(3, 3, []float64{
+			1, 0, -kappa / c,
+			0, kappa / c, 0,
+			-kappa / c, 0, lambda2 * lambda2,
+		}),
+		mat.NewDense(3, 3, []float64{
+			0, 0, -dkappa,
+			0, dkappa, 0,
+			-dkappa, 0, 4 * lambda2 * lambda * dlambda * c,
+		}),
+	}
+	return F, Pinf, H, dF, dPinf
+}
+
+// Type QuasiPeriodic is the product of the periodic and the
+// Matern(nu=3/2) kernels, with four parameters: the output
+// scale c, the length scale l and the period p of the periodic
+// kernel, and the length scale of the Matern kernel,
+// corresponding to c*kernel.Periodic*kernel.Matern32. The
+// periodic kernel is approximated by the first Harmonics
+// harmonics of its Fourier series (Solin and Särkkä, 2014);
+// 6 harmonics are used by default. The approximation is poor
+// for the periodic length scale much less than 0.1.
+type QuasiPeriodic struct {
+	Harmonics int
+}
+
+func (QuasiPeriodic) NTheta() int { return 4 }
+
+func (QuasiPeriodic) Names() []string {
+	names := []string{"c"}
+	names = append(names, kernel.Prefix("periodic", kernel.Periodic.Names())...)
+	return append(names, kernel.Prefix("matern32", kernel.Matern32.Names())...)
+}
+
+func (QuasiPeriodic) String() string { return "QuasiPeriodic" }
+
+// defaultHarmonics is the default number of harmonics of
+// the periodic kernel.
+const defaultHarmonics = 6
+
+func (k QuasiPeriodic) harmonics() int {
+	if k.Harmonics > 0 {
+		return k.Harmonics
+	}
+	return defaultHarmonics
+}
+
+func (k QuasiPeriodic) Dim() int { return 4 * (k.harmonics() + 1) }
+
+func (k QuasiPeriodic) SDE(theta []float64) (
+	F, Pinf *mat.Dense, H []float64,
+	dF, dPinf []*mat.Dense,
+) {
+	c, lp, p, lm := theta[0], theta[1], theta[2], theta[3]
+
+	// Periodic part, a sum of resonators. The
+	// coefficient of harmonic j is q_j^2 = 2 I_j(z) exp(-z),
+	// z = 1/lp^2, for j > 0 and I_0(z) exp(-z) for j = 0.
+	nh := k.harmonics() + 1
+	omega := 2 * math.Pi / p
+	domega := -omega / p
+	z := 1 / (lp * lp)
+	dz := -2 * z / lp
+	Fp := mat.NewDense(2*nh, 2*nh, nil)
+	dFp := mat.NewDense(2*nh, 2*nh, nil)
+	Pp := mat.NewDense(2*nh, 2*nh, nil)
+	dPp := mat.NewDense(2*nh, 2*nh, nil)
+	Hp := make([]float64, 2*nh)
+	for j := 0; j != nh; j++ {
+		q2, dq2 := besselIe(j, z)
+		if j > 0 {
+			q2, dq2 = 2*q2, 2*dq2
+		}
+		Fp.Set(2*j, 2*j+1, -float64(j)*omega)
+		Fp.Set(2*j+1, 2*j, float64(j)*omega)
+		dFp.Set(2*j, 2*j+1, -float64(j)*domega)
+		dFp.Set(2*j+1, 2*j, float64(j)*domega)
+		Pp.Set(2*j, 2*j, q2)
+		Pp.Set(2*j+1, 2*j+1, q2)
+		dPp.Set(2*j, 2*j, dq2*dz)
+		dPp.Set(2*j+1, 2*j+1, dq2*dz)
+		Hp[2*j] = 1
+	}
+
+	// Matern part, carries the output scale
+	Fm, Pm, Hm, dFm, dPm := Matern32{}.SDE([]float64{c, lm})
+
+	// The product kernel is the Kronecker product of the
+	// state-space models.
+	Ip := eye(2 * nh)
+	Im := eye(2)
+	zero := mat.NewDense(2*nh, 2*nh, nil)
+
+	F = kron(Fp, Im)
+	F.Add(F, kron(Ip, Fm))
+	Pinf = kron(Pp, Pm)
+	H = make([]float64, 0, len(Hp)*len(Hm))
+	for i := range Hp {
+		for j := range Hm {
+			H = append(H, Hp[i]*Hm[j])
+		}
+	}
+	dF = []*mat.Dense{
+		kron(zero, Im),   // c
+		kron(zero, Im),   // lp
+		kron(dFp, Im),    // p
+		kron(Ip, dFm[1]), // lm
+	}
+	dPinf = []*mat.Dense{
+		kron(Pp, dPm[0]), // c
+		kron(dPp, Pm),    // lp
+		kron(zero, Pm),   // p
+		kron(Pp, dPm[1]), // lm
+	}
+	return F, Pinf, H, dF, dPinf
+}
+
+// besselIe computes the exponentially scaled modified Bessel
+// function of the first kind exp(-z) I_j(z), and its derivative
+// by z, from the power series, summed in the log space.
+func besselIe(j int, z float64) (ie, die float64) {
+	in := func(j int) float64 {
+		if j < 0 {
+			j = -j
+		}
+		logz2 := math.Log(z / 2)
+		sum := 0.
+		for k := 0; ; k++ {
+			lgk, _ := math.Lgamma(float64(k + 1))
+			lgkj, _ := math.Lgamma(float64(k + j + 1))
+			term := math.Exp(float64(2*k+j)*logz2 - lgk - lgkj - z)
+			sum += term
+			// The terms grow while k^2 < z^2/4, then decrease
+			if float64(k) > z/2 && term < 1e-16*sum {
+				break
+			}
+		}
+		return sum
+	}
+	ie = in(j)
+	// I_j'(z) = (I_{j-1}(z) + I_{j+1}(z))/2
+	die = 0.5*(in(j-1)+in(j+1)) - ie
+	return ie, die
+}
+
+// kron returns the Kronecker product of a and b.
+func kron(a, b mat.Matrix) *mat.Dense {
+	var ab mat.Dense
+	ab.Kronecker(a, b)
+	return &ab
+}
+
+// eye returns the n×n identity matrix.
+func eye(n int) *mat.Dense {
+	m := mat.NewDense(n, n, nil)
+	for i := 0; i != n; i++ {
+		m.Set(i, i, 1)
+	}
+	return m
+}
+
+// Type Sum is the sum of kernels. The parameters of the sum are
+// the concatenated parameters of the kernels; the names of the
+// parameters are prefixed by the kernels' indices in the sum.
+type Sum []Kernel
+
+func (s Sum) NTheta() int {
+	ntheta := 0
+	for _, k := range s {
+		ntheta += k.NTheta()
+	}
+	return ntheta
+}
+
+func (s Sum) Names() []string {
+	var names []string
+	for i, k := range s {
+		names = append(names, kernel.Prefix(fmt.Sprint(i), k.Names())...)
+	}
+	return names
+}
+
+func (s Sum) String() string {
+	str := ""
+	for i, k := range s {
+		if i > 0 {
+			str += " + "
+		}
+		str += fmt.Sprint(k)
+	}
+	return str
+}
+
+func (s Sum) Dim() int {
+	dim := 0
+	for _, k := range s {
+		dim += k.Dim()
+	}
+	return dim
+}
+
+func (s Sum) SDE(theta []float64) (
+	F, Pinf *mat.Dense, H []float64,
+	dF, dPinf []*mat.Dense,
+) {
+	// The state of the sum is the concatenation of the
+	// states of the kernels, the matrices are block-diagonal.
+	dim := s.Dim()
+	F = mat.NewDense(dim, dim, nil)
+	Pinf = mat.NewDense(dim, dim, nil)
+	off := 0
+	for _, k := range s {
+		d := k.Dim()
+		kF, kPinf, kH, kdF, kdPinf := k.SDE(theta[:k.NTheta()])
+		theta = theta[k.NTheta():]
+		F.Slice(off, off+d, off, off+d).(*mat.Dense).Copy(kF)
+		Pinf.Slice(off, off+d, off, off+d).(*mat.Dense).Copy(kPinf)
+		H = append(H, kH...)
+		for i := range kdF {
+			dFi := mat.NewDense(dim, dim, nil)
+			dFi.Slice(off, off+d, off, off+d).(*mat.Dense).Copy(kdF[i])
+			dF = append(dF, dFi)
+			dPinfi := mat.NewDense(dim, dim, nil)
+			dPinfi.Slice(off, off+d, off, off+d).(*mat.Dense).Copy(kdPinf[i])
+			dPinf = append(dPinf, dPinfi)
+		}
+		off += d
+	}
+	return F, Pinf, H, dF, dPinf
+}
@@ -22,6 +22,11 @@ func (simil) Observe(x []float64) float64 {
 
 func (simil) NTheta() int { return 2 }
 
+func (simil) Names() []string {
+	return append([]string{"c"},
+		kernel.Prefix("matern52", kernel.Matern52.Names())...)
+}
+
 // The noise kernel, allocates a single parameter,
 // which is used to define the noise in the priors.
 type noise struct{}
@@ -33,3 +38,5 @@ func (n noise) Observe(x []float64) float64 {
 }
 
 func (noise) NTheta() int { return 1 }
+
+func (noise) Names() []string { return []string{"s"} }
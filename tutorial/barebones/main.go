@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bitbucket.org/dtolpin/gogp/expr"
 	. "bitbucket.org/dtolpin/gogp/gp"
 	. "bitbucket.org/dtolpin/gogp/tutorial"
-	. "bitbucket.org/dtolpin/gogp/tutorial/barebones/kernel/ad"
 	"bitbucket.org/dtolpin/infergo/ad"
 	"flag"
 	"fmt"
@@ -43,14 +43,19 @@ func main() {
 		panic("usage")
 	}
 
+	// The similarity kernel is just a scaled Matern32. The
+	// noise is scaled by 0.01, the `prior', or rather the
+	// starting search point for input noise. We might modify
+	// the initial point instead.
+	simil, noise, err := expr.Parse("c*Matern32 + 0.01*UniformNoise(s)")
+	if err != nil {
+		panic(err)
+	}
 	gp := &GP{
 		NDim:     1,
-		Simil:    Simil,
-		Noise:    Noise(0.01),
+		Simil:    simil,
+		Noise:    noise,
 		Parallel: ad.IsMTSafe(),
-		// 0.01 is the `prior', or rather the starting search
-		// point for input noise; see kernel/kernel.go for
-		// details. We might modify the initial point instead.
 	}
 	fmt.Println(ad.IsMTSafe())
 	theta := make([]float64, gp.Simil.NTheta()+gp.Noise.NTheta())
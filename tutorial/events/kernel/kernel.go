@@ -6,7 +6,9 @@ import (
 
 // The similarity kernel. When two points are on different sides
 // of an event boundary, the similarity between the points is
-// scaled down by the event's discount factor.
+// scaled down by the event's discount factor. Events without
+// a fixed discount factor share a discount factor inferred as
+// a hyperparameter in the interval (0, 1).
 type Simil struct {
 	Events [][]float64
 }
@@ -16,6 +18,7 @@ func (s *Simil) Observe(x []float64) float64 {
 	const (
 		c = iota
 		l
+		d
 		a
 		b
 	)
@@ -27,7 +30,7 @@ func (s *Simil) Observe(x []float64) float64 {
 		discount
 	)
 
-	k := x[c] * kernel.Matern52.Observe(x[l:])
+	k := x[c] * kernel.Matern52.Cov(x[l], x[a], x[b])
 
 	// Discount similarities crossing event boundaries
 	xa, xb := x[a], x[b]
@@ -38,14 +41,30 @@ func (s *Simil) Observe(x []float64) float64 {
 		e := s.Events[i]
 		if xa < e[from] && e[from] <= xb ||
 			xa < e[to] && e[to] <= xb {
-			k *= e[discount]
+			if len(e) > discount {
+				k *= e[discount]
+			} else {
+				k *= x[d]
+			}
 			break
 		}
 	}
 	return k
 }
 
-func (*Simil) NTheta() int { return 2 }
+func (*Simil) NTheta() int { return 3 }
+
+func (*Simil) Names() []string {
+	names := []string{"c"}
+	names = append(names, kernel.Prefix("matern52", kernel.Matern52.Names())...)
+	return append(names, "discount")
+}
+
+// The output scale and the length scale are positive, the
+// discount factor is between 0 and 1.
+func (*Simil) Transforms() []kernel.Transform {
+	return []kernel.Transform{kernel.Log, kernel.Log, kernel.Logit{Lo: 0, Hi: 1}}
+}
 
 // The noise kernel, uniform noise scaled by a likely value.
 // Scaling is tantamount to specifying an initial
@@ -58,3 +77,5 @@ func (n Noise) Observe(x []float64) float64 {
 }
 
 func (Noise) NTheta() int { return 1 }
+
+func (Noise) Names() []string { return kernel.UniformNoise.Names() }
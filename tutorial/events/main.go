@@ -29,8 +29,9 @@ to demonstrate basic functionality.
 		flag.PrintDefaults()
 	}
 	flag.StringVar(&EVENTS, "events", EVENTS,
-		"comma separated colon connected event list \"from:to:discount,...\", "+
-			"for example \"1.:2.5:0.3,3:6:0.5\"")
+		"comma separated colon connected event list \"from:to[:discount],...\", "+
+			"for example \"1.:2.5:0.3,3:6\"; when the discount is omitted, "+
+			"it is inferred")
 }
 
 func main() {
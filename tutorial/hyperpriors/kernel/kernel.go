@@ -26,6 +26,13 @@ func (simil) Observe(x []float64) float64 {
 
 func (simil) NTheta() int { return 5 }
 
+func (simil) Names() []string {
+	names := []string{"trend.c", "season.c"}
+	names = append(names, kernel.Prefix("trend", kernel.Matern52.Names())...)
+	names = append(names, kernel.Prefix("season", kernel.Periodic.Names())...)
+	return names
+}
+
 // The noise kernel.
 type noise struct{}
 
@@ -36,3 +43,5 @@ func (n noise) Observe(x []float64) float64 {
 }
 
 func (noise) NTheta() int { return 1 }
+
+func (noise) Names() []string { return kernel.UniformNoise.Names() }
@@ -0,0 +1,173 @@
+package tutorial
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Output formats
+//
+// By default, Evaluate writes unlabeled CSV rows: the inputs,
+// the output, the predictive mean and standard deviation, the
+// log marginal likelihoods before and after optimization, and
+// the hyperparameters; out-of-sample rows hold the inputs, nan,
+// and the mean and standard deviation. Structured formats,
+// CSV with a header and JSON Lines, write the same fields for
+// all rows, by name, along with the status of the optimization;
+// JSON Lines also hold the joint covariances of out-of-sample
+// forecasts, a row of the covariance matrix per forecast.
+
+// Statuses of the optimization of hyperparameters
+const (
+	Converged = "converged" // the optimizer converged
+	Stopped   = "stopped"   // stopped early, after enough iterations
+	Stuck     = "stuck"     // stopped too early, see MINITERS
+	Skipped   = "skipped"   // too few observations, see MINOPT
+	Fixed     = "fixed"     // out-of-sample, not optimized
+)
+
+// Type Record is a forecast of Evaluate.
+type Record struct {
+	Index       int       `json:"index"`       // of the forecast point
+	OutOfSample bool      `json:"outofsample"` // see OUTOFSAMPLE
+	X           []float64 `json:"x"`
+	Y           Number    `json:"y"` // NaN out of sample
+	Mu          Number    `json:"mu"`
+	Sigma       Number    `json:"sigma"`
+	LML0        Number    `json:"lml0"` // before optimization
+	LML         Number    `json:"lml"`  // after optimization
+	Names       []string  `json:"names"`
+	Params      []Number  `json:"params"`        // hyperparameters, transformed
+	Cov         []Number  `json:"cov,omitempty"` // with the other out-of-sample forecasts
+	Iterations  int       `json:"iterations"`    // of the optimizer
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Type Number is a number written in JSON as null when it is not
+// finite.
+type Number float64
+
+func (x Number) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(x))
+}
+
+// Type recorder writes the records in an output format.
+type recorder interface {
+	Write(r *Record) error
+	Flush() error
+}
+
+// newRecorder returns the recorder for the format.
+func newRecorder(format string, w io.Writer) (recorder, error) {
+	switch format {
+	case "plain":
+		return &plainRecorder{w: w}, nil
+	case "csv":
+		return &csvRecorder{w: csv.NewWriter(w)}, nil
+	case "jsonl":
+		return &jsonRecorder{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// Type plainRecorder writes unlabeled CSV rows.
+type plainRecorder struct {
+	w io.Writer
+}
+
+func (p *plainRecorder) Write(r *Record) error {
+	for j := range r.X {
+		if _, err := fmt.Fprintf(p.w, "%f,", r.X[j]); err != nil {
+			return err
+		}
+	}
+	if r.OutOfSample {
+		_, err := fmt.Fprintf(p.w, "nan,%f,%f\n", r.Mu, r.Sigma)
+		return err
+	}
+	if _, err := fmt.Fprintf(p.w, "%f,%f,%f,%f,%f",
+		r.Y, r.Mu, r.Sigma, r.LML0, r.LML); err != nil {
+		return err
+	}
+	for _, x := range r.Params {
+		if _, err := fmt.Fprintf(p.w, ",%f", x); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(p.w)
+	return err
+}
+
+func (p *plainRecorder) Flush() error {
+	return nil
+}
+
+// Type csvRecorder writes CSV with a header.
+type csvRecorder struct {
+	w      *csv.Writer
+	header bool // true when the header is written
+}
+
+func (c *csvRecorder) Write(r *Record) error {
+	if !c.header {
+		c.header = true
+		header := []string{"index", "outofsample"}
+		for j := range r.X {
+			header = append(header, fmt.Sprintf("x%d", j+1))
+		}
+		header = append(header, "y", "mu", "sigma", "lml0", "lml")
+		header = append(header, r.Names...)
+		header = append(header, "iterations", "status", "error")
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	row := []string{strconv.Itoa(r.Index), strconv.FormatBool(r.OutOfSample)}
+	for _, x := range r.X {
+		row = append(row, format(Number(x)))
+	}
+	for _, x := range []Number{r.Y, r.Mu, r.Sigma, r.LML0, r.LML} {
+		row = append(row, format(x))
+	}
+	for _, x := range r.Params {
+		row = append(row, format(x))
+	}
+	row = append(row, strconv.Itoa(r.Iterations), r.Status, r.Error)
+	return c.w.Write(row)
+}
+
+func (c *csvRecorder) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// format formats a number for CSV output; numbers which are not
+// finite are empty.
+func format(x Number) string {
+	if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+		return ""
+	}
+	return strconv.FormatFloat(float64(x), 'g', -1, 64)
+}
+
+// Type jsonRecorder writes JSON Lines, a JSON object per line.
+type jsonRecorder struct {
+	enc *json.Encoder
+}
+
+func (j *jsonRecorder) Write(r *Record) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonRecorder) Flush() error {
+	return nil
+}
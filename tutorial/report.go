@@ -0,0 +1,118 @@
+package tutorial
+
+import (
+	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/report"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Report
+//
+// When REPORT is set, Evaluate writes an HTML report of the run,
+// with the figures inline: the observations, the one-step
+// forecasts, the posterior at the last step and the out-of-sample
+// forecasts against the first input; the hyperparameters over
+// time; and the log marginal likelihoods before and after
+// optimization.
+
+// Level of credible bands in the report
+var LEVEL = 0.95
+
+// Type collector keeps the records written through a recorder,
+// for the report.
+type collector struct {
+	recorder
+	records []*Record
+}
+
+func (c *collector) Write(r *Record) error {
+	c.records = append(c.records, r)
+	return c.recorder.Write(r)
+}
+
+// writeReport writes the report of the records to file path; the
+// posterior is that of gp, with outputs normalized by meany and
+// stdy.
+func writeReport(
+	path string,
+	gp *gp.GP,
+	records []*Record,
+	meany, stdy float64,
+	summary string,
+) error {
+	var in, out []*Record
+	for _, r := range records {
+		if r.OutOfSample {
+			out = append(out, r)
+		} else {
+			in = append(in, r)
+		}
+	}
+	columns := func(rs []*Record) (x, y, mu, sigma []float64) {
+		for _, r := range rs {
+			x = append(x, r.X[0])
+			y = append(y, float64(r.Y))
+			mu = append(mu, float64(r.Mu))
+			sigma = append(sigma, float64(r.Sigma))
+		}
+		return x, y, mu, sigma
+	}
+
+	rep := &report.Report{Title: "GoGP report", Summary: summary}
+	x, y, mu, sigma := columns(in)
+	f := rep.Figure("Forecasts", "x", "y")
+	f.Points("observed", x, y)
+	f.Forecast("one-step forecast", x, mu, sigma, LEVEL)
+	var X [][]float64
+	for _, r := range in {
+		X = append(X, r.X)
+	}
+	if pmu, psigma, err := gp.Produce(X); err == nil {
+		for i := range pmu {
+			pmu[i], psigma[i] = pmu[i]*stdy+meany, psigma[i]*stdy
+		}
+		f.Forecast("posterior", x, pmu, psigma, LEVEL)
+	}
+	if len(out) > 0 {
+		ox, _, omu, osigma := columns(out)
+		f.Forecast("out of sample", ox, omu, osigma, LEVEL)
+	}
+
+	f = rep.Figure("Hyperparameters", "x", "value")
+	for i, name := range gp.Names() {
+		var v []float64
+		for _, r := range in {
+			if i < len(r.Params) {
+				v = append(v, float64(r.Params[i]))
+			} else {
+				v = append(v, math.NaN())
+			}
+		}
+		f.Line(name, x, v)
+	}
+
+	f = rep.Figure("Log marginal likelihood", "x", "LML")
+	var lml0, lml []float64
+	for _, r := range in {
+		lml0 = append(lml0, float64(r.LML0))
+		lml = append(lml, float64(r.LML))
+	}
+	f.Line("before optimization", x, lml0)
+	f.Line("after optimization", x, lml)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := rep.HTML(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Report: %s\n", path)
+	return nil
+}
@@ -1,11 +1,13 @@
 package tutorial
 
 import (
+	"bitbucket.org/dtolpin/gogp/data"
 	"bitbucket.org/dtolpin/gogp/gp"
+	"bitbucket.org/dtolpin/gogp/metrics"
+	"bitbucket.org/dtolpin/gogp/query"
 	"bitbucket.org/dtolpin/infergo/ad"
 	"bitbucket.org/dtolpin/infergo/infer"
 	"bitbucket.org/dtolpin/infergo/model"
-	"encoding/csv"
     "flag"
 	"fmt"
 	"gonum.org/v1/gonum/optimize"
@@ -14,8 +16,7 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"strconv"
-	"time"
+	"strings"
 )
 
 var (
@@ -23,6 +24,8 @@ var (
 	MINOPT    = 0
 	ALG       = "lbfgs"
 	PARALLEL  = false
+	WORKERS   = 0 // number of parallel workers, GOMAXPROCS by default
+	TOEPLITZ  = false
 	ITERS     = 1000 // major iterations
 	MINITERS  = 10   // minimum iterations to accept in lbfgs
 	THRESHOLD = 1e-6 // gradient threshold
@@ -30,18 +33,37 @@ var (
 	NTASKS    = 0
     NONORMALIZE = false
     OUTOFSAMPLE = false
+	QUERY     = "" // out-of-sample query points, see query.Parse
+	FORMAT    = "plain" // output format, see output.go
+	REPORT    = ""      // path of the HTML report, see report.go
+	SEED      = int64(1) // seed of the random source
 )
 
 func init() {
-	rand.Seed(time.Now().UnixNano())
 	flag.StringVar(&ALG, "a", ALG,
 		"optimization algorithm + adam or lbfgs)")
 	flag.BoolVar(&PARALLEL, "p", PARALLEL,
 		"compute covariance in parallel")
+	flag.IntVar(&WORKERS, "w", WORKERS,
+		"number of parallel workers, GOMAXPROCS when 0")
+	flag.BoolVar(&TOEPLITZ, "t", TOEPLITZ,
+		"use Toeplitz solver on regularly spaced inputs,\n"+
+			"the kernels must be stationary")
 	flag.BoolVar(&NONORMALIZE, "n", NONORMALIZE,
 		"normalize outputs")
 	flag.BoolVar(&OUTOFSAMPLE, "o", OUTOFSAMPLE,
-		"forecast out of sample")
+		"forecast out of sample, as far after the data\n"+
+			"as the data span, unless -q is given")
+	flag.StringVar(&QUERY, "q", QUERY,
+		"forecast out of sample at the query points:\n"+
+			"horizon=H[,step=S], grid=FROM:TO:STEP[,...], or file=PATH")
+	flag.StringVar(&FORMAT, "f", FORMAT,
+		"output format: plain, csv (with a header), or jsonl")
+	flag.StringVar(&REPORT, "r", REPORT,
+		"write an HTML report of the run to the file")
+	flag.Int64Var(&SEED, "seed", SEED,
+		"seed of the random source; runs with the same seed\n"+
+			"are identical")
 }
 
 // Evaluate evaluates Gaussian process on CSV data.  One step
@@ -60,18 +82,38 @@ func Evaluate(
 	rdr io.Reader, // data
 	wtr io.Writer, // forecasts
 ) error {
-	if PARALLEL {
-		ad.MTSafeOn()
+	// Parallel mode does not need thread-safe differentiation,
+	// calls to automatically differentiated kernels are
+	// serialized.
+	gp.Parallel = PARALLEL
+	gp.Workers = WORKERS
+	gp.Toeplitz = TOEPLITZ
+
+	rec, err := newRecorder(FORMAT, wtr)
+	if err != nil {
+		return err
+	}
+	var coll *collector
+	if REPORT != "" {
+		coll = &collector{recorder: rec}
+		rec = coll
 	}
-	gp.Parallel = ad.IsMTSafe()
 
 	// Load the data
-	var err error
 	fmt.Fprint(os.Stderr, "loading...")
-	X, Y, err := load(rdr)
+	tab, err := load(rdr)
 	if err != nil {
 		return err
 	}
+	X, Y := tab.X, tab.Y
+	// Query points are parsed before forecasting, to report
+	// errors early.
+	var Z [][]float64
+	if OUTOFSAMPLE || QUERY != "" {
+		if Z, err = queryPoints(tab); err != nil {
+			return err
+		}
+	}
 	fmt.Fprintln(os.Stderr, "done")
 
 	// Normalize Y
@@ -87,7 +129,11 @@ func Evaluate(
 
 	// Forecast one step out of sample, iteratively.
 	// Output data augmented with predictions.
+	fmt.Fprintf(os.Stderr, "Hyperparameters: %s\n",
+		strings.Join(gp.Names(), ", "))
 	fmt.Fprintln(os.Stderr, "Forecasting...")
+	scores := metrics.NewScores(nil, 0)
+	rng := rand.New(rand.NewSource(SEED))
 	for end := 0; end != len(X); end++ {
 		Xi := X[:end]
 		Yi := Y[:end]
@@ -117,13 +163,21 @@ func Evaluate(
 
 		// Randomize the initial values of hyperparameters
 		for i := range theta {
-			x[i] += 0.1 * rand.NormFloat64()
+			x[i] += 0.1 * rng.NormFloat64()
 		}
 
 		// Initial log likelihood
 		lml0 := m.Observe(x)
 		model.DropGradient(m)
 
+		r := &Record{
+			Index:  end,
+			X:      X[end],
+			Y:      Number(Y[end]*stdy + meany),
+			LML0:   Number(lml0),
+			Names:  gp.Names(),
+			Status: Skipped,
+		}
 		if len(gp.X) > MINOPT {
 			switch ALG {
 			case "lbfgs":
@@ -145,9 +199,16 @@ func Evaluate(
 				// of the improvement. However, in pathological
 				// cases even a few iterations do not succeed,
 				// and we want to report that.
-				if err != nil && result.Stats.MajorIterations <= MINITERS {
+				r.Iterations = result.Stats.MajorIterations
+				switch {
+				case err == nil:
+					r.Status = Converged
+				case result.Stats.MajorIterations > MINITERS:
+					r.Status, r.Error = Stopped, err.Error()
+				default:
 					// There was a problem and the optimizer stopped
 					// too early.
+					r.Status, r.Error = Stuck, err.Error()
 					fmt.Fprintf(os.Stderr,
 						"%d: stuck after %d iterations: %v\n",
 						end, result.Stats.MajorIterations, err)
@@ -166,6 +227,12 @@ func Evaluate(
 					}
 					break Epochs
 				}
+				r.Iterations = epoch
+				if epoch == ITERS {
+					r.Status = Stopped
+				} else {
+					r.Status = Converged
+				}
 			}
 		}
 
@@ -174,99 +241,139 @@ func Evaluate(
 		model.DropGradient(m)
 		ad.DropAllTapes()
 
+		r.LML = Number(lml)
+		for i, t := range gp.Transforms() {
+			r.Params = append(r.Params, Number(t.Forward(x[i])))
+		}
+
 		// Forecast
-		Z := X[end : end+1]
-		mu, sigma, err := gp.Produce(Z)
+		mu, sigma, err := gp.Produce(X[end : end+1])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to forecast: %v\n", err)
+			r.Mu, r.Sigma = Number(math.NaN()), Number(math.NaN())
+			r.Error = err.Error()
+		} else {
+			r.Mu = Number(mu[0]*stdy + meany)
+			r.Sigma = Number(sigma[0] * stdy)
+
+			// The trivial forecast is the mean and the standard
+			// deviation of the preceding outputs.
+			var tmean, tstd float64
+			if end > 1 {
+				tmean, tstd = stat.MeanStdDev(Y[:end], nil)
+			}
+			scores.Add(float64(r.Y), float64(r.Mu), float64(r.Sigma),
+				tmean*stdy+meany, tstd*stdy)
 		}
 
 		// Output forecasts
-		z := Z[0]
-		for j := range z {
-			fmt.Fprintf(wtr, "%f,", z[j])
-		}
-		fmt.Fprintf(wtr, "%f,%f,%f,%f,%f",
-			Y[end]*stdy + meany, 
-            mu[0]*stdy + meany,
-            sigma[0]*stdy,
-            lml0, lml)
-		for i := 0; i != len(theta); i++ {
-			fmt.Fprintf(wtr, ",%f", math.Exp(x[i]))
+		if err := rec.Write(r); err != nil {
+			return err
 		}
-		fmt.Fprintln(wtr)
 	}
 
-    if OUTOFSAMPLE {
-        Z := make([][]float64,  len(X))
-        for i := range Z {
-            Z[i] = make([]float64, len(X[0]))
-            copy(Z[i], X[i])
-            for j := range Z[i] {
-                Z[i][j] += X[len(X)-1][j]
-            }
-        }
-        Z = Z[1:]
-
-        mu, sigma, err := gp.Produce(Z)
-        if err != nil {
-            fmt.Fprintf(os.Stderr, "Failed to forecast: %v\n", err)
-        }
-
-        // Output forecasts
-        for i := range Z {
-            z := Z[i]
-            for j := range z {
-                fmt.Fprintf(wtr, "%f,", z[j])
-            }
-            fmt.Fprintf(wtr, "nan,%f,%f", mu[i]*stdy + meany, sigma[i]*stdy)
-            fmt.Fprintln(wtr)
-        }
+	if Z != nil {
+		if err := outOfSample(gp, rec, Z, len(X), meany, stdy); err != nil {
+			return err
+		}
+	}
+    if err := rec.Flush(); err != nil {
+        return err
     }
 
 	fmt.Fprintln(os.Stderr, "done")
+	fmt.Fprintf(os.Stderr, "Kernel: %v\n", gp)
+	fmt.Fprintf(os.Stderr, "Scores of one-step forecasts:\n%v",
+		scores.Summary())
+
+	if coll != nil {
+		summary := fmt.Sprintf("Kernel: %v\n\nScores of one-step forecasts:\n%v",
+			gp, scores.Summary())
+		if err := writeReport(REPORT, gp, coll.records,
+			meany, stdy, summary); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-// load parses the data from csv and returns inputs and outputs,
-// suitable for feeding to the GP.
-func load(rdr io.Reader) (
-	x [][]float64,
-	y []float64,
-	err error,
-) {
-	csv := csv.NewReader(rdr)
-RECORDS:
-	for {
-		record, err := csv.Read()
-		switch err {
-		case nil:
-			// record contains the data
-			xi := make([]float64, len(record)-1)
-			i := 0
-			for ; i != len(record)-1; i++ {
-				xi[i], err = strconv.ParseFloat(record[i], 64)
-				if err != nil {
-					// data error
-					return x, y, err
-				}
-			}
-			yi, err := strconv.ParseFloat(record[i], 64)
-			if err != nil {
-				// data error
-				return x, y, err
-			}
-			x = append(x, xi)
-			y = append(y, yi)
-		case io.EOF:
-			// end of file
-			break RECORDS
-		default:
-			// i/o error
-			return x, y, err
+// load parses the data from csv into inputs and outputs,
+// suitable for feeding to the GP. The last column is the output;
+// timestamps are turned into days, and rows with missing values
+// are skipped.
+func load(rdr io.Reader) (*data.Table, error) {
+	return data.Load(rdr, data.Options{})
+}
+
+// queryPoints returns the query points of out-of-sample
+// forecasts, see QUERY and OUTOFSAMPLE.
+func queryPoints(tab *data.Table) ([][]float64, error) {
+	X := tab.X
+	spec := QUERY
+	if spec == "" {
+		// As far after the data as the data span, with the
+		// average spacing of the data.
+		if len(X) < 2 {
+			return nil, fmt.Errorf("%d points, too few to forecast "+
+				"out of sample without a query", len(X))
 		}
+		horizon := X[len(X)-1][0] - X[0][0]
+		spec = fmt.Sprintf("horizon=%v,step=%v",
+			horizon, horizon/float64(len(X)-1))
+	}
+	return query.Parse(spec, X[len(X)-1],
+		data.Options{Origin: tab.Origin, Unit: tab.Unit})
+}
+
+// outOfSample forecasts at query points Z after n data points,
+// with the hyperparameters of the last step, and writes the
+// forecasts along with their joint covariances.
+func outOfSample(
+	gp *gp.GP,
+	rec recorder,
+	Z [][]float64,
+	n int,
+	meany, stdy float64,
+) error {
+	mu, cov, err := gp.ProduceCov(Z)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to forecast: %v\n", err)
 	}
 
-	return x, y, err
+	var params []Number
+	for _, theta := range [][]float64{gp.ThetaSimil, gp.ThetaNoise} {
+		for _, v := range theta {
+			params = append(params, Number(v))
+		}
+	}
+	for i := range Z {
+		r := &Record{
+			Index:       n + i,
+			OutOfSample: true,
+			X:           Z[i],
+			Y:           Number(math.NaN()),
+			Mu:          Number(math.NaN()),
+			Sigma:       Number(math.NaN()),
+			LML0:        Number(math.NaN()),
+			LML:         Number(math.NaN()),
+			Names:       gp.Names(),
+			Params:      params,
+			Status:      Fixed,
+		}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Mu = Number(mu[i]*stdy + meany)
+			r.Sigma = Number(math.Sqrt(cov.At(i, i)) * stdy)
+			r.Cov = make([]Number, len(Z))
+			for j := range Z {
+				r.Cov[j] = Number(cov.At(i, j) * stdy * stdy)
+			}
+		}
+		if err := rec.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bitbucket.org/dtolpin/gogp/expr"
 	. "bitbucket.org/dtolpin/gogp/gp"
 	. "bitbucket.org/dtolpin/gogp/tutorial"
-	. "bitbucket.org/dtolpin/gogp/tutorial/warpedtime/kernel/ad"
 	. "bitbucket.org/dtolpin/gogp/tutorial/warpedtime/model/ad"
 	"flag"
 	"fmt"
@@ -72,10 +72,15 @@ func main() {
 		panic("usage")
 	}
 
+	// The noise is scaled by 0.01, see the priors in the model.
+	simil, noise, err := expr.Parse("c*Matern52 + 0.01*UniformNoise(s)")
+	if err != nil {
+		panic(err)
+	}
 	gp := &GP{
 		NDim:  1,
-		Simil: Simil,
-		Noise: Noise,
+		Simil: simil,
+		Noise: noise,
 	}
 	m := &WarpedTime{
 		&Model{